@@ -16,6 +16,7 @@ type Configuration struct {
 	Restic           *ResticConfig
 	Gocrypt          []GocryptConfig
 	Git              []GitConfig
+	Template         []TemplateConfig
 	VaultConfig      *vault.Config
 	DB               *badger.DB
 	Hostname         string
@@ -24,11 +25,29 @@ type Configuration struct {
 	PathDB           string
 	TimeBetweenStart time.Duration
 	Timer            *time.Timer
+	WatchdogTimer    *time.Timer
+	TokenTimer       *time.Timer
+	WatchdogDuration time.Duration
 	MountAllow       bool
 	MountDuration    string
+	FsckDuration     time.Duration
 	VaultKeyFile     string
 	RoleID           string
 	SecretID         string
+	WrappedSecretID  string
+	AuthMethod       string
+	TokenFile        string
+	CertRole         string
+	ClientCert       string
+	ClientKey        string
+	CACert           string
+	TLSServerName    string
+	TLSSkipVerify    bool
+	Username         string
+	Password         string
+	Auth             VaultAuth
+	Mounts           MountsConfig
+	DBKey            DBKeyConfig
 	useLogin         bool
 	backup           bool
 }
@@ -37,6 +56,7 @@ type AgentConfig struct {
 	Gocryptfs  string `mapstructure:"gocryptfs"`
 	Restic     string `mapstructure:"restic"`
 	Git        string `mapstructure:"git"`
+	Template   string `mapstructure:"template"`
 	HomeFolder string `mapstructure:"home"`
 }
 
@@ -44,10 +64,15 @@ type GocryptConfig struct {
 	MountPoint    string `mapstructure:"mount-path"`
 	Path          string `mapstructure:"path"`
 	Password      string `mapstructure:"pw"`
-	AllowOther    bool   `mapstructure:"allow"`
+	Type          string `mapstructure:"type"`
+	Allow         *bool  `mapstructure:"allow"`
 	NotEmpty      bool   `mapstructure:"notempty"`
+	Reverse       bool   `mapstructure:"reverse"`
+	Init          bool   `mapstructure:"init"`
 	Duration      string `mapstructure:"duration"`
+	AllowOther    bool
 	MountDuration time.Duration
+	Name          string
 }
 
 type ResticConfig struct {
@@ -57,18 +82,46 @@ type ResticConfig struct {
 	ExcludePath string `mapstructure:"exclude"`
 	SecretKey   string `mapstructure:"secret_key"`
 	AccessKey   string `mapstructure:"access_key"`
+	Reverse     string `mapstructure:"reverse"`
 	Environment []string
 }
 
 type GitConfig struct {
-	Rep           string `mapstructure:"repo"`
-	Directory     string `mapstructure:"dir"`
-	PersonalToken string `mapstructure:"personal_token"`
+	Rep           string   `mapstructure:"repo"`
+	Directory     string   `mapstructure:"dir"`
+	PersonalToken string   `mapstructure:"personal_token"`
+	SSHKey        string   `mapstructure:"ssh_key"`
+	SSHPassphrase string   `mapstructure:"ssh_passphrase"`
+	KnownHosts    string   `mapstructure:"known_hosts"`
+	Branch        string   `mapstructure:"branch"`
+	Tag           string   `mapstructure:"tag"`
+	Commit        string   `mapstructure:"commit"`
+	Dirty         string   `mapstructure:"dirty"`
+	Verify        string   `mapstructure:"verify"`
+	TrustedKeys   string   `mapstructure:"trusted_keys"`
+	Submodules    bool     `mapstructure:"submodules"`
+	Depth         int      `mapstructure:"depth"`
+	SingleBranch  bool     `mapstructure:"single_branch"`
+	Hooks         []string `mapstructure:"hooks"`
+	AuthorName    string   `mapstructure:"author_name"`
+	AuthorEmail   string   `mapstructure:"author_email"`
+	Message       string   `mapstructure:"message"`
+	Strategy      string   `mapstructure:"strategy"`
+	Mode          string   `mapstructure:"mode"`
 	Name          string
 }
 
+type TemplateConfig struct {
+	Source      string `mapstructure:"source"`
+	Destination string `mapstructure:"dest"`
+	Mode        string `mapstructure:"mode"`
+	Owner       string `mapstructure:"owner"`
+	Command     string `mapstructure:"command"`
+	Name        string
+}
+
 func GetGocryptConfig(config *vault.Config, token string, path string) (*GocryptConfig, error) {
-	data, err := getDataFromSecret(config, token, "gocrypt/data/"+path)
+	data, err := ReadKV(config, token, kvMount(AgentConfiguration.Mounts.Gocrypt, MOUNT_GOCRYPT), path)
 	if err != nil {
 		return nil, err
 	}
@@ -97,11 +150,32 @@ func GetGocryptConfig(config *vault.Config, token string, path string) (*Gocrypt
 	if err != nil {
 		return nil, err
 	}
+	if conf.Allow != nil {
+		conf.AllowOther = *conf.Allow
+	}
+	conf.Name = path
 	return &conf, nil
 }
 
+// values set in the vault secret of a volume take precedence over the
+// mount_duration and mount_allow flags of the agent
+func (conf *GocryptConfig) ApplyDefaults(duration string, allow bool) error {
+	if conf.Duration == "" && duration != "" {
+		dur, err := time.ParseDuration(duration)
+		if err != nil {
+			return err
+		}
+		conf.MountDuration = dur
+	}
+
+	if conf.Allow == nil {
+		conf.AllowOther = allow
+	}
+	return nil
+}
+
 func GetAgentConfig(config *vault.Config, token string, path string) (*AgentConfig, error) {
-	data, err := getDataFromSecret(config, token, "config/"+path)
+	data, err := ReadKV(config, token, kvMount(AgentConfiguration.Mounts.Config, MOUNT_CONFIG), path)
 	if err != nil {
 		return nil, err
 	}
@@ -115,8 +189,27 @@ func GetAgentConfig(config *vault.Config, token string, path string) (*AgentConf
 	return &conf, nil
 }
 
+func GetTemplateConfig(config *vault.Config, token string, path string) (*TemplateConfig, error) {
+	data, err := ReadKV(config, token, kvMount(AgentConfiguration.Mounts.Template, MOUNT_TEMPLATE), path)
+	if err != nil {
+		return nil, err
+	}
+
+	var conf TemplateConfig
+	err = mapstructure.Decode(data, &conf)
+	if err != nil {
+		return nil, err
+	}
+	if conf.Source == "" || conf.Destination == "" {
+		return nil, errors.New(ERROR_TEMPLATE_CONFIG + path)
+	}
+
+	conf.Name = path
+	return &conf, nil
+}
+
 func GetGitConfig(config *vault.Config, token string, path string) (*GitConfig, error) {
-	data, err := getDataFromSecret(config, token, "git/data/"+path)
+	data, err := ReadKV(config, token, kvMount(AgentConfiguration.Mounts.Git, MOUNT_GIT), path)
 	if err != nil {
 		return nil, err
 	}
@@ -141,7 +234,7 @@ func GetGitConfig(config *vault.Config, token string, path string) (*GitConfig,
 }
 
 func GetResticConfig(config *vault.Config, token string, path string) (*ResticConfig, error) {
-	data, err := getDataFromSecret(config, token, "restic/data/"+path)
+	data, err := ReadKV(config, token, kvMount(AgentConfiguration.Mounts.Restic, MOUNT_RESTIC), path)
 	if err != nil {
 		return nil, err
 	}
@@ -238,11 +331,37 @@ func (config *Configuration) GetGocryptConfig() error {
 		if err != nil {
 			return err
 		}
+		err = gocrypt.ApplyDefaults(config.MountDuration, config.MountAllow)
+		if err != nil {
+			return err
+		}
 		config.Gocrypt = append(config.Gocrypt, *gocrypt)
 	}
 	return nil
 }
 
+func (config *Configuration) GetReverseConfig() ([]GocryptConfig, error) {
+	var reverse []GocryptConfig
+	if config.Restic == nil || config.Restic.Reverse == "" {
+		return reverse, nil
+	}
+
+	for _, name := range strings.Split(config.Restic.Reverse, ",") {
+		gocrypt, err := GetGocryptConfig(config.VaultConfig, config.Token, name)
+		if err != nil {
+			return nil, err
+		}
+		if !gocrypt.Reverse {
+			return nil, errors.New(ERROR_NOT_REVERSE + name)
+		}
+		if gocrypt.Type != "" && gocrypt.Type != MOUNTER_GOCRYPTFS {
+			return nil, errors.New(ERROR_REVERSE_TYPE + name)
+		}
+		reverse = append(reverse, *gocrypt)
+	}
+	return reverse, nil
+}
+
 func (config *Configuration) GetGitConfig() error {
 	if err := config.VaultReady(); err != nil {
 		return err
@@ -263,6 +382,29 @@ func (config *Configuration) GetGitConfig() error {
 	return nil
 }
 
+// templates are optional, an agent without them renders nothing
+func (config *Configuration) GetTemplateConfig() error {
+	if err := config.VaultReady(); err != nil {
+		return err
+	}
+	err := config.GetAgentConfig()
+	if err != nil {
+		return err
+	}
+	if config.Agent.Template == "" {
+		return nil
+	}
+
+	for _, name := range strings.Split(config.Agent.Template, ",") {
+		tmpl, err := GetTemplateConfig(config.VaultConfig, config.Token, name)
+		if err != nil {
+			return err
+		}
+		config.Template = append(config.Template, *tmpl)
+	}
+	return nil
+}
+
 func CreateConfigFullFromVault(token string, hostname string, vaultConfig *vault.Config) (*Configuration, error) {
 	config, err := CreateConfigFromVault(token, hostname, vaultConfig)
 	if err != nil {
@@ -287,6 +429,33 @@ func CreateConfigFullFromVault(token string, hostname string, vaultConfig *vault
 	return config, nil
 }
 
+// the TLS options end up in the transport of the vault config, so every client
+// built from it shares them
+func (c *Configuration) ConfigureTLS() error {
+	if c.TLSSkipVerify {
+		Sugar.Warn(MAIN_MESSAGE_TLS_SKIP_VERIFY)
+	}
+	err := c.VaultConfig.ConfigureTLS(&vault.TLSConfig{
+		CACert:        c.CACert,
+		ClientCert:    c.ClientCert,
+		ClientKey:     c.ClientKey,
+		TLSServerName: c.TLSServerName,
+		Insecure:      c.TLSSkipVerify,
+	})
+	if err != nil {
+		return errors.New(MAIN_ERROR_TLS + err.Error())
+	}
+	return nil
+}
+
+func parseDBKeyConfig() DBKeyConfig {
+	return DBKeyConfig{
+		KeyFile:    viper.GetString(MAIN_DB_KEY_FILE),
+		Key:        viper.GetString(MAIN_DB_KEY),
+		Passphrase: viper.GetString(MAIN_DB_PASSPHRASE),
+	}
+}
+
 func ParseConfiguration(confi *Configuration) {
 	if viper.IsSet(MAIN_ADDRESS) {
 		confi.Address = viper.GetString(MAIN_ADDRESS)
@@ -324,6 +493,28 @@ func ParseConfiguration(confi *Configuration) {
 		confi.MountAllow = false
 	}
 
+	if viper.IsSet(MAIN_FSCK_DURATION) {
+		dur, err := time.ParseDuration(viper.GetString(MAIN_FSCK_DURATION))
+		if err != nil {
+			Sugar.Error("Error parsing fsck duration: ", err)
+			dur = 24 * time.Hour
+		}
+		confi.FsckDuration = dur
+	} else {
+		confi.FsckDuration = 24 * time.Hour
+	}
+
+	if viper.IsSet(MAIN_WATCHDOG_DURATION) {
+		dur, err := time.ParseDuration(viper.GetString(MAIN_WATCHDOG_DURATION))
+		if err != nil {
+			Sugar.Error("Error parsing watchdog duration: ", err)
+			dur = 30 * time.Second
+		}
+		confi.WatchdogDuration = dur
+	} else {
+		confi.WatchdogDuration = 30 * time.Second
+	}
+
 	if viper.IsSet(MAIN_VAULT_KEY_FILE) {
 		confi.VaultKeyFile = viper.GetString(MAIN_VAULT_KEY_FILE)
 	} else {
@@ -334,18 +525,39 @@ func ParseConfiguration(confi *Configuration) {
 		confi.VaultConfig.Address = viper.GetString(MAIN_VAULT_ADDRESS)
 	}
 
-	if viper.IsSet(MAIN_VAULT_ROLE_ID) {
-		confi.RoleID = viper.GetString(MAIN_VAULT_ROLE_ID)
-	} else {
+	confi.AuthMethod = viper.GetString(MAIN_VAULT_AUTH)
+	confi.RoleID = viper.GetString(MAIN_VAULT_ROLE_ID)
+	confi.SecretID = viper.GetString(MAIN_VAULT_SECRET_ID)
+	confi.WrappedSecretID = viper.GetString(MAIN_VAULT_WRAPPED_SECRET_ID)
+	confi.TokenFile = viper.GetString(MAIN_VAULT_TOKEN_FILE)
+	confi.CertRole = viper.GetString(MAIN_VAULT_CERT_ROLE)
+	confi.ClientCert = viper.GetString(MAIN_VAULT_CLIENT_CERT)
+	confi.ClientKey = viper.GetString(MAIN_VAULT_CLIENT_KEY)
+	confi.CACert = viper.GetString(MAIN_VAULT_CA_CERT)
+	confi.TLSServerName = viper.GetString(MAIN_VAULT_TLS_SERVER_NAME)
+	confi.TLSSkipVerify = viper.GetBool(MAIN_VAULT_TLS_SKIP_VERIFY)
+	confi.Username = viper.GetString(MAIN_VAULT_USERNAME)
+	confi.Password = viper.GetString(MAIN_VAULT_PASSWORD)
+
+	confi.Mounts = MountsConfig{
+		Config:   viper.GetString(MAIN_VAULT_MOUNT_CONFIG),
+		Restic:   viper.GetString(MAIN_VAULT_MOUNT_RESTIC),
+		Gocrypt:  viper.GetString(MAIN_VAULT_MOUNT_GOCRYPT),
+		Git:      viper.GetString(MAIN_VAULT_MOUNT_GIT),
+		Template: viper.GetString(MAIN_VAULT_MOUNT_TEMPLATE),
+		Status:   viper.GetString(MAIN_VAULT_MOUNT_STATUS),
+	}
+
+	confi.DBKey = parseDBKeyConfig()
+
+	auth, err := GetVaultAuth(*confi)
+	if err != nil {
+		Sugar.Error(err)
 		confi.useLogin = false
-	}
-
-	if viper.IsSet(MAIN_VAULT_SECRET_ID) {
-		confi.SecretID = viper.GetString(MAIN_VAULT_SECRET_ID)
 	} else {
-		confi.useLogin = false
+		confi.Auth = auth
 	}
-	
+
 	if viper.IsSet(MAIN_BACKUP) {
 		confi.backup = viper.GetBool(MAIN_BACKUP)
 	} else {
@@ -361,8 +573,12 @@ func ParseConfiguration(confi *Configuration) {
 		"\nVault KeyFile path: ", confi.VaultKeyFile,
 		"\nMount Duration: ", confi.MountDuration,
 		"\nMount AllowOther: ", confi.MountAllow,
+		"\nTime Between Fsck Runs: ", confi.FsckDuration,
+		"\nTime Between Watchdog Checks: ", confi.WatchdogDuration,
+		"\nVault Auth: ", confi.AuthMethod,
+		"\nVault Mounts: ", confi.Mounts,
 		"\nRoleID: ", confi.RoleID,
-		"\nSecretID: ", confi.SecretID,
 		"\nBackup: ", confi.backup,
+		"\nDatabase Encrypted: ", confi.DBKey.IsSet(),
 	)
 }
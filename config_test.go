@@ -169,3 +169,62 @@ func TestConfigParseConfigurations(t *testing.T) {
 	assert.Empty(t, config.VaultKeyFile)
 	assert.False(t, config.MountAllow)
 }
+
+func TestConfigApplyDefaults(t *testing.T) {
+	fmt.Println("running: TestConfigApplyDefaults")
+	allow := true
+	deny := false
+
+	tests := []struct {
+		name     string
+		conf     GocryptConfig
+		duration string
+		allow    bool
+		expDur   time.Duration
+		expAllow bool
+	}{
+		{"global defaults", GocryptConfig{}, "5s", true, 5 * time.Second, true},
+		{"no defaults", GocryptConfig{}, "", false, 0, false},
+		{"volume duration wins", GocryptConfig{Duration: "3s", MountDuration: 3 * time.Second}, "5s", false, 3 * time.Second, false},
+		{"volume allow wins", GocryptConfig{Allow: &deny}, "", true, 0, false},
+		{"volume allow without global", GocryptConfig{Allow: &allow, AllowOther: true}, "", false, 0, true},
+	}
+
+	for _, test := range tests {
+		conf := test.conf
+		err := conf.ApplyDefaults(test.duration, test.allow)
+		assert.NoError(t, err, test.name)
+		assert.Equal(t, test.expDur, conf.MountDuration, test.name)
+		assert.Equal(t, test.expAllow, conf.AllowOther, test.name)
+	}
+
+	conf := GocryptConfig{}
+	err := conf.ApplyDefaults("notaduration", false)
+	assert.Error(t, err)
+}
+
+func TestConfigGetReverseConfig(t *testing.T) {
+	fmt.Println("running: TestConfigGetReverseConfig")
+	t.Cleanup(clear)
+	testconfig := readConfig(t)
+
+	config := Configuration{
+		VaultConfig: testconfig.config,
+		Token:       testconfig.token,
+		Restic:      &ResticConfig{},
+	}
+	reverse, err := config.GetReverseConfig()
+	assert.NoError(t, err)
+	assert.Empty(t, reverse)
+
+	config.Restic.Reverse = "reversepath"
+	reverse, err = config.GetReverseConfig()
+	assert.NoError(t, err)
+	require.Len(t, reverse, 1)
+	assert.True(t, reverse[0].Reverse)
+	assert.Equal(t, GOCRYPT_TEST_REVERSE_MOUNTPATH, reverse[0].MountPoint)
+
+	config.Restic.Reverse = "reversepath," + testconfig.gocryptpath
+	_, err = config.GetReverseConfig()
+	assert.EqualError(t, err, ERROR_NOT_REVERSE+testconfig.gocryptpath)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"math"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type CryfsMounter struct{}
+
+func (CryfsMounter) Mount(home string, conf GocryptConfig) (*exec.Cmd, error) {
+	return MountCryfs(conf.Path, conf.MountPoint, home, conf.MountDuration, conf.Password, conf.AllowOther, conf.NotEmpty), nil
+}
+
+func (CryfsMounter) Unmount(home string, conf GocryptConfig) *exec.Cmd {
+	folder := strings.ReplaceAll(conf.MountPoint, HOME, home)
+	cmd := exec.Command("cryfs-unmount", folder)
+	cmd.Env = os.Environ()
+	return cmd
+}
+
+func (CryfsMounter) Status(home string, conf GocryptConfig) (MountStatus, error) {
+	status, err := fuseStatus(home, conf.MountPoint)
+	status.Initialized = isInitialized(home, conf.Path, CRYFS_CONFIG)
+	return status, err
+}
+
+// cryfs has no integrity check of its own
+func (CryfsMounter) Fsck(home string, conf GocryptConfig) (*exec.Cmd, error) {
+	return nil, nil
+}
+
+// cryfs creates the filesystem on the first mount, so the volume is mounted
+// once and unmounted again
+func (CryfsMounter) Init(home string, conf GocryptConfig) (*exec.Cmd, error) {
+	cryptoDir := strings.ReplaceAll(conf.Path, HOME, home)
+	folder := strings.ReplaceAll(conf.MountPoint, HOME, home)
+
+	cmd := exec.Command("sh", "-c", "cryfs \"$1\" \"$2\" && cryfs-unmount \"$2\"", "sh", cryptoDir, folder)
+	cmd.Env = append(os.Environ(), CRYFS_FRONTEND)
+	cmd.Stdin = strings.NewReader(conf.Password + "\n")
+	return cmd, nil
+}
+
+func MountCryfs(cryptoDir string, folder string, home string, duration time.Duration, pwd string, allowOther bool, notEmpty bool) *exec.Cmd {
+	var options []string
+	if duration.String() != "0s" {
+		// cryfs only knows idle minutes
+		minutes := int(math.Ceil(duration.Minutes()))
+		options = append(options, "--unmount-idle", strconv.Itoa(minutes))
+	}
+
+	cryptoDir = strings.ReplaceAll(cryptoDir, HOME, home)
+	folder = strings.ReplaceAll(folder, HOME, home)
+	options = append(options, cryptoDir, folder)
+
+	var fuseOptions []string
+	if allowOther {
+		fuseOptions = append(fuseOptions, "allow_other")
+	}
+	if notEmpty {
+		fuseOptions = append(fuseOptions, "nonempty")
+	}
+	if len(fuseOptions) > 0 {
+		options = append(options, "--", "-o", strings.Join(fuseOptions, ","))
+	}
+
+	Sugar.Debug("Mounting cryfs: ", folder, " Duration", duration.String(), " AllowOther", allowOther, " NotEmpty", notEmpty)
+	cmd := exec.Command("cryfs", options...)
+	// in the noninteractive frontend cryfs reads the password from stdin
+	cmd.Env = append(os.Environ(), CRYFS_FRONTEND)
+	cmd.Stdin = strings.NewReader(pwd + "\n")
+	return cmd
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCryfsMountCryfs(t *testing.T) {
+	fmt.Println("running: TestCryfsMountCryfs")
+	home, err := os.Getwd()
+	require.NoError(t, err)
+
+	cmd := MountCryfs(GOCRYPT_TEST_FOLDER, GOCRYPT_TEST_MOUNTPATH, home, 0, VAULT_TEST_PASSWORD, false, false)
+	assert.Contains(t, cmd.String(), "cryfs "+home+"/test/tmp "+home+"/test/tmp-mount")
+	assert.NotContains(t, cmd.String(), "--")
+	assert.Contains(t, cmd.Env, CRYFS_FRONTEND)
+
+	cmd = MountCryfs(GOCRYPT_TEST_FOLDER, GOCRYPT_TEST_MOUNTPATH, home, 90*time.Second, VAULT_TEST_PASSWORD, true, true)
+	assert.Contains(t, cmd.String(), "cryfs --unmount-idle 2 "+home+"/test/tmp "+home+"/test/tmp-mount -- -o allow_other,nonempty")
+	assert.NotContains(t, cmd.String(), VAULT_TEST_PASSWORD)
+
+	pwd, err := ioutil.ReadAll(cmd.Stdin)
+	assert.NoError(t, err)
+	assert.Equal(t, VAULT_TEST_PASSWORD+"\n", string(pwd))
+}
+
+func TestCryfsMounter(t *testing.T) {
+	fmt.Println("running: TestCryfsMounter")
+	home, err := os.Getwd()
+	require.NoError(t, err)
+
+	conf := GocryptConfig{
+		Type:       MOUNTER_CRYFS,
+		MountPoint: GOCRYPT_TEST_MOUNTPATH,
+		Path:       GOCRYPT_TEST_FOLDER,
+		Password:   VAULT_TEST_PASSWORD,
+	}
+	mounter := CryfsMounter{}
+
+	cmd := mounter.Unmount(home, conf)
+	assert.Contains(t, cmd.String(), "cryfs-unmount "+home+"/test/tmp-mount")
+
+	cmd, err = mounter.Init(home, conf)
+	require.NoError(t, err)
+	assert.Contains(t, cmd.Args, home+"/test/tmp")
+	assert.Contains(t, cmd.Args, home+"/test/tmp-mount")
+	assert.Contains(t, cmd.Env, CRYFS_FRONTEND)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v3"
+)
+
+type Event struct {
+	Time    time.Time `json:"time"`
+	Level   string    `json:"level"`
+	Source  string    `json:"source"`
+	Message string    `json:"message"`
+}
+
+func RecordEvent(level string, source string, message string) {
+	switch level {
+	case EVENT_LEVEL_ERROR:
+		Sugar.Error(source, ": ", message)
+	case EVENT_LEVEL_WARN:
+		Sugar.Warn(source, ": ", message)
+	default:
+		Sugar.Info(source, ": ", message)
+	}
+
+	event := Event{
+		Time:    time.Now(),
+		Level:   level,
+		Source:  source,
+		Message: message,
+	}
+	_, err := PutEvent(AgentConfiguration.DB, event)
+	if err != nil {
+		Sugar.Debug(ERROR_EVENT, err)
+	}
+}
+
+func PutEvent(db *badger.DB, event Event) (bool, error) {
+	value, err := json.Marshal(event)
+	if err != nil {
+		return false, err
+	}
+	// keys sort by time, the TTL keeps the event log from growing forever
+	key := STORE_EVENT + event.Time.UTC().Format(time.RFC3339Nano)
+	return PutWithTTL(db, key, string(value), EVENT_TTL)
+}
+
+func GetEvents(db *badger.DB, limit int) ([]Event, error) {
+	values, err := GetPrefix(db, STORE_EVENT)
+	if err != nil {
+		return nil, err
+	}
+
+	events := []Event{}
+	for _, v := range values {
+		var event Event
+		err = json.Unmarshal([]byte(v), &event)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+
+	// newest first
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].Time.After(events[j].Time)
+	})
+	if limit > 0 && len(events) > limit {
+		events = events[:limit]
+	}
+	return events, nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventGetEvents(t *testing.T) {
+	fmt.Println("running: TestEventGetEvents")
+	db := InitDB("", "", true)
+	require.NotNil(t, db)
+
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		ok, err := PutEvent(db, Event{
+			Time:    now.Add(time.Duration(i) * time.Second),
+			Level:   EVENT_LEVEL_INFO,
+			Source:  "test",
+			Message: fmt.Sprint(i),
+		})
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	}
+
+	events, err := GetEvents(db, 0)
+	assert.NoError(t, err)
+	require.Len(t, events, 3)
+	assert.Equal(t, "2", events[0].Message)
+	assert.Equal(t, "0", events[2].Message)
+
+	events, err = GetEvents(db, 2)
+	assert.NoError(t, err)
+	require.Len(t, events, 2)
+	assert.Equal(t, "1", events[1].Message)
+
+	err = db.Close()
+	assert.NoError(t, err)
+}
+
+func TestEventRecordEvent(t *testing.T) {
+	fmt.Println("running: TestEventRecordEvent")
+	AgentConfiguration.DB = InitDB("", "", true)
+	require.NotNil(t, AgentConfiguration.DB)
+
+	RecordEvent(EVENT_LEVEL_WARN, "test", "message")
+
+	events, err := GetEvents(AgentConfiguration.DB, EVENT_LIMIT)
+	assert.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, EVENT_LEVEL_WARN, events[0].Level)
+	assert.Equal(t, "message", events[0].Message)
+
+	err = AgentConfiguration.DB.Close()
+	assert.NoError(t, err)
+}
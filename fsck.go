@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os/exec"
+	"strings"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v3"
+)
+
+type FsckResult struct {
+	Time   time.Time `json:"time"`
+	Status string    `json:"status"`
+	Output string    `json:"output"`
+}
+
+func FsckGocryptfs(cryptoDir string, home string, pwd string) (*exec.Cmd, error) {
+	cryptoDir = strings.ReplaceAll(cryptoDir, HOME, home)
+
+	Sugar.Debug("Checking integrity of: ", cryptoDir)
+	return GocryptfsCommand(pwd, []string{"-fsck", "-q"}, cryptoDir)
+}
+
+func RunFsck(home string, conf GocryptConfig) FsckResult {
+	result := FsckResult{
+		Time: time.Now(),
+	}
+
+	mounter, err := GetMounter(conf.Type)
+	if err != nil {
+		result.Status = FSCK_STATUS_FAILED
+		result.Output = err.Error()
+		return result
+	}
+
+	status, err := mounter.Status(home, conf)
+	if err != nil {
+		result.Status = FSCK_STATUS_FAILED
+		result.Output = err.Error()
+		return result
+	}
+	if status.Mounted && !status.ReadOnly {
+		result.Status = FSCK_STATUS_SKIPPED
+		result.Output = FSCK_MESSAGE_MOUNTED
+		return result
+	}
+
+	cmd, err := mounter.Fsck(home, conf)
+	if err != nil {
+		result.Status = FSCK_STATUS_FAILED
+		result.Output = err.Error()
+		return result
+	}
+	if cmd == nil {
+		result.Status = FSCK_STATUS_SKIPPED
+		result.Output = FSCK_MESSAGE_TYPE + conf.Name
+		return result
+	}
+	job := CreateJobFromCommand(cmd, "fsck "+conf.Name)
+	err = job.RunJob(false)
+	result.Output = job.Stdout.String() + job.Stderr.String()
+
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		result.Status = FSCK_STATUS_OK
+	case errors.As(err, &exitErr) && exitErr.ExitCode() == FSCK_EXIT_CORRUPT:
+		result.Status = FSCK_STATUS_CORRUPT
+	default:
+		result.Status = FSCK_STATUS_FAILED
+		if result.Output == "" {
+			result.Output = err.Error()
+		}
+	}
+	return result
+}
+
+func CheckFsckResult(db *badger.DB, name string, result FsckResult) error {
+	previous, err := GetFsckResult(db, name)
+	if err != nil && err != badger.ErrKeyNotFound {
+		return err
+	}
+
+	if result.Status == FSCK_STATUS_CORRUPT && previous.Status != FSCK_STATUS_CORRUPT {
+		RecordEvent(EVENT_LEVEL_ERROR, EVENT_SOURCE_FSCK, FSCK_MESSAGE_CORRUPT+name+"\n"+result.Output)
+	}
+
+	_, err = UpdateFsckResult(db, name, result)
+	return err
+}
+
+func UpdateFsckResult(db *badger.DB, name string, result FsckResult) (bool, error) {
+	value, err := json.Marshal(result)
+	if err != nil {
+		return false, err
+	}
+	return Put(db, STORE_FSCK+name, string(value))
+}
+
+func GetFsckResult(db *badger.DB, name string) (FsckResult, error) {
+	var result FsckResult
+	value, err := Get(db, STORE_FSCK+name)
+	if err != nil {
+		return result, err
+	}
+	err = json.Unmarshal([]byte(value), &result)
+	return result, err
+}
+
+func GetFsckResults(db *badger.DB) (map[string]FsckResult, error) {
+	values, err := GetPrefix(db, STORE_FSCK)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make(map[string]FsckResult)
+	for k, v := range values {
+		var result FsckResult
+		err = json.Unmarshal([]byte(v), &result)
+		if err != nil {
+			return nil, err
+		}
+		results[strings.TrimPrefix(k, STORE_FSCK)] = result
+	}
+	return results, nil
+}
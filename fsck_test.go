@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFsckFsckGocryptfs(t *testing.T) {
+	fmt.Println("running: TestFsckFsckGocryptfs")
+	home, err := os.Getwd()
+	require.NoError(t, err)
+
+	cmd, err := FsckGocryptfs(GOCRYPT_TEST_FOLDER, home, VAULT_TEST_PASSWORD)
+	require.NoError(t, err)
+	assert.Contains(t, cmd.String(), "gocryptfs -fsck -q -passfile /dev/fd/3 "+home+"/test/tmp")
+	assert.NotContains(t, cmd.String(), VAULT_TEST_PASSWORD)
+}
+
+func TestFsckRunFsck(t *testing.T) {
+	fmt.Println("running: TestFsckRunFsck")
+	t.Cleanup(clear)
+	home, err := os.Getwd()
+	require.NoError(t, err)
+
+	conf := GocryptConfig{
+		Name:       "fsck-test",
+		MountPoint: GOCRYPT_TEST_MOUNTPATH,
+		Path:       GOCRYPT_TEST_FOLDER,
+		Password:   VAULT_TEST_PASSWORD,
+	}
+	result := RunFsck(home, conf)
+	assert.Equal(t, FSCK_STATUS_OK, result.Status, result.Output)
+
+	conf.Password = "wrong"
+	result = RunFsck(home, conf)
+	assert.Equal(t, FSCK_STATUS_FAILED, result.Status)
+}
+
+func TestFsckCheckFsckResult(t *testing.T) {
+	fmt.Println("running: TestFsckCheckFsckResult")
+	AgentConfiguration.DB = InitDB("", "", true)
+	require.NotNil(t, AgentConfiguration.DB)
+
+	_, err := GetFsckResult(AgentConfiguration.DB, "volume")
+	assert.Equal(t, badger.ErrKeyNotFound, err)
+
+	result := FsckResult{
+		Time:   time.Now(),
+		Status: FSCK_STATUS_OK,
+	}
+	err = CheckFsckResult(AgentConfiguration.DB, "volume", result)
+	assert.NoError(t, err)
+
+	events, err := GetEvents(AgentConfiguration.DB, 0)
+	assert.NoError(t, err)
+	assert.Empty(t, events)
+
+	result.Status = FSCK_STATUS_CORRUPT
+	err = CheckFsckResult(AgentConfiguration.DB, "volume", result)
+	assert.NoError(t, err)
+
+	// a known corruption is only reported once
+	err = CheckFsckResult(AgentConfiguration.DB, "volume", result)
+	assert.NoError(t, err)
+
+	events, err = GetEvents(AgentConfiguration.DB, 0)
+	assert.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, EVENT_LEVEL_ERROR, events[0].Level)
+	assert.Equal(t, EVENT_SOURCE_FSCK, events[0].Source)
+	assert.Contains(t, events[0].Message, "volume")
+
+	results, err := GetFsckResults(AgentConfiguration.DB)
+	assert.NoError(t, err)
+	require.Contains(t, results, "volume")
+	assert.Equal(t, FSCK_STATUS_CORRUPT, results["volume"].Status)
+
+	err = AgentConfiguration.DB.Close()
+	assert.NoError(t, err)
+}
@@ -1,35 +1,64 @@
 package main
 
 import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
 	"strings"
+	"text/template"
+	"time"
 
 	git "github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
 	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
 )
 
-func GitClone(repo string, dir string, home string, personal string) error {
+func GitClone(v GitConfig, home string, progress io.Writer) error {
 	var r *git.Repository
-	var err error
 
-	dir = strings.ReplaceAll(dir, HOME, home)
-	Sugar.Info("Checkout out to Repo: ", repo)
+	dir := strings.ReplaceAll(v.Directory, HOME, home)
+	Sugar.Info("Checkout out to Repo: ", v.Rep)
 	Sugar.Debug("Checkout out to Dir: ", dir)
 
-	cloneOptions := git.CloneOptions{
-		URL:      repo,
-		Progress: os.Stdout,
+	err := gitCheckRef(v)
+	if err != nil {
+		return err
+	}
+	err = gitCheckVerify(v)
+	if err != nil {
+		return err
+	}
+	auth, err := gitAuth(v)
+	if err != nil {
+		return err
 	}
 
-	if personal != "" {
-		cloneOptions.Auth = &http.BasicAuth{
-			// The intended use of a GitHub personal access token is in replace of your password
-			// because access tokens can easily be revoked.
-			// https://help.github.com/articles/creating-a-personal-access-token-for-the-command-line/
-			Username: "abc123", // yes, this can be anything except an empty string
-			Password: personal,
-		}
+	cloneOptions := git.CloneOptions{
+		URL:          v.Rep,
+		Progress:     progress,
+		Auth:         auth,
+		Depth:        v.Depth,
+		SingleBranch: v.SingleBranch,
+	}
+	if v.Submodules {
+		cloneOptions.RecurseSubmodules = git.DefaultSubmoduleRecursionDepth
+	}
+	switch {
+	case v.Tag != "":
+		cloneOptions.ReferenceName = plumbing.NewTagReferenceName(v.Tag)
+	case v.Branch != "":
+		cloneOptions.ReferenceName = plumbing.NewBranchReferenceName(v.Branch)
 	}
 
 	r, err = git.PlainClone(dir, false, &cloneOptions)
@@ -37,14 +66,114 @@ func GitClone(repo string, dir string, home string, personal string) error {
 		return err
 	}
 
+	if v.Commit != "" {
+		w, err := r.Worktree()
+		if err != nil {
+			return err
+		}
+		err = w.Checkout(&git.CheckoutOptions{Hash: plumbing.NewHash(v.Commit)})
+		if err != nil {
+			return err
+		}
+		err = gitUpdateSubmodules(w, v, auth)
+		if err != nil {
+			return err
+		}
+	}
+
 	ref, err := r.Head()
 	if err != nil {
 		return err
 	}
 	Sugar.Debug("Checkout out Ref: ", ref)
+
+	// there is no earlier state to compare with, so only HEAD is checked.
+	// An untrusted checkout must not stay on disk.
+	if v.Verify != "" {
+		_, err = GitVerifyCommits(r, v, plumbing.ZeroHash, ref.Hash())
+		if err != nil {
+			removeErr := os.RemoveAll(dir)
+			if removeErr != nil {
+				Sugar.Error(removeErr)
+			}
+			return err
+		}
+	}
 	return nil
 }
 
+func gitAuth(v GitConfig) (transport.AuthMethod, error) {
+	if v.SSHKey != "" {
+		return gitSSHAuth(v)
+	}
+
+	if v.PersonalToken == "" {
+		return nil, nil
+	}
+	return &http.BasicAuth{
+		// The intended use of a GitHub personal access token is in replace of your password
+		// because access tokens can easily be revoked.
+		// https://help.github.com/articles/creating-a-personal-access-token-for-the-command-line/
+		Username: "abc123", // yes, this can be anything except an empty string
+		Password: v.PersonalToken,
+	}, nil
+}
+
+func gitSSHAuth(v GitConfig) (transport.AuthMethod, error) {
+	// host keys are always checked, without known hosts there is no connection
+	if strings.TrimSpace(v.KnownHosts) == "" {
+		return nil, errors.New(ERROR_GIT_KNOWN_HOSTS + v.Name)
+	}
+
+	var signer ssh.Signer
+	var err error
+	if v.SSHPassphrase != "" {
+		signer, err = ssh.ParsePrivateKeyWithPassphrase([]byte(v.SSHKey), []byte(v.SSHPassphrase))
+	} else {
+		signer, err = ssh.ParsePrivateKey([]byte(v.SSHKey))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	callback, err := gitKnownHosts(v.KnownHosts)
+	if err != nil {
+		return nil, err
+	}
+
+	user := gitssh.DefaultUsername
+	endpoint, err := transport.NewEndpoint(v.Rep)
+	if err == nil && endpoint.User != "" {
+		user = endpoint.User
+	}
+
+	auth := &gitssh.PublicKeys{
+		User:   user,
+		Signer: signer,
+	}
+	auth.HostKeyCallback = callback
+	return auth, nil
+}
+
+// knownhosts only reads from files, the entries are public so a short lived
+// temporary file is fine
+func gitKnownHosts(entries string) (ssh.HostKeyCallback, error) {
+	f, err := ioutil.TempFile("", "agent-known-hosts")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.WriteString(entries + "\n")
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return knownhosts.New(f.Name())
+}
+
 func GitCreateRemote(dir string, home string, repoUrl string) error {
 	path := strings.ReplaceAll(dir, HOME, home)
 	r, err := git.PlainOpen(path)
@@ -58,47 +187,638 @@ func GitCreateRemote(dir string, home string, repoUrl string) error {
 			Name: GIT_REMOTE_NAME,
 			URLs: []string{repoUrl},
 		})
-		return err
+		if err != nil {
+			return err
+		}
+		return gitRecordRemote(r, GIT_REMOTE_NAME)
 	}
 	return err
 }
 
-func GitPull(dir string, home string, personal string) error {
-	path := strings.ReplaceAll(dir, HOME, home)
+func GitPull(v GitConfig, home string, progress io.Writer) (string, error) {
+	str, _, err := gitPull(v, home, progress)
+	return str, err
+}
+
+// gitPull reports false if the dirty worktree policy skipped the pull
+func gitPull(v GitConfig, home string, progress io.Writer) (string, bool, error) {
+	path := strings.ReplaceAll(v.Directory, HOME, home)
 	Sugar.Info("Pulling from: ", path)
 	r, err := git.PlainOpen(path)
 	if err != nil {
-		return err
+		return "", false, err
+	}
+
+	err = gitCheckRef(v)
+	if err != nil {
+		return "", false, err
+	}
+	err = gitCheckVerify(v)
+	if err != nil {
+		return "", false, err
+	}
+	auth, err := gitAuth(v)
+	if err != nil {
+		return "", false, err
 	}
 
 	// Get the working directory for the repository
 	w, err := r.Worktree()
 	if err != nil {
-		return err
+		return "", false, err
+	}
+
+	var buffer bytes.Buffer
+	files, err := gitDirtyFiles(w)
+	if err != nil {
+		return "", false, err
+	}
+	if len(files) > 0 {
+		message, pull, err := gitHandleDirty(r, w, v, path, files)
+		buffer.WriteString(message)
+		if err != nil || !pull {
+			return buffer.String(), false, err
+		}
+	}
+
+	str, err := gitUpdateWorktree(r, w, v, auth, progress)
+	buffer.WriteString(str)
+	if err != nil {
+		return buffer.String(), false, err
+	}
+
+	// submodules are not touched by a reset, they always follow the new HEAD
+	err = gitUpdateSubmodules(w, v, auth)
+	return buffer.String(), err == nil, err
+}
+
+func gitUpdateWorktree(r *git.Repository, w *git.Worktree, v GitConfig, auth transport.AuthMethod, progress io.Writer) (string, error) {
+	if v.Tag != "" || v.Commit != "" {
+		err := gitEnforcePin(r, w, v, auth, progress)
+		if err != nil {
+			return "", err
+		}
+		return "Pinned to: " + gitPinName(v), nil
+	}
+
+	if v.Verify != "" {
+		return gitVerifiedPull(r, w, v, auth, progress)
 	}
 
 	pullOptions := git.PullOptions{
-		RemoteName: GIT_REMOTE_NAME,
+		RemoteName:   GIT_REMOTE_NAME,
+		Auth:         auth,
+		Progress:     progress,
+		Depth:        v.Depth,
+		SingleBranch: v.SingleBranch,
+	}
+	if v.Branch != "" {
+		err := gitCheckoutBranch(r, w, v.Branch, auth, progress)
+		if err != nil {
+			return "", err
+		}
+		pullOptions.ReferenceName = plumbing.NewBranchReferenceName(v.Branch)
 	}
 
-	if personal != "" {
-		pullOptions.Auth = &http.BasicAuth{
-			Username: "abc123", // yes, this can be anything except an empty string
-			Password: personal,
+	// the pull can not fetch into a shallow clone itself
+	shallow, err := gitShallow(r)
+	if err != nil {
+		return "", err
+	}
+	if len(shallow) > 0 {
+		remote, err := r.Remote(GIT_REMOTE_NAME)
+		if err != nil {
+			return "", err
+		}
+		err = gitFetch(r, remote.Config(), &git.FetchOptions{
+			RemoteName: GIT_REMOTE_NAME,
+			Auth:       auth,
+			Progress:   progress,
+		})
+		if err != nil && err != git.NoErrAlreadyUpToDate {
+			return "", err
 		}
 	}
 
 	// Pull the latest changes from the origin remote and merge into the current branch
 	err = w.Pull(&pullOptions)
-	if err != nil && err != git.NoErrAlreadyUpToDate {
-		return err
+	if err == git.NoErrAlreadyUpToDate {
+		return "Already up-to-date", nil
+	} else if err != nil {
+		return "", err
 	}
 
 	// Print the latest commit that was just pulled
 	ref, err := r.Head()
 	if err != nil {
-		return err
+		return "", err
 	}
 	Sugar.Debug("Checkout out Ref: ", ref)
+	return "Updated to: " + ref.Hash().String(), nil
+}
+
+func gitUpdateSubmodules(w *git.Worktree, v GitConfig, auth transport.AuthMethod) error {
+	if !v.Submodules {
+		return nil
+	}
+	subs, err := w.Submodules()
+	if err != nil {
+		return err
+	}
+	return subs.Update(&git.SubmoduleUpdateOptions{
+		Init:              true,
+		RecurseSubmodules: git.DefaultSubmoduleRecursionDepth,
+		Auth:              auth,
+	})
+}
+
+func gitPinName(v GitConfig) string {
+	if v.Tag != "" {
+		return v.Tag
+	}
+	return v.Commit
+}
+
+// pinned repositories are enforced, so their local changes are backed up and
+// reset unless something else is configured
+func gitDirtyPolicy(v GitConfig) string {
+	if v.Dirty != "" {
+		return v.Dirty
+	}
+	if v.Tag != "" || v.Commit != "" {
+		return GIT_DIRTY_BACKUP
+	}
+	return GIT_DIRTY_SKIP
+}
+
+func gitDirtyFiles(w *git.Worktree) ([]string, error) {
+	status, err := w.Status()
+	if err != nil {
+		return nil, err
+	}
+	files := []string{}
+	for file, s := range status {
+		if s.Worktree == git.Untracked {
+			continue
+		}
+		if s.Worktree != git.Unmodified || s.Staging != git.Unmodified {
+			files = append(files, file)
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+func gitHandleDirty(r *git.Repository, w *git.Worktree, v GitConfig, path string, files []string) (string, bool, error) {
+	policy := gitDirtyPolicy(v)
+	list := strings.Join(files, ", ")
+	switch policy {
+	case GIT_DIRTY_SKIP:
+		Sugar.Warn(GIT_MESSAGE_DIRTY_SKIP, path, ": ", list)
+		return "Dirty worktree, skipped pull (policy " + policy + "): " + list + "\n", false, nil
+	case GIT_DIRTY_BACKUP:
+		dir, err := gitBackupFiles(path, files)
+		if err != nil {
+			return "", false, err
+		}
+		err = gitResetHead(r, w)
+		if err != nil {
+			return "", false, err
+		}
+		return "Dirty worktree, backed up to " + dir + " (policy " + policy + "): " + list + "\n", true, nil
+	case GIT_DIRTY_RESET:
+		err := gitResetHead(r, w)
+		if err != nil {
+			return "", false, err
+		}
+		return "Dirty worktree, reset (policy " + policy + "): " + list + "\n", true, nil
+	default:
+		return "", false, errors.New(ERROR_GIT_DIRTY_POLICY + policy)
+	}
+}
+
+func gitResetHead(r *git.Repository, w *git.Worktree) error {
+	head, err := r.Head()
+	if err != nil {
+		return err
+	}
+	return w.Reset(&git.ResetOptions{
+		Commit: head.Hash(),
+		Mode:   git.HardReset,
+	})
+}
+
+// the backup lives next to the worktree, inside it would make the worktree
+// dirty again
+func gitBackupFiles(path string, files []string) (string, error) {
+	dir := filepath.Join(filepath.Clean(path)+GIT_BACKUP_SUFFIX, time.Now().Format(GIT_BACKUP_FORMAT))
+	for _, file := range files {
+		data, err := ioutil.ReadFile(filepath.Join(path, file))
+		if os.IsNotExist(err) {
+			// deleted files are still in the history
+			continue
+		} else if err != nil {
+			return "", err
+		}
+
+		target := filepath.Join(dir, file)
+		err = os.MkdirAll(filepath.Dir(target), 0700)
+		if err != nil {
+			return "", err
+		}
+		err = ioutil.WriteFile(target, data, 0600)
+		if err != nil {
+			return "", err
+		}
+	}
+	return dir, nil
+}
+
+func gitCheckRef(v GitConfig) error {
+	if v.Tag != "" && v.Commit != "" {
+		return errors.New(ERROR_GIT_REF + v.Name)
+	}
+	if v.Commit != "" && plumbing.NewHash(v.Commit).String() != strings.ToLower(v.Commit) {
+		return errors.New(ERROR_GIT_COMMIT + v.Commit)
+	}
+	return nil
+}
+
+// pulls only ever fast-forward the configured branch, so it has to be the
+// checked out one
+func gitCheckoutBranch(r *git.Repository, w *git.Worktree, branch string, auth transport.AuthMethod, progress io.Writer) error {
+	name := plumbing.NewBranchReferenceName(branch)
+	head, err := r.Head()
+	if err != nil {
+		return err
+	}
+	if head.Name() == name {
+		return nil
+	}
+
+	_, err = r.Reference(name, false)
+	if err == nil {
+		Sugar.Info("Switching to branch: ", branch)
+		return w.Checkout(&git.CheckoutOptions{Branch: name})
+	} else if err != plumbing.ErrReferenceNotFound {
+		return err
+	}
+
+	remote, err := r.Remote(GIT_REMOTE_NAME)
+	if err != nil {
+		return err
+	}
+	err = gitFetch(r, remote.Config(), &git.FetchOptions{
+		RemoteName: GIT_REMOTE_NAME,
+		Auth:       auth,
+		Progress:   progress,
+	})
+	if err != nil && err != git.NoErrAlreadyUpToDate {
+		return err
+	}
+	remoteRef, err := r.Reference(plumbing.NewRemoteReferenceName(GIT_REMOTE_NAME, branch), true)
+	if err != nil {
+		return err
+	}
+	Sugar.Info("Creating branch: ", branch)
+	return w.Checkout(&git.CheckoutOptions{
+		Branch: name,
+		Hash:   remoteRef.Hash(),
+		Create: true,
+	})
+}
+
+func gitPinnedHash(r *git.Repository, v GitConfig) (plumbing.Hash, error) {
+	if v.Commit != "" {
+		hash := plumbing.NewHash(v.Commit)
+		_, err := r.CommitObject(hash)
+		return hash, err
+	}
+	hash, err := r.ResolveRevision(plumbing.Revision(plumbing.NewTagReferenceName(v.Tag)))
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return *hash, nil
+}
+
+// a pinned tag or commit wins over local commits, local modifications are
+// handled by the dirty policy before
+func gitEnforcePin(r *git.Repository, w *git.Worktree, v GitConfig, auth transport.AuthMethod, progress io.Writer) error {
+	remote, err := r.Remote(GIT_REMOTE_NAME)
+	if err != nil {
+		return err
+	}
+	err = gitFetch(r, remote.Config(), &git.FetchOptions{
+		RemoteName: GIT_REMOTE_NAME,
+		Auth:       auth,
+		Progress:   progress,
+		Tags:       git.AllTags,
+	})
+	if err != nil && err != git.NoErrAlreadyUpToDate {
+		return err
+	}
+
+	target, err := gitPinnedHash(r, v)
+	if err != nil {
+		return err
+	}
+	head, err := r.Head()
+	if err != nil {
+		return err
+	}
+	if head.Hash() == target {
+		return nil
+	}
+	if v.Verify != "" {
+		// a pin can move anywhere, only the target itself is checked
+		_, err = GitVerifyCommits(r, GitConfig{Name: v.Name, TrustedKeys: v.TrustedKeys, Verify: GIT_VERIFY_TIP}, head.Hash(), target)
+		if err != nil {
+			return err
+		}
+	}
+
+	RecordEvent(EVENT_LEVEL_WARN, EVENT_SOURCE_GIT, GIT_MESSAGE_DRIFT+v.Name+" "+head.Hash().String()+" -> "+target.String())
+	return w.Checkout(&git.CheckoutOptions{
+		Hash:  target,
+		Force: true,
+	})
+}
+
+func GitHookCommand(v GitConfig, home string, hook string, old plumbing.Hash, head plumbing.Hash) *exec.Cmd {
+	cmd := exec.Command("sh", "-c", hook)
+	cmd.Dir = strings.ReplaceAll(v.Directory, HOME, home)
+	cmd.Env = append(os.Environ(),
+		GIT_HOOK_OLD_COMMIT+"="+old.String(),
+		GIT_HOOK_NEW_COMMIT+"="+head.String(),
+		GIT_HOOK_NAME+"="+v.Name,
+		GIT_HOOK_DIR+"="+cmd.Dir,
+	)
+	return cmd
+}
+
+type GitMessageData struct {
+	Hostname  string
+	Timestamp string
+}
+
+func GitSync(v GitConfig, home string, progress io.Writer) (string, error) {
+	path := strings.ReplaceAll(v.Directory, HOME, home)
+	Sugar.Info("Syncing: ", path)
+	switch v.Strategy {
+	case "", GIT_STRATEGY_FF, GIT_STRATEGY_NONE, GIT_STRATEGY_MERGE, GIT_STRATEGY_REBASE:
+	default:
+		return "", errors.New(ERROR_GIT_STRATEGY + v.Strategy)
+	}
+	if v.Tag != "" || v.Commit != "" {
+		return "", errors.New(ERROR_GIT_PINNED + v.Name)
+	}
+	err := gitCheckVerify(v)
+	if err != nil {
+		return "", err
+	}
+
+	reconciled, err := GitReconcileRemote(v, home, progress)
+	if err != nil {
+		return reconciled, err
+	}
+
+	r, err := git.PlainOpen(path)
+	if err != nil {
+		return "", err
+	}
+	w, err := r.Worktree()
+	if err != nil {
+		return "", err
+	}
+	head, err := r.Head()
+	if err != nil {
+		return "", err
+	}
+	if !head.Name().IsBranch() {
+		return "", errors.New(ERROR_GIT_DETACHED)
+	}
+
+	remote, err := r.Remote(GIT_REMOTE_NAME)
+	if err != nil {
+		return "", err
+	}
+	auth, err := gitAuth(v)
+	if err != nil {
+		return "", err
+	}
+	err = gitFetch(r, remote.Config(), &git.FetchOptions{
+		RemoteName: GIT_REMOTE_NAME,
+		Auth:       auth,
+		Progress:   progress,
+	})
+	if err != nil && err != git.NoErrAlreadyUpToDate {
+		return "", err
+	}
+
+	remoteName := plumbing.NewRemoteReferenceName(GIT_REMOTE_NAME, head.Name().Short())
+	remoteRef, err := r.Reference(remoteName, true)
+	if err != nil && err != plumbing.ErrReferenceNotFound {
+		return "", err
+	}
+
+	var buffer bytes.Buffer
+	buffer.WriteString(reconciled)
+	files, err := gitDirtyFiles(w)
+	if err != nil {
+		return "", err
+	}
+	if remoteRef != nil && remoteRef.Hash() != head.Hash() {
+		err = gitSyncUpstream(r, w, v, path, head, remoteRef.Hash(), files, &buffer)
+	} else {
+		err = gitSyncCommit(w, v, files, &buffer)
+	}
+	if err != nil {
+		return buffer.String(), err
+	}
+
+	refspec := config.RefSpec(head.Name().String() + ":" + head.Name().String())
+	err = r.Push(&git.PushOptions{
+		RemoteName: GIT_REMOTE_NAME,
+		RefSpecs:   []config.RefSpec{refspec},
+		Auth:       auth,
+	})
+	if err == git.NoErrAlreadyUpToDate {
+		buffer.WriteString("Already up-to-date")
+		return buffer.String(), nil
+	} else if err != nil {
+		return buffer.String(), err
+	}
+	buffer.WriteString("Pushed: " + head.Name().Short())
+	return buffer.String(), nil
+}
+
+func gitSyncCommit(w *git.Worktree, v GitConfig, files []string, buffer *bytes.Buffer) error {
+	if len(files) == 0 {
+		return nil
+	}
+	hash, err := gitCommitTracked(w, v)
+	if err != nil {
+		return err
+	}
+	buffer.WriteString("Committed: " + hash.String() + "\n")
 	return nil
 }
+
+// gitSyncUpstream decides before the local changes are committed, a sync
+// which can not finish leaves the checkout as it was
+func gitSyncUpstream(r *git.Repository, w *git.Worktree, v GitConfig, path string, head *plumbing.Reference, target plumbing.Hash, files []string, buffer *bytes.Buffer) error {
+	local, err := r.CommitObject(head.Hash())
+	if err != nil {
+		return err
+	}
+	upstream, err := r.CommitObject(target)
+	if err != nil {
+		return err
+	}
+	bases, err := local.MergeBase(upstream)
+	if err != nil {
+		return err
+	}
+	if len(bases) == 0 {
+		return errors.New(ERROR_GIT_DIVERGED + v.Name)
+	}
+	base := bases[0]
+
+	if base.Hash == upstream.Hash {
+		// nothing to pull, only the push is missing
+		return gitSyncCommit(w, v, files, buffer)
+	}
+	if v.Strategy == GIT_STRATEGY_NONE {
+		return errors.New(ERROR_GIT_REMOTE_AHEAD + v.Name)
+	}
+	diverged := base.Hash != local.Hash
+	if diverged && (v.Strategy == "" || v.Strategy == GIT_STRATEGY_FF) {
+		return errors.New(ERROR_GIT_DIVERGED + v.Name)
+	}
+
+	remotePaths, err := gitChangedPaths(base, upstream)
+	if err != nil {
+		return err
+	}
+	localPaths, err := gitChangedPaths(base, local)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		localPaths[file] = true
+	}
+	conflicts := gitConflicts(localPaths, remotePaths)
+	if len(conflicts) > 0 {
+		// never force anything, the conflict has to be resolved by hand
+		return errors.New(ERROR_GIT_CONFLICT + v.Name + ": " + strings.Join(conflicts, ", "))
+	}
+	if v.Verify != "" {
+		_, err = GitVerifyCommits(r, v, local.Hash, upstream.Hash)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !diverged {
+		// the fetched state is used, pulling would fetch again
+		_, err = gitRebase(w, path, v, upstream, remotePaths, nil)
+		if err != nil {
+			return err
+		}
+		buffer.WriteString("Fast-forwarded to: " + upstream.Hash.String() + "\n")
+		return gitSyncCommit(w, v, files, buffer)
+	}
+
+	err = gitSyncCommit(w, v, files, buffer)
+	if err != nil {
+		return err
+	}
+	committed, err := r.Head()
+	if err != nil {
+		return err
+	}
+	if v.Strategy == GIT_STRATEGY_MERGE {
+		var merge plumbing.Hash
+		merge, err = gitMerge(w, path, v, committed.Hash(), upstream, remotePaths, head.Name().Short())
+		if err == nil {
+			buffer.WriteString("Merged: " + merge.String() + "\n")
+		}
+	} else {
+		var commits []*object.Commit
+		local, err = r.CommitObject(committed.Hash())
+		if err == nil {
+			commits, err = gitLocalCommits(local, base.Hash)
+		}
+		if err == nil {
+			_, err = gitRebase(w, path, v, upstream, remotePaths, commits)
+		}
+		if err == nil {
+			buffer.WriteString("Rebased onto: " + upstream.Hash.String() + "\n")
+		}
+	}
+	if err != nil {
+		// the local changes are committed, going back loses nothing
+		resetErr := w.Reset(&git.ResetOptions{
+			Commit: committed.Hash(),
+			Mode:   git.HardReset,
+		})
+		if resetErr != nil {
+			Sugar.Error(resetErr)
+		}
+		return err
+	}
+	return nil
+}
+
+func gitSignature(v GitConfig) *object.Signature {
+	name := v.AuthorName
+	if name == "" {
+		name = GIT_DEFAULT_AUTHOR
+	}
+	email := v.AuthorEmail
+	if email == "" {
+		email = GIT_DEFAULT_EMAIL
+	}
+	return &object.Signature{
+		Name:  name,
+		Email: email,
+		When:  time.Now(),
+	}
+}
+
+func gitCommitTracked(w *git.Worktree, v GitConfig) (plumbing.Hash, error) {
+	message, err := GitCommitMessage(v.Message)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return w.Commit(message, &git.CommitOptions{
+		All:    true,
+		Author: gitSignature(v),
+	})
+}
+
+func GitCommitMessage(message string) (string, error) {
+	if message == "" {
+		message = GIT_DEFAULT_MESSAGE
+	}
+	tmpl, err := template.New("message").Parse(message)
+	if err != nil {
+		return "", err
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "", err
+	}
+	data := GitMessageData{
+		Hostname:  hostname,
+		Timestamp: time.Now().Format(time.RFC3339),
+	}
+
+	var buffer bytes.Buffer
+	err = tmpl.Execute(&buffer, data)
+	if err != nil {
+		return "", err
+	}
+	return buffer.String(), nil
+}
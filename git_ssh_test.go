@@ -0,0 +1,210 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// startSSHServer serves git-upload-pack and git-receive-pack for the client key
+// and returns the address and the known_hosts line of the server
+func startSSHServer(t *testing.T, client ssh.PublicKey) (string, string) {
+	hostKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	hostSigner, err := ssh.NewSignerFromKey(hostKey)
+	require.NoError(t, err)
+
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if string(key.Marshal()) == string(client.Marshal()) {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("unknown key for %s", conn.User())
+		},
+	}
+	config.AddHostKey(hostSigner)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveSSH(conn, config)
+		}
+	}()
+
+	addr := listener.Addr().(*net.TCPAddr)
+	host := "[127.0.0.1]:" + strconv.Itoa(addr.Port)
+	return addr.String(), knownhosts.Line([]string{host}, hostSigner.PublicKey())
+}
+
+func serveSSH(conn net.Conn, config *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go serveSession(channel, requests)
+	}
+}
+
+func serveSession(channel ssh.Channel, requests <-chan *ssh.Request) {
+	defer channel.Close()
+	for req := range requests {
+		if req.Type != "exec" {
+			req.Reply(false, nil)
+			continue
+		}
+		var payload struct{ Command string }
+		ssh.Unmarshal(req.Payload, &payload)
+		req.Reply(true, nil)
+
+		args := strings.SplitN(payload.Command, " ", 2)
+		if len(args) != 2 || (args[0] != "git-upload-pack" && args[0] != "git-receive-pack") {
+			channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{1}))
+			return
+		}
+
+		cmd := exec.Command(args[0], strings.Trim(args[1], "'"))
+		cmd.Stdout = channel
+		cmd.Stderr = channel.Stderr()
+		stdin, err := cmd.StdinPipe()
+		if err != nil || cmd.Start() != nil {
+			channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{1}))
+			return
+		}
+		go func() {
+			io.Copy(stdin, channel)
+			stdin.Close()
+		}()
+
+		status := uint32(0)
+		if cmd.Wait() != nil {
+			status = 1
+		}
+		channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+		return
+	}
+}
+
+func generateClientKey(t *testing.T, passphrase string) (string, ssh.PublicKey) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(key)
+	require.NoError(t, err)
+
+	block := &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}
+	if passphrase != "" {
+		//lint:ignore SA1019 legacy PEM encryption is what ssh-keygen -m PEM writes
+		block, err = x509.EncryptPEMBlock(rand.Reader, block.Type, block.Bytes, []byte(passphrase), x509.PEMCipherAES256)
+		require.NoError(t, err)
+	}
+	return string(pem.EncodeToMemory(block)), signer.PublicKey()
+}
+
+func TestGitSSHAuth(t *testing.T) {
+	fmt.Println("running: TestGitSSHAuth")
+
+	home, remote := createLocalRemote(t)
+	key, public := generateClientKey(t, "")
+	addr, knownHosts := startSSHServer(t, public)
+
+	v := GitConfig{
+		Name:       "ssh",
+		Rep:        "ssh://git@" + addr + remote,
+		Directory:  "~/clone",
+		SSHKey:     key,
+		KnownHosts: knownHosts,
+	}
+
+	err := GitClone(v, home, nil)
+	require.NoError(t, err)
+	assert.FileExists(t, home+"/clone/README")
+
+	seed, err := git.PlainOpen(home + "/seed")
+	require.NoError(t, err)
+	commitFile(t, seed, home+"/seed", "NEW", "new\n")
+	require.NoError(t, seed.Push(&git.PushOptions{RemoteName: "origin"}))
+
+	err = GitCreateRemote(v.Directory, home, v.Rep)
+	require.NoError(t, err)
+	_, err = GitPull(v, home, nil)
+	require.NoError(t, err)
+	assert.FileExists(t, home+"/clone/NEW")
+
+	// a different host key must be rejected
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	otherPublic, err := ssh.NewPublicKey(&otherKey.PublicKey)
+	require.NoError(t, err)
+	wrong := v
+	wrong.Directory = "~/wrong"
+	wrong.KnownHosts = knownhosts.Line([]string{knownhosts.Normalize(addr)}, otherPublic)
+	err = GitClone(wrong, home, nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "knownhosts: key mismatch")
+	assert.NoDirExists(t, home+"/wrong/.git")
+
+	missing := v
+	missing.Directory = "~/missing"
+	missing.KnownHosts = ""
+	err = GitClone(missing, home, nil)
+	assert.Error(t, err)
+	assert.True(t, strings.HasPrefix(err.Error(), ERROR_GIT_KNOWN_HOSTS))
+}
+
+func TestGitSSHAuthPassphrase(t *testing.T) {
+	fmt.Println("running: TestGitSSHAuthPassphrase")
+
+	home, remote := createLocalRemote(t)
+	key, public := generateClientKey(t, "secret")
+	addr, knownHosts := startSSHServer(t, public)
+
+	v := GitConfig{
+		Rep:           "ssh://git@" + addr + remote,
+		Directory:     "~/clone",
+		SSHKey:        key,
+		SSHPassphrase: "secret",
+		KnownHosts:    knownHosts,
+	}
+	err := GitClone(v, home, nil)
+	require.NoError(t, err)
+	assert.FileExists(t, home+"/clone/README")
+
+	v.SSHPassphrase = "wrong"
+	v.Directory = "~/wrong"
+	err = GitClone(v, home, nil)
+	assert.Error(t, err)
+}
@@ -1,13 +1,19 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"os/exec"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
 	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -20,7 +26,7 @@ func TestGitClone(t *testing.T) {
 	test_folder := strings.ReplaceAll(GIT_TEST_FOLDER, HOME, pwd)
 	require.NoDirExists(t, test_folder)
 
-	err = GitClone(GIT_TEST_REPO, GIT_TEST_FOLDER, pwd, "")
+	err = GitClone(GitConfig{Rep: GIT_TEST_REPO, Directory: GIT_TEST_FOLDER}, pwd, nil)
 	assert.NoError(t, err)
 	assert.DirExists(t, test_folder)
 
@@ -28,7 +34,7 @@ func TestGitClone(t *testing.T) {
 	assert.NoError(t, err)
 
 	// Second Clone for test if repo exists error is ignored
-	err = GitClone(GIT_TEST_REPO, GIT_TEST_FOLDER, pwd, "test")
+	err = GitClone(GitConfig{Rep: GIT_TEST_REPO, Directory: GIT_TEST_FOLDER, PersonalToken: "test"}, pwd, nil)
 	require.Error(t, err)
 	assert.Error(t, git.ErrRepositoryAlreadyExists, err)
 }
@@ -68,12 +74,12 @@ func TestGitPull(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, GIT_TEST_COMMIT, ref.Hash().String())
 
-	err = GitPull(GIT_TEST_FOLDER, pwd, "")
+	_, err = GitPull(GitConfig{Directory: GIT_TEST_FOLDER}, pwd, nil)
 	assert.Error(t, git.ErrRemoteNotFound, err)
 	err = GitCreateRemote(GIT_TEST_FOLDER, pwd, GIT_TEST_REPO)
 	assert.NoError(t, err)
 
-	err = GitPull(GIT_TEST_FOLDER, pwd, "")
+	_, err = GitPull(GitConfig{Directory: GIT_TEST_FOLDER}, pwd, nil)
 	assert.NoError(t, err)
 	remote, err = r.Remote(GIT_REMOTE_NAME)
 	assert.NoError(t, err)
@@ -111,3 +117,647 @@ func TestGitCreateRemote(t *testing.T) {
 	assert.NoError(t, err)
 	assert.NotNil(t, remote)
 }
+
+// creates a bare repository with one commit in a temporary home folder and
+// returns the home folder and the path of the bare repository
+func createLocalRemote(t *testing.T) (string, string) {
+	home, err := ioutil.TempDir("", "agent-git")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(home) })
+
+	remote := home + "/remote.git"
+	_, err = git.PlainInit(remote, true)
+	require.NoError(t, err)
+
+	seed, err := git.PlainInit(home+"/seed", false)
+	require.NoError(t, err)
+	commitFile(t, seed, home+"/seed", "README", "seed\n")
+	_, err = seed.CreateRemote(&config.RemoteConfig{
+		Name: "origin",
+		URLs: []string{remote},
+	})
+	require.NoError(t, err)
+	require.NoError(t, seed.Push(&git.PushOptions{RemoteName: "origin"}))
+	return home, remote
+}
+
+func commitFile(t *testing.T, r *git.Repository, dir string, name string, content string) plumbing.Hash {
+	require.NoError(t, ioutil.WriteFile(dir+"/"+name, []byte(content), 0600))
+	w, err := r.Worktree()
+	require.NoError(t, err)
+	_, err = w.Add(name)
+	require.NoError(t, err)
+	hash, err := w.Commit("update "+name, &git.CommitOptions{
+		Author: &object.Signature{Name: "test", Email: "test@localhost", When: time.Now()},
+	})
+	require.NoError(t, err)
+	return hash
+}
+
+func remoteHead(t *testing.T, remote string) plumbing.Hash {
+	r, err := git.PlainOpen(remote)
+	require.NoError(t, err)
+	ref, err := r.Reference(plumbing.Master, true)
+	require.NoError(t, err)
+	return ref.Hash()
+}
+
+func TestGitCommitMessage(t *testing.T) {
+	fmt.Println("running: TestGitCommitMessage")
+	hostname, err := os.Hostname()
+	require.NoError(t, err)
+
+	message, err := GitCommitMessage("")
+	assert.NoError(t, err)
+	assert.Contains(t, message, "agent sync from "+hostname+" at ")
+
+	message, err = GitCommitMessage("{{.Hostname}}")
+	assert.NoError(t, err)
+	assert.Equal(t, hostname, message)
+
+	_, err = GitCommitMessage("{{.Hostname")
+	assert.Error(t, err)
+}
+
+func TestGitSync(t *testing.T) {
+	fmt.Println("running: TestGitSync")
+	home, remote := createLocalRemote(t)
+	_, err := git.PlainClone(home+"/work", false, &git.CloneOptions{URL: remote})
+	require.NoError(t, err)
+
+	v := GitConfig{
+		Name:        "sync",
+		Rep:         remote,
+		Directory:   "~/work",
+		AuthorName:  "sync-author",
+		AuthorEmail: "sync@localhost",
+		Message:     "sync {{.Hostname}}",
+	}
+
+	// nothing to do
+	str, err := GitSync(v, home, nil)
+	assert.NoError(t, err)
+	assert.Contains(t, str, "Already up-to-date")
+
+	// tracked changes are committed and pushed, untracked files are ignored
+	require.NoError(t, ioutil.WriteFile(home+"/work/README", []byte("changed\n"), 0600))
+	require.NoError(t, ioutil.WriteFile(home+"/work/untracked", []byte("untracked\n"), 0600))
+	str, err = GitSync(v, home, nil)
+	assert.NoError(t, err)
+	assert.Contains(t, str, "Committed: ")
+	assert.Contains(t, str, "Pushed: master")
+
+	r, err := git.PlainOpen(home + "/work")
+	require.NoError(t, err)
+	head, err := r.Head()
+	require.NoError(t, err)
+	assert.Equal(t, head.Hash(), remoteHead(t, remote))
+
+	commit, err := r.CommitObject(head.Hash())
+	require.NoError(t, err)
+	assert.Equal(t, "sync-author", commit.Author.Name)
+	assert.Equal(t, "sync@localhost", commit.Author.Email)
+	assert.True(t, strings.HasPrefix(commit.Message, "sync "))
+	_, err = commit.File("untracked")
+	assert.Error(t, err)
+
+	// the remote moved on, local is fast-forwarded
+	other, err := git.PlainClone(home+"/other", false, &git.CloneOptions{URL: remote})
+	require.NoError(t, err)
+	hash := commitFile(t, other, home+"/other", "OTHER", "other\n")
+	require.NoError(t, other.Push(&git.PushOptions{}))
+
+	str, err = GitSync(v, home, nil)
+	assert.NoError(t, err)
+	assert.Contains(t, str, "Fast-forwarded to: "+hash.String())
+	assert.FileExists(t, home+"/work/OTHER")
+
+	// local changes to other files are kept on a fast-forward
+	hash = commitFile(t, other, home+"/other", "OTHER", "again\n")
+	require.NoError(t, other.Push(&git.PushOptions{}))
+	require.NoError(t, ioutil.WriteFile(home+"/work/README", []byte("kept\n"), 0600))
+	str, err = GitSync(v, home, nil)
+	assert.NoError(t, err)
+	assert.Contains(t, str, "Fast-forwarded to: "+hash.String())
+	assert.Contains(t, str, "Committed: ")
+	assert.Contains(t, str, "Pushed: master")
+	content, err := ioutil.ReadFile(home + "/work/OTHER")
+	require.NoError(t, err)
+	assert.Equal(t, "again\n", string(content))
+	head, err = r.Head()
+	require.NoError(t, err)
+	assert.Equal(t, head.Hash(), remoteHead(t, remote))
+
+	// a file changed on both sides stops the sync before anything is committed
+	w, err := other.Worktree()
+	require.NoError(t, err)
+	require.NoError(t, w.Pull(&git.PullOptions{}))
+	commitFile(t, other, home+"/other", "README", "remote\n")
+	require.NoError(t, other.Push(&git.PushOptions{}))
+	pushed := remoteHead(t, remote)
+	require.NoError(t, ioutil.WriteFile(home+"/work/README", []byte("local\n"), 0600))
+	before, err := r.Head()
+	require.NoError(t, err)
+
+	_, err = GitSync(v, home, nil)
+	assert.EqualError(t, err, ERROR_GIT_CONFLICT+"sync: README")
+	assert.Equal(t, pushed, remoteHead(t, remote))
+	head, err = r.Head()
+	require.NoError(t, err)
+	assert.Equal(t, before.Hash(), head.Hash())
+	content, err = ioutil.ReadFile(home + "/work/README")
+	require.NoError(t, err)
+	assert.Equal(t, "local\n", string(content))
+
+	v.Strategy = "octopus"
+	_, err = GitSync(v, home, nil)
+	assert.EqualError(t, err, ERROR_GIT_STRATEGY+"octopus")
+}
+
+func TestGitSyncStrategies(t *testing.T) {
+	fmt.Println("running: TestGitSyncStrategies")
+	home, remote := createLocalRemote(t)
+	other, err := git.PlainClone(home+"/other", false, &git.CloneOptions{URL: remote})
+	require.NoError(t, err)
+
+	for _, strategy := range []string{GIT_STRATEGY_FF, GIT_STRATEGY_MERGE, GIT_STRATEGY_REBASE} {
+		dir := "work-" + strategy
+		r, err := git.PlainClone(home+"/"+dir, false, &git.CloneOptions{URL: remote})
+		require.NoError(t, err)
+		v := GitConfig{Name: strategy, Rep: remote, Directory: "~/" + dir, Strategy: strategy}
+		require.NoError(t, GitCreateRemote(v.Directory, home, remote))
+
+		// both sides have new commits on different files
+		w, err := other.Worktree()
+		require.NoError(t, err)
+		err = w.Pull(&git.PullOptions{})
+		if err != git.NoErrAlreadyUpToDate {
+			require.NoError(t, err)
+		}
+		upstream := commitFile(t, other, home+"/other", "OTHER-"+strategy, "remote\n")
+		require.NoError(t, other.Push(&git.PushOptions{}))
+		local := commitFile(t, r, home+"/"+dir, "LOCAL", "local\n")
+		require.NoError(t, ioutil.WriteFile(home+"/"+dir+"/README", []byte(strategy+"\n"), 0600))
+
+		str, err := GitSync(v, home, nil)
+		if strategy == GIT_STRATEGY_FF {
+			assert.EqualError(t, err, ERROR_GIT_DIVERGED+strategy)
+			head, err := r.Head()
+			require.NoError(t, err)
+			assert.Equal(t, local, head.Hash())
+			assert.NoFileExists(t, home+"/"+dir+"/OTHER-"+strategy)
+			continue
+		}
+		require.NoError(t, err, str)
+		assert.Contains(t, str, "Pushed: master")
+		assert.FileExists(t, home+"/"+dir+"/OTHER-"+strategy)
+
+		// objects written by the sync are only seen by a fresh handle
+		r, err = git.PlainOpen(home + "/" + dir)
+		require.NoError(t, err)
+		head, err := r.Head()
+		require.NoError(t, err)
+		assert.Equal(t, head.Hash(), remoteHead(t, remote))
+		commit, err := r.CommitObject(head.Hash())
+		require.NoError(t, err)
+		tree, err := commit.Tree()
+		require.NoError(t, err)
+		for name, content := range map[string]string{"README": strategy + "\n", "LOCAL": "local\n", "OTHER-" + strategy: "remote\n"} {
+			file, err := tree.File(name)
+			require.NoError(t, err, name)
+			value, err := file.Contents()
+			require.NoError(t, err)
+			assert.Equal(t, content, value, name)
+		}
+
+		if strategy == GIT_STRATEGY_MERGE {
+			assert.Contains(t, str, "Merged: ")
+			require.Equal(t, 2, commit.NumParents())
+			assert.Equal(t, upstream, commit.ParentHashes[1])
+			continue
+		}
+
+		// the local commits are replayed on top of the remote
+		assert.Contains(t, str, "Rebased onto: "+upstream.String())
+		assert.Equal(t, 1, commit.NumParents())
+		parent, err := commit.Parent(0)
+		require.NoError(t, err)
+		assert.Equal(t, "update LOCAL", parent.Message)
+		assert.Equal(t, upstream, parent.ParentHashes[0])
+		assert.Equal(t, "test", parent.Author.Name)
+		status, err := r.Worktree()
+		require.NoError(t, err)
+		files, err := gitDirtyFiles(status)
+		require.NoError(t, err)
+		assert.Empty(t, files)
+	}
+}
+
+func TestGitSyncStrategyNone(t *testing.T) {
+	fmt.Println("running: TestGitSyncStrategyNone")
+	home, remote := createLocalRemote(t)
+	_, err := git.PlainClone(home+"/work", false, &git.CloneOptions{URL: remote})
+	require.NoError(t, err)
+
+	other, err := git.PlainClone(home+"/other", false, &git.CloneOptions{URL: remote})
+	require.NoError(t, err)
+	commitFile(t, other, home+"/other", "OTHER", "other\n")
+	require.NoError(t, other.Push(&git.PushOptions{}))
+
+	v := GitConfig{
+		Name:      "sync",
+		Rep:       remote,
+		Directory: "~/work",
+		Strategy:  GIT_STRATEGY_NONE,
+	}
+	_, err = GitSync(v, home, nil)
+	assert.EqualError(t, err, ERROR_GIT_REMOTE_AHEAD+"sync")
+	assert.NoFileExists(t, home+"/work/OTHER")
+}
+
+func TestGitRefPinning(t *testing.T) {
+	fmt.Println("running: TestGitRefPinning")
+	home, remote := createLocalRemote(t)
+	seed, err := git.PlainOpen(home + "/seed")
+	require.NoError(t, err)
+	head, err := seed.Head()
+	require.NoError(t, err)
+	first := head.Hash()
+	_, err = seed.CreateTag("v1", first, nil)
+	require.NoError(t, err)
+	second := commitFile(t, seed, home+"/seed", "SECOND", "second\n")
+
+	w, err := seed.Worktree()
+	require.NoError(t, err)
+	require.NoError(t, w.Checkout(&git.CheckoutOptions{Branch: plumbing.NewBranchReferenceName("dev"), Create: true}))
+	dev := commitFile(t, seed, home+"/seed", "DEV", "dev\n")
+	require.NoError(t, seed.Push(&git.PushOptions{
+		RemoteName: "origin",
+		RefSpecs:   []config.RefSpec{"refs/heads/*:refs/heads/*", "refs/tags/*:refs/tags/*"},
+	}))
+
+	// clone checks out the requested ref
+	err = GitClone(GitConfig{Rep: remote, Directory: "~/branch", Branch: "dev"}, home, nil)
+	require.NoError(t, err)
+	r, err := git.PlainOpen(home + "/branch")
+	require.NoError(t, err)
+	head, err = r.Head()
+	require.NoError(t, err)
+	assert.Equal(t, plumbing.NewBranchReferenceName("dev"), head.Name())
+	assert.Equal(t, dev, head.Hash())
+
+	err = GitClone(GitConfig{Rep: remote, Directory: "~/commit", Commit: second.String()}, home, nil)
+	require.NoError(t, err)
+	r, err = git.PlainOpen(home + "/commit")
+	require.NoError(t, err)
+	head, err = r.Head()
+	require.NoError(t, err)
+	assert.Equal(t, second, head.Hash())
+
+	tag := GitConfig{Name: "tag", Rep: remote, Directory: "~/tag", Tag: "v1"}
+	err = GitClone(tag, home, nil)
+	require.NoError(t, err)
+	r, err = git.PlainOpen(home + "/tag")
+	require.NoError(t, err)
+	head, err = r.Head()
+	require.NoError(t, err)
+	assert.Equal(t, first, head.Hash())
+	assert.NoFileExists(t, home+"/tag/SECOND")
+
+	// drift from the pinned tag is backed up and reset
+	require.NoError(t, ioutil.WriteFile(home+"/tag/README", []byte("drift\n"), 0600))
+	require.NoError(t, GitCreateRemote(tag.Directory, home, remote))
+	str, err := GitPull(tag, home, nil)
+	require.NoError(t, err)
+	assert.Contains(t, str, "policy backup): README")
+	content, err := ioutil.ReadFile(home + "/tag/README")
+	require.NoError(t, err)
+	assert.Equal(t, "seed\n", string(content))
+	backups, err := ioutil.ReadDir(home + "/tag" + GIT_BACKUP_SUFFIX)
+	require.NoError(t, err)
+	require.Len(t, backups, 1)
+	content, err = ioutil.ReadFile(home + "/tag" + GIT_BACKUP_SUFFIX + "/" + backups[0].Name() + "/README")
+	require.NoError(t, err)
+	assert.Equal(t, "drift\n", string(content))
+
+	commitFile(t, r, home+"/tag", "LOCAL", "local\n")
+	_, err = GitPull(tag, home, nil)
+	require.NoError(t, err)
+	head, err = r.Head()
+	require.NoError(t, err)
+	assert.Equal(t, first, head.Hash())
+	assert.NoFileExists(t, home+"/tag/LOCAL")
+
+	// pull switches to and fast-forwards only the configured branch
+	err = GitClone(GitConfig{Rep: remote, Directory: "~/master"}, home, nil)
+	require.NoError(t, err)
+	require.NoError(t, GitCreateRemote("~/master", home, remote))
+	_, err = GitPull(GitConfig{Rep: remote, Directory: "~/master", Branch: "dev"}, home, nil)
+	require.NoError(t, err)
+	r, err = git.PlainOpen(home + "/master")
+	require.NoError(t, err)
+	head, err = r.Head()
+	require.NoError(t, err)
+	assert.Equal(t, plumbing.NewBranchReferenceName("dev"), head.Name())
+	assert.Equal(t, dev, head.Hash())
+	master, err := r.Reference(plumbing.NewBranchReferenceName("master"), false)
+	require.NoError(t, err)
+	assert.Equal(t, second, master.Hash())
+
+	_, err = GitPull(GitConfig{Name: "both", Directory: "~/master", Tag: "v1", Commit: first.String()}, home, nil)
+	assert.EqualError(t, err, ERROR_GIT_REF+"both")
+	_, err = GitSync(tag, home, nil)
+	assert.EqualError(t, err, ERROR_GIT_PINNED+"tag")
+}
+
+func TestGitDirtyPolicy(t *testing.T) {
+	fmt.Println("running: TestGitDirtyPolicy")
+	t.Cleanup(clear)
+	home, remote := createLocalRemote(t)
+	seed, err := git.PlainOpen(home + "/seed")
+	require.NoError(t, err)
+	_, err = git.PlainClone(home+"/work", false, &git.CloneOptions{URL: remote})
+	require.NoError(t, err)
+	require.NoError(t, GitCreateRemote("~/work", home, remote))
+
+	v := GitConfig{Name: "dirty", Rep: remote, Directory: "~/work"}
+	upstream := commitFile(t, seed, home+"/seed", "NEW", "new\n")
+	require.NoError(t, seed.Push(&git.PushOptions{RemoteName: "origin"}))
+	require.NoError(t, ioutil.WriteFile(home+"/work/README", []byte("local\n"), 0600))
+
+	// skip is the default and shows up in the job result
+	ok, err := HandleGit("pull", v, true, false, home)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	value, found := jobmap.Get("pull dirty")
+	require.True(t, found)
+	assert.Contains(t, value.(*Job).Stdout.String(), "policy skip): README")
+	assert.NoFileExists(t, home+"/work/NEW")
+
+	v.Dirty = GIT_DIRTY_BACKUP
+	str, err := GitPull(v, home, nil)
+	assert.NoError(t, err)
+	assert.Contains(t, str, "policy backup): README")
+	assert.Contains(t, str, "Updated to: "+upstream.String())
+	assert.FileExists(t, home+"/work/NEW")
+	content, err := ioutil.ReadFile(home + "/work/README")
+	require.NoError(t, err)
+	assert.Equal(t, "seed\n", string(content))
+
+	backups, err := ioutil.ReadDir(home + "/work" + GIT_BACKUP_SUFFIX)
+	require.NoError(t, err)
+	require.Len(t, backups, 1)
+	content, err = ioutil.ReadFile(home + "/work" + GIT_BACKUP_SUFFIX + "/" + backups[0].Name() + "/README")
+	require.NoError(t, err)
+	assert.Equal(t, "local\n", string(content))
+
+	v.Dirty = GIT_DIRTY_RESET
+	require.NoError(t, ioutil.WriteFile(home+"/work/NEW", []byte("local\n"), 0600))
+	str, err = GitPull(v, home, nil)
+	assert.NoError(t, err)
+	assert.Contains(t, str, "policy reset): NEW")
+	assert.Contains(t, str, "Already up-to-date")
+	content, err = ioutil.ReadFile(home + "/work/NEW")
+	require.NoError(t, err)
+	assert.Equal(t, "new\n", string(content))
+
+	v.Dirty = "stash"
+	require.NoError(t, ioutil.WriteFile(home+"/work/NEW", []byte("local\n"), 0600))
+	_, err = GitPull(v, home, nil)
+	assert.EqualError(t, err, ERROR_GIT_DIRTY_POLICY+"stash")
+}
+
+func TestGitReconcileRemote(t *testing.T) {
+	fmt.Println("running: TestGitReconcileRemote")
+	AgentConfiguration.DB = InitDB("", "", true)
+	t.Cleanup(func() {
+		AgentConfiguration.DB.Close()
+		AgentConfiguration.DB = nil
+	})
+
+	home, remote := createLocalRemote(t)
+	r, err := git.PlainClone(home+"/work", false, &git.CloneOptions{URL: remote})
+	require.NoError(t, err)
+	// only remotes the agent recorded are removed
+	_, err = r.CreateRemote(&config.RemoteConfig{Name: "agent_old", URLs: []string{remote}})
+	require.NoError(t, err)
+	_, err = r.CreateRemote(&config.RemoteConfig{Name: "agent_user", URLs: []string{remote}})
+	require.NoError(t, err)
+	require.NoError(t, gitRecordRemote(r, "agent_old"))
+	remoteURL := func() string {
+		remote, err := r.Remote(GIT_REMOTE_NAME)
+		require.NoError(t, err)
+		return remote.Config().URLs[0]
+	}
+
+	v := GitConfig{Name: "reconcile", Rep: remote, Directory: "~/work"}
+	str, err := GitReconcileRemote(v, home, nil)
+	require.NoError(t, err)
+	assert.Contains(t, str, "Removed remote: agent_old")
+	assert.Contains(t, str, "Added remote: "+remote)
+	assert.Equal(t, remote, remoteURL())
+	_, err = r.Remote("agent_old")
+	assert.Equal(t, git.ErrRemoteNotFound, err)
+	_, err = r.Remote("agent_user")
+	assert.NoError(t, err)
+	_, err = r.Remote("origin")
+	assert.NoError(t, err)
+	cfg, err := r.Config()
+	require.NoError(t, err)
+	assert.Equal(t, []string{GIT_REMOTE_NAME}, gitRecordedRemotes(cfg))
+
+	str, err = GitReconcileRemote(v, home, nil)
+	require.NoError(t, err)
+	assert.Empty(t, str)
+
+	// the repository moved
+	_, err = git.PlainClone(home+"/moved.git", true, &git.CloneOptions{URL: remote})
+	require.NoError(t, err)
+	v.Rep = home + "/moved.git"
+	str, err = GitReconcileRemote(v, home, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "Updated remote: "+remote+" -> "+v.Rep+"\n", str)
+	assert.Equal(t, v.Rep, remoteURL())
+
+	// a remote without url, go-git only writes one with a url
+	content, err := ioutil.ReadFile(home + "/work/.git/config")
+	require.NoError(t, err)
+	content = bytes.Replace(content, []byte("url = "+v.Rep+"\n"), nil, 1)
+	require.NoError(t, ioutil.WriteFile(home+"/work/.git/config", content, 0600))
+	str, err = GitReconcileRemote(v, home, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "Updated remote:  -> "+v.Rep+"\n", str)
+	assert.Equal(t, v.Rep, remoteURL())
+
+	// a different repository is reported and not used
+	other := home + "/other.git"
+	_, err = git.PlainInit(other, true)
+	require.NoError(t, err)
+	unrelated, err := git.PlainInit(home+"/unrelated", false)
+	require.NoError(t, err)
+	commitFile(t, unrelated, home+"/unrelated", "OTHER", "other\n")
+	_, err = unrelated.CreateRemote(&config.RemoteConfig{Name: "origin", URLs: []string{other}})
+	require.NoError(t, err)
+	require.NoError(t, unrelated.Push(&git.PushOptions{RemoteName: "origin"}))
+	moved := v.Rep
+	v.Rep = other
+	_, err = GitReconcileRemote(v, home, nil)
+	assert.EqualError(t, err, ERROR_GIT_MISMATCH+"reconcile")
+	assert.Equal(t, moved, remoteURL())
+
+	events, err := GetEvents(AgentConfiguration.DB, 0)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Contains(t, events[0].Message, GIT_MESSAGE_MISMATCH)
+
+	refs, err := r.References()
+	require.NoError(t, err)
+	require.NoError(t, refs.ForEach(func(ref *plumbing.Reference) error {
+		assert.False(t, strings.HasPrefix(ref.Name().String(), GIT_CANDIDATE_REFS))
+		return nil
+	}))
+}
+
+func runGit(t *testing.T, dir string, args ...string) {
+	args = append([]string{"-C", dir, "-c", "user.name=test", "-c", "user.email=test@localhost", "-c", "protocol.file.allow=always"}, args...)
+	out, err := exec.Command("git", args...).CombinedOutput()
+	require.NoError(t, err, string(out))
+}
+
+func TestGitSubmodules(t *testing.T) {
+	fmt.Println("running: TestGitSubmodules")
+	home, remote := createLocalRemote(t)
+
+	sub := home + "/sub.git"
+	_, err := git.PlainInit(sub, true)
+	require.NoError(t, err)
+	subSeed, err := git.PlainInit(home+"/subseed", false)
+	require.NoError(t, err)
+	commitFile(t, subSeed, home+"/subseed", "SUB", "first\n")
+	_, err = subSeed.CreateRemote(&config.RemoteConfig{Name: "origin", URLs: []string{sub}})
+	require.NoError(t, err)
+	require.NoError(t, subSeed.Push(&git.PushOptions{RemoteName: "origin"}))
+
+	runGit(t, home+"/seed", "submodule", "add", sub, "sub")
+	runGit(t, home+"/seed", "commit", "-q", "-m", "add sub")
+	runGit(t, home+"/seed", "push", "-q", "origin", "master")
+
+	v := GitConfig{Rep: remote, Directory: "~/work", Submodules: true}
+	err = GitClone(v, home, nil)
+	require.NoError(t, err)
+	content, err := ioutil.ReadFile(home + "/work/sub/SUB")
+	require.NoError(t, err)
+	assert.Equal(t, "first\n", string(content))
+
+	// the submodule moves on and the new commit is recorded upstream
+	commitFile(t, subSeed, home+"/subseed", "SUB", "second\n")
+	require.NoError(t, subSeed.Push(&git.PushOptions{RemoteName: "origin"}))
+	runGit(t, home+"/seed/sub", "pull", "-q", "origin", "master")
+	runGit(t, home+"/seed", "commit", "-q", "-am", "update sub")
+	runGit(t, home+"/seed", "push", "-q", "origin", "master")
+
+	require.NoError(t, GitCreateRemote(v.Directory, home, remote))
+	str, err := GitPull(v, home, nil)
+	require.NoError(t, err)
+	assert.Contains(t, str, "Updated to: ")
+	content, err = ioutil.ReadFile(home + "/work/sub/SUB")
+	require.NoError(t, err)
+	assert.Equal(t, "second\n", string(content))
+
+	// without the option the submodule is left alone
+	err = GitClone(GitConfig{Rep: remote, Directory: "~/plain"}, home, nil)
+	require.NoError(t, err)
+	assert.NoFileExists(t, home+"/plain/sub/SUB")
+}
+
+func TestGitShallowSingleBranch(t *testing.T) {
+	fmt.Println("running: TestGitShallowSingleBranch")
+	home, remote := createLocalRemote(t)
+	seed, err := git.PlainOpen(home + "/seed")
+	require.NoError(t, err)
+	commitFile(t, seed, home+"/seed", "A", "a\n")
+	commitFile(t, seed, home+"/seed", "B", "b\n")
+	w, err := seed.Worktree()
+	require.NoError(t, err)
+	require.NoError(t, w.Checkout(&git.CheckoutOptions{Branch: plumbing.NewBranchReferenceName("dev"), Create: true}))
+	commitFile(t, seed, home+"/seed", "DEV", "dev\n")
+	require.NoError(t, w.Checkout(&git.CheckoutOptions{Branch: plumbing.NewBranchReferenceName("master")}))
+	require.NoError(t, seed.Push(&git.PushOptions{RemoteName: "origin", RefSpecs: []config.RefSpec{"refs/heads/*:refs/heads/*"}}))
+
+	v := GitConfig{Rep: "file://" + remote, Directory: "~/shallow", Depth: 1, SingleBranch: true}
+	err = GitClone(v, home, nil)
+	require.NoError(t, err)
+	r, err := git.PlainOpen(home + "/shallow")
+	require.NoError(t, err)
+	head, err := r.Head()
+	require.NoError(t, err)
+	shallow, err := r.Storer.Shallow()
+	require.NoError(t, err)
+	assert.Equal(t, []plumbing.Hash{head.Hash()}, shallow)
+	_, err = r.Reference(plumbing.NewRemoteReferenceName("origin", "dev"), false)
+	assert.Equal(t, plumbing.ErrReferenceNotFound, err)
+
+	commitFile(t, seed, home+"/seed", "C", "c\n")
+	require.NoError(t, seed.Push(&git.PushOptions{RemoteName: "origin"}))
+	_, err = GitReconcileRemote(v, home, nil)
+	require.NoError(t, err)
+	_, err = GitPull(v, home, nil)
+	require.NoError(t, err)
+	assert.FileExists(t, home+"/shallow/C")
+	_, err = r.Reference(plumbing.NewRemoteReferenceName(GIT_REMOTE_NAME, "dev"), false)
+	assert.Equal(t, plumbing.ErrReferenceNotFound, err)
+
+	// the history walks stop at the shallow commit
+	r, err = git.PlainOpen(home + "/shallow")
+	require.NoError(t, err)
+	old, err := r.Head()
+	require.NoError(t, err)
+	commitFile(t, seed, home+"/seed", "D", "d\n")
+	commitFile(t, seed, home+"/seed", "E", "e\n")
+	require.NoError(t, seed.Push(&git.PushOptions{RemoteName: "origin"}))
+	_, err = GitPull(v, home, nil)
+	require.NoError(t, err)
+	assert.FileExists(t, home+"/shallow/E")
+	commits, err := GitCommitsBetween(v, home, old.Hash(), GitHead(v, home))
+	require.NoError(t, err)
+	assert.Len(t, commits, 2)
+	r, err = git.PlainOpen(home + "/shallow")
+	require.NoError(t, err)
+	shallow, err = r.Storer.Shallow()
+	require.NoError(t, err)
+	assert.Equal(t, []plumbing.Hash{head.Hash()}, shallow)
+	c, err := r.CommitObject(head.Hash())
+	require.NoError(t, err)
+	assert.Equal(t, 1, c.NumParents())
+}
+
+func TestGitPinnedSingleBranch(t *testing.T) {
+	fmt.Println("running: TestGitPinnedSingleBranch")
+	home, remote := createLocalRemote(t)
+	seed, err := git.PlainOpen(home + "/seed")
+	require.NoError(t, err)
+	head, err := seed.Head()
+	require.NoError(t, err)
+	_, err = seed.CreateTag("v1", head.Hash(), nil)
+	require.NoError(t, err)
+	require.NoError(t, seed.Push(&git.PushOptions{
+		RemoteName: "origin",
+		RefSpecs:   []config.RefSpec{"refs/heads/*:refs/heads/*", "refs/tags/*:refs/tags/*"},
+	}))
+
+	v := GitConfig{Name: "pinned", Rep: "file://" + remote, Directory: "~/pinned", Tag: "v1", SingleBranch: true}
+	err = GitClone(v, home, nil)
+	require.NoError(t, err)
+	_, err = GitReconcileRemote(v, home, nil)
+	require.NoError(t, err)
+
+	r, err := git.PlainOpen(home + "/pinned")
+	require.NoError(t, err)
+	remoteConfig, err := r.Remote(GIT_REMOTE_NAME)
+	require.NoError(t, err)
+	for _, spec := range remoteConfig.Config().Fetch {
+		assert.NotContains(t, spec.String(), "refs/heads/HEAD")
+	}
+	_, err = GitPull(v, home, nil)
+	assert.NoError(t, err)
+}
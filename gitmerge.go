@@ -0,0 +1,195 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// The agent can not resolve conflicts, so histories are only combined if
+// both sides changed different files. A file changed on both sides stops
+// the sync before anything is written.
+
+func gitChangedPaths(from *object.Commit, to *object.Commit) (map[string]bool, error) {
+	fromTree, err := from.Tree()
+	if err != nil {
+		return nil, err
+	}
+	toTree, err := to.Tree()
+	if err != nil {
+		return nil, err
+	}
+	changes, err := object.DiffTree(fromTree, toTree)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make(map[string]bool)
+	for _, change := range changes {
+		if change.From.Name != "" {
+			paths[change.From.Name] = true
+		}
+		if change.To.Name != "" {
+			paths[change.To.Name] = true
+		}
+	}
+	return paths, nil
+}
+
+func gitConflicts(local map[string]bool, remote map[string]bool) []string {
+	conflicts := []string{}
+	for path := range local {
+		if remote[path] {
+			conflicts = append(conflicts, path)
+		}
+	}
+	sort.Strings(conflicts)
+	return conflicts
+}
+
+// gitWriteFiles puts the given paths of the commit into the worktree, a path
+// missing in the commit is deleted. With stage the index follows.
+func gitWriteFiles(w *git.Worktree, dir string, c *object.Commit, paths map[string]bool, stage bool) error {
+	tree, err := c.Tree()
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(paths))
+	for name := range paths {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		file, err := tree.File(name)
+		if err == object.ErrFileNotFound {
+			if stage {
+				_, err = w.Remove(name)
+			} else {
+				err = os.Remove(filepath.Join(dir, name))
+				if os.IsNotExist(err) {
+					err = nil
+				}
+			}
+			if err != nil {
+				return err
+			}
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		err = gitWriteFile(filepath.Join(dir, name), file)
+		if err != nil {
+			return err
+		}
+		if stage {
+			_, err = w.Add(name)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func gitWriteFile(target string, file *object.File) error {
+	content, err := file.Contents()
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(target), 0755)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(target)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if file.Mode == filemode.Symlink {
+		return os.Symlink(content, target)
+	}
+	mode, err := file.Mode.ToOSFileMode()
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(target, []byte(content), mode.Perm())
+}
+
+// the commits only the local branch has, oldest first
+func gitLocalCommits(local *object.Commit, base plumbing.Hash) ([]*object.Commit, error) {
+	commits := []*object.Commit{}
+	c := local
+	for c.Hash != base {
+		commits = append([]*object.Commit{c}, commits...)
+		if c.NumParents() == 0 {
+			return nil, errors.New(ERROR_GIT_REBASE + local.Hash.String())
+		}
+		parent, err := c.Parent(0)
+		if err != nil {
+			return nil, err
+		}
+		c = parent
+	}
+	return commits, nil
+}
+
+// gitRebase moves the branch to upstream and commits the local commits on top
+// again. Without commits it is a fast-forward which keeps the local changes.
+func gitRebase(w *git.Worktree, dir string, v GitConfig, upstream *object.Commit, remotePaths map[string]bool, commits []*object.Commit) (plumbing.Hash, error) {
+	err := gitWriteFiles(w, dir, upstream, remotePaths, false)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	err = w.Reset(&git.ResetOptions{
+		Commit: upstream.Hash,
+		Mode:   git.MixedReset,
+	})
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	head := upstream.Hash
+	for _, c := range commits {
+		parent, err := c.Parent(0)
+		if err != nil {
+			return head, err
+		}
+		paths, err := gitChangedPaths(parent, c)
+		if err != nil {
+			return head, err
+		}
+		err = gitWriteFiles(w, dir, c, paths, true)
+		if err != nil {
+			return head, err
+		}
+		author := c.Author
+		head, err = w.Commit(c.Message, &git.CommitOptions{
+			Author:    &author,
+			Committer: gitSignature(v),
+		})
+		if err != nil {
+			return head, err
+		}
+	}
+	return head, nil
+}
+
+func gitMerge(w *git.Worktree, dir string, v GitConfig, local plumbing.Hash, upstream *object.Commit, remotePaths map[string]bool, branch string) (plumbing.Hash, error) {
+	err := gitWriteFiles(w, dir, upstream, remotePaths, true)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return w.Commit(GIT_MERGE_MESSAGE+GIT_REMOTE_NAME+"/"+branch, &git.CommitOptions{
+		Author:  gitSignature(v),
+		Parents: []plumbing.Hash{local, upstream.Hash},
+	})
+}
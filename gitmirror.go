@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// GitMirror keeps a bare copy of every ref of the remote. Refs that are
+// deleted or force pushed upstream are kept below GIT_MIRROR_BACKUP before
+// they are changed, so nothing is lost by a later fetch.
+func GitMirror(v GitConfig, home string, progress io.Writer) (string, error) {
+	path := strings.ReplaceAll(v.Directory, HOME, home)
+	Sugar.Info("Mirroring to: ", path)
+
+	auth, err := gitAuth(v)
+	if err != nil {
+		return "", err
+	}
+
+	r, err := git.PlainOpen(path)
+	if err == git.ErrRepositoryNotExists {
+		Sugar.Debug("Creating bare repository: ", path)
+		r, err = git.PlainInit(path, true)
+	}
+	if err != nil {
+		return "", err
+	}
+	// a mirror moves every branch, a checkout would no longer match them
+	_, err = r.Worktree()
+	if err != git.ErrIsBareRepository {
+		return "", errors.New(ERROR_GIT_MIRROR_BARE + path)
+	}
+	reconciled, err := GitReconcileRemote(v, home, progress)
+	if err != nil {
+		return reconciled, err
+	}
+	remote, err := r.Remote(GIT_REMOTE_NAME)
+	if err != nil {
+		return "", err
+	}
+
+	remoteRefs, err := remote.List(&git.ListOptions{Auth: auth})
+	if err != nil {
+		return "", err
+	}
+	local, err := gitMirrorRefs(r)
+	if err != nil {
+		return "", err
+	}
+
+	upstream := make(map[plumbing.ReferenceName]plumbing.Hash)
+	refspecs := []config.RefSpec{}
+	var head *plumbing.Reference
+	for _, ref := range remoteRefs {
+		if ref.Name() == plumbing.HEAD {
+			head = ref
+			continue
+		}
+		if ref.Type() != plumbing.HashReference || gitMirrorProtected(ref.Name()) {
+			continue
+		}
+		upstream[ref.Name()] = ref.Hash()
+		refspecs = append(refspecs, config.RefSpec("+"+ref.Name().String()+":"+ref.Name().String()))
+	}
+
+	var buffer bytes.Buffer
+	buffer.WriteString(reconciled)
+	prefix := GIT_MIRROR_BACKUP + time.Now().UTC().Format(GIT_BACKUP_FORMAT) + "/"
+	names := make([]string, 0, len(local))
+	for name := range local {
+		names = append(names, name.String())
+	}
+	sort.Strings(names)
+
+	// every ref which is not known to move forward is kept before the
+	// fetch, an interrupted fetch must not lose the old state
+	backups := make(map[plumbing.ReferenceName]plumbing.ReferenceName)
+	for _, n := range names {
+		name := plumbing.ReferenceName(n)
+		old := local[name]
+		hash, ok := upstream[name]
+		if ok && hash == old {
+			continue
+		}
+		if ok {
+			forward, err := gitIsAncestor(r, old, hash)
+			if err != nil {
+				return buffer.String(), err
+			}
+			if forward {
+				buffer.WriteString("Updated: " + n + "\n")
+				continue
+			}
+		}
+
+		backup := plumbing.ReferenceName(prefix + strings.TrimPrefix(n, "refs/"))
+		err = r.Storer.SetReference(plumbing.NewHashReference(backup, old))
+		if err != nil {
+			return buffer.String(), err
+		}
+		backups[name] = backup
+	}
+
+	if len(refspecs) > 0 {
+		err = remote.Fetch(&git.FetchOptions{
+			RemoteName: GIT_REMOTE_NAME,
+			RefSpecs:   refspecs,
+			Auth:       auth,
+			Progress:   progress,
+			Tags:       git.NoTags,
+		})
+		if err != nil && err != git.NoErrAlreadyUpToDate {
+			return buffer.String(), err
+		}
+	}
+
+	for _, n := range names {
+		name := plumbing.ReferenceName(n)
+		backup, kept := backups[name]
+		if !kept {
+			continue
+		}
+		hash, ok := upstream[name]
+		if ok {
+			// the new commit is only known after the fetch
+			forward, err := gitIsAncestor(r, local[name], hash)
+			if err != nil {
+				return buffer.String(), err
+			}
+			if forward {
+				err = r.Storer.RemoveReference(backup)
+				if err != nil {
+					return buffer.String(), err
+				}
+				buffer.WriteString("Updated: " + n + "\n")
+				continue
+			}
+			buffer.WriteString("Forced: " + n + " old state kept in " + backup.String() + "\n")
+			continue
+		}
+
+		// prune by hand, the backup has to exist before the ref is gone
+		err = r.Storer.RemoveReference(name)
+		if err != nil {
+			return buffer.String(), err
+		}
+		buffer.WriteString("Deleted: " + n + " old state kept in " + backup.String() + "\n")
+	}
+
+	for name := range upstream {
+		if _, ok := local[name]; !ok {
+			buffer.WriteString("Created: " + name.String() + "\n")
+		}
+	}
+
+	if head != nil && head.Type() == plumbing.SymbolicReference {
+		err = r.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, head.Target()))
+		if err != nil {
+			return buffer.String(), err
+		}
+	}
+
+	buffer.WriteString("Mirrored refs: " + strconv.Itoa(len(upstream)))
+	return buffer.String(), nil
+}
+
+func gitMirrorProtected(name plumbing.ReferenceName) bool {
+	return strings.HasPrefix(name.String(), GIT_MIRROR_BACKUP)
+}
+
+func gitMirrorRefs(r *git.Repository) (map[plumbing.ReferenceName]plumbing.Hash, error) {
+	iter, err := r.References()
+	if err != nil {
+		return nil, err
+	}
+	refs := make(map[plumbing.ReferenceName]plumbing.Hash)
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() != plumbing.HashReference || gitMirrorProtected(ref.Name()) {
+			return nil
+		}
+		if ref.Name().IsRemote() || ref.Name() == plumbing.HEAD {
+			return nil
+		}
+		refs[ref.Name()] = ref.Hash()
+		return nil
+	})
+	return refs, err
+}
+
+// refs can point to tags or trees too, those are never a fast-forward
+func gitIsAncestor(r *git.Repository, old plumbing.Hash, hash plumbing.Hash) (bool, error) {
+	oldCommit, err := r.CommitObject(old)
+	if err == plumbing.ErrObjectNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	newCommit, err := r.CommitObject(hash)
+	if err == plumbing.ErrObjectNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return oldCommit.IsAncestor(newCommit)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func mirrorBackups(t *testing.T, r *git.Repository) map[string]plumbing.Hash {
+	iter, err := r.References()
+	require.NoError(t, err)
+	backups := make(map[string]plumbing.Hash)
+	require.NoError(t, iter.ForEach(func(ref *plumbing.Reference) error {
+		if strings.HasPrefix(ref.Name().String(), GIT_MIRROR_BACKUP) {
+			// strip the timestamp
+			parts := strings.SplitN(strings.TrimPrefix(ref.Name().String(), GIT_MIRROR_BACKUP), "/", 2)
+			backups[parts[1]] = ref.Hash()
+		}
+		return nil
+	}))
+	return backups
+}
+
+func TestGitMirror(t *testing.T) {
+	fmt.Println("running: TestGitMirror")
+	home, remote := createLocalRemote(t)
+	seed, err := git.PlainOpen(home + "/seed")
+	require.NoError(t, err)
+	head, err := seed.Head()
+	require.NoError(t, err)
+	first := head.Hash()
+	_, err = seed.CreateTag("v1", first, nil)
+	require.NoError(t, err)
+	w, err := seed.Worktree()
+	require.NoError(t, err)
+	require.NoError(t, w.Checkout(&git.CheckoutOptions{Branch: plumbing.NewBranchReferenceName("dev"), Create: true}))
+	dev := commitFile(t, seed, home+"/seed", "DEV", "dev\n")
+	require.NoError(t, w.Checkout(&git.CheckoutOptions{Branch: plumbing.NewBranchReferenceName("master")}))
+	push := func(refspecs ...config.RefSpec) {
+		err := seed.Push(&git.PushOptions{RemoteName: "origin", RefSpecs: refspecs})
+		if err != git.NoErrAlreadyUpToDate {
+			require.NoError(t, err)
+		}
+	}
+	push("refs/heads/*:refs/heads/*", "refs/tags/*:refs/tags/*")
+
+	v := GitConfig{Name: "mirror", Rep: "file://" + remote, Directory: "~/mirror.git"}
+	str, err := GitMirror(v, home, nil)
+	require.NoError(t, err)
+	assert.Contains(t, str, "Created: refs/heads/dev")
+	assert.Contains(t, str, "Mirrored refs: 3")
+
+	mirror, err := git.PlainOpen(home + "/mirror.git")
+	require.NoError(t, err)
+	ref, err := mirror.Reference(plumbing.NewBranchReferenceName("dev"), false)
+	require.NoError(t, err)
+	assert.Equal(t, dev, ref.Hash())
+	ref, err = mirror.Reference(plumbing.NewTagReferenceName("v1"), false)
+	require.NoError(t, err)
+	assert.Equal(t, first, ref.Hash())
+	ref, err = mirror.Head()
+	require.NoError(t, err)
+	assert.Equal(t, first, ref.Hash())
+
+	// fast-forwards are not backed up
+	second := commitFile(t, seed, home+"/seed", "SECOND", "second\n")
+	push()
+	str, err = GitMirror(v, home, nil)
+	require.NoError(t, err)
+	assert.Contains(t, str, "Updated: refs/heads/master")
+	assert.Empty(t, mirrorBackups(t, mirror))
+
+	// force push of master and deletion of dev upstream
+	require.NoError(t, w.Reset(&git.ResetOptions{Commit: first, Mode: git.HardReset}))
+	forced := commitFile(t, seed, home+"/seed", "FORCED", "forced\n")
+	push("+refs/heads/master:refs/heads/master", ":refs/heads/dev")
+
+	str, err = GitMirror(v, home, nil)
+	require.NoError(t, err)
+	assert.Contains(t, str, "Forced: refs/heads/master")
+	assert.Contains(t, str, "Deleted: refs/heads/dev")
+
+	ref, err = mirror.Reference(plumbing.NewBranchReferenceName("master"), false)
+	require.NoError(t, err)
+	assert.Equal(t, forced, ref.Hash())
+	_, err = mirror.Reference(plumbing.NewBranchReferenceName("dev"), false)
+	assert.Equal(t, plumbing.ErrReferenceNotFound, err)
+
+	backups := mirrorBackups(t, mirror)
+	assert.Equal(t, second, backups["heads/master"])
+	assert.Equal(t, dev, backups["heads/dev"])
+	_, err = mirror.CommitObject(dev)
+	assert.NoError(t, err)
+
+	// backups survive later runs
+	str, err = GitMirror(v, home, nil)
+	require.NoError(t, err)
+	assert.Len(t, mirrorBackups(t, mirror), 2)
+	assert.Contains(t, str, "Mirrored refs: 2")
+
+	// a checkout is never turned into a mirror
+	_, err = git.PlainClone(home+"/checkout", false, &git.CloneOptions{URL: remote})
+	require.NoError(t, err)
+	_, err = GitMirror(GitConfig{Name: "checkout", Rep: "file://" + remote, Directory: "~/checkout"}, home, nil)
+	assert.EqualError(t, err, ERROR_GIT_MIRROR_BARE+home+"/checkout")
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+// GitReconcileRemote brings agent_remote in line with the configured
+// repository. A new or changed url is only used if it shares history with
+// the local checkout, otherwise the mismatch is reported.
+func GitReconcileRemote(v GitConfig, home string, progress io.Writer) (string, error) {
+	path := strings.ReplaceAll(v.Directory, HOME, home)
+	r, err := git.PlainOpen(path)
+	if err != nil {
+		return "", err
+	}
+
+	var buffer bytes.Buffer
+	cfg, err := r.Config()
+	if err != nil {
+		return "", err
+	}
+	stale := false
+	for _, name := range gitRecordedRemotes(cfg) {
+		if name == GIT_REMOTE_NAME {
+			continue
+		}
+		stale = true
+		gitForgetRemote(cfg, name)
+		if _, ok := cfg.Remotes[name]; ok {
+			delete(cfg.Remotes, name)
+			buffer.WriteString("Removed remote: " + name + "\n")
+		}
+	}
+	if stale {
+		err = r.SetConfig(cfg)
+		if err != nil {
+			return buffer.String(), err
+		}
+	}
+
+	remote, err := r.Remote(GIT_REMOTE_NAME)
+	if err != nil && err != git.ErrRemoteNotFound {
+		return buffer.String(), err
+	}
+	fetch, err := gitFetchRefSpec(r, v)
+	if err != nil {
+		return buffer.String(), err
+	}
+
+	if remote == nil {
+		err = gitCheckSameRepository(r, v, progress)
+		if err != nil {
+			return buffer.String(), err
+		}
+		Sugar.Debug("Adding remote: ", GIT_REMOTE_NAME)
+		_, err = r.CreateRemote(&config.RemoteConfig{
+			Name:  GIT_REMOTE_NAME,
+			URLs:  []string{v.Rep},
+			Fetch: []config.RefSpec{fetch},
+		})
+		if err != nil {
+			return buffer.String(), err
+		}
+		err = gitRecordRemote(r, GIT_REMOTE_NAME)
+		if err != nil {
+			return buffer.String(), err
+		}
+		buffer.WriteString("Added remote: " + v.Rep + "\n")
+		return buffer.String(), nil
+	}
+
+	cfg, err = r.Config()
+	if err != nil {
+		return buffer.String(), err
+	}
+	remoteConfig := cfg.Remotes[GIT_REMOTE_NAME]
+	changed := false
+	old := ""
+	if len(remoteConfig.URLs) > 0 {
+		old = remoteConfig.URLs[0]
+	}
+	if len(remoteConfig.URLs) != 1 || old != v.Rep {
+		err = gitCheckSameRepository(r, v, progress)
+		if err != nil {
+			return buffer.String(), err
+		}
+		remoteConfig.URLs = []string{v.Rep}
+		changed = true
+		Sugar.Info("Remote moved from ", old, " to ", v.Rep)
+		buffer.WriteString("Updated remote: " + old + " -> " + v.Rep + "\n")
+	}
+	if len(remoteConfig.Fetch) != 1 || remoteConfig.Fetch[0] != fetch {
+		remoteConfig.Fetch = []config.RefSpec{fetch}
+		changed = true
+		buffer.WriteString("Updated fetch refspec: " + fetch.String() + "\n")
+	}
+
+	if changed {
+		err = r.SetConfig(cfg)
+	}
+	return buffer.String(), err
+}
+
+// the remotes the agent created are listed in the repository config, only
+// those are ever removed again
+func gitRecordedRemotes(cfg *config.Config) []string {
+	return cfg.Raw.Section(GIT_CONFIG_SECTION).Options.GetAll(GIT_CONFIG_REMOTE)
+}
+
+func gitRecordRemote(r *git.Repository, name string) error {
+	cfg, err := r.Config()
+	if err != nil {
+		return err
+	}
+	for _, recorded := range gitRecordedRemotes(cfg) {
+		if recorded == name {
+			return nil
+		}
+	}
+	cfg.Raw.Section(GIT_CONFIG_SECTION).AddOption(GIT_CONFIG_REMOTE, name)
+	return r.SetConfig(cfg)
+}
+
+func gitForgetRemote(cfg *config.Config, name string) {
+	section := cfg.Raw.Section(GIT_CONFIG_SECTION)
+	recorded := section.Options.GetAll(GIT_CONFIG_REMOTE)
+	section.RemoveOption(GIT_CONFIG_REMOTE)
+	for _, value := range recorded {
+		if value != name {
+			section.AddOption(GIT_CONFIG_REMOTE, value)
+		}
+	}
+}
+
+// a single branch repository only fetches the configured or checked out
+// branch, like git clone --single-branch does
+func gitFetchRefSpec(r *git.Repository, v GitConfig) (config.RefSpec, error) {
+	branch := "*"
+	if v.SingleBranch {
+		branch = v.Branch
+		if branch == "" {
+			head, err := r.Head()
+			if err != nil {
+				return "", err
+			}
+			// a pinned tag or commit has no branch to narrow to
+			branch = "*"
+			if head.Name().IsBranch() {
+				branch = head.Name().Short()
+			}
+		}
+	}
+	return config.RefSpec("+refs/heads/" + branch + ":refs/remotes/" + GIT_REMOTE_NAME + "/" + branch), nil
+}
+
+// the configured url is fetched into a candidate namespace, it is the same
+// repository if any of its branches shares a commit with HEAD
+func gitCheckSameRepository(r *git.Repository, v GitConfig, progress io.Writer) error {
+	head, err := r.Head()
+	if err == plumbing.ErrReferenceNotFound {
+		// nothing checked out yet, e.g. a new mirror
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	auth, err := gitAuth(v)
+	if err != nil {
+		return err
+	}
+	defer gitRemoveCandidate(r)
+
+	candidate := &config.RemoteConfig{
+		Name: GIT_REMOTE_CANDIDATE,
+		URLs: []string{v.Rep},
+	}
+	err = gitFetch(r, candidate, &git.FetchOptions{
+		RefSpecs: []config.RefSpec{config.RefSpec("+refs/heads/*:" + GIT_CANDIDATE_REFS + "*")},
+		Auth:     auth,
+		Progress: progress,
+		Tags:     git.NoTags,
+	})
+	if err == transport.ErrEmptyRemoteRepository {
+		// a new empty remote can not hold a different history
+		return nil
+	} else if err != nil && err != git.NoErrAlreadyUpToDate {
+		return err
+	}
+
+	local, err := gitAncestors(r, head.Hash())
+	if err != nil {
+		return err
+	}
+	refs, err := r.References()
+	if err != nil {
+		return err
+	}
+	same := false
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		if !strings.HasPrefix(ref.Name().String(), GIT_CANDIDATE_REFS) {
+			return nil
+		}
+		err := gitWalk(r, ref.Hash(), func(c *object.Commit) error {
+			if _, ok := local[c.Hash]; ok {
+				same = true
+				return storer.ErrStop
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if same {
+			return storer.ErrStop
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if !same {
+		path := ""
+		if w, err := r.Worktree(); err == nil {
+			path = w.Filesystem.Root()
+		}
+		RecordEvent(EVENT_LEVEL_ERROR, EVENT_SOURCE_GIT, GIT_MESSAGE_MISMATCH+v.Name+" "+path+" is not "+v.Rep)
+		return errors.New(ERROR_GIT_MISMATCH + v.Name)
+	}
+	return nil
+}
+
+func gitRemoveCandidate(r *git.Repository) {
+	refs, err := r.References()
+	if err != nil {
+		Sugar.Debug(err)
+		return
+	}
+	candidates := []plumbing.ReferenceName{}
+	refs.ForEach(func(ref *plumbing.Reference) error {
+		if strings.HasPrefix(ref.Name().String(), GIT_CANDIDATE_REFS) {
+			candidates = append(candidates, ref.Name())
+		}
+		return nil
+	})
+	for _, name := range candidates {
+		err = r.Storer.RemoveReference(name)
+		if err != nil {
+			Sugar.Debug(err)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+)
+
+// go-git records the boundary of a shallow clone, but its history walks do
+// not stop there. The walks of the agent stop at the shallow commits and a
+// fetch only offers the tips of the local refs, the server sends the
+// history up to them.
+
+func gitShallow(r *git.Repository) (map[plumbing.Hash]bool, error) {
+	hashes, err := r.Storer.Shallow()
+	if err != nil {
+		return nil, err
+	}
+	shallow := make(map[plumbing.Hash]bool)
+	for _, h := range hashes {
+		shallow[h] = true
+	}
+	return shallow, nil
+}
+
+// gitWalk visits the history of from like r.Log, the parents of shallow
+// commits are not read
+func gitWalk(r *git.Repository, from plumbing.Hash, cb func(*object.Commit) error) error {
+	shallow, err := gitShallow(r)
+	if err != nil {
+		return err
+	}
+	seen := make(map[plumbing.Hash]bool)
+	stack := []plumbing.Hash{from}
+	for len(stack) > 0 {
+		h := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if seen[h] {
+			continue
+		}
+		seen[h] = true
+
+		c, err := r.CommitObject(h)
+		if err != nil {
+			return err
+		}
+		err = cb(c)
+		if err == storer.ErrStop {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if shallow[h] {
+			continue
+		}
+		for i := len(c.ParentHashes) - 1; i >= 0; i-- {
+			stack = append(stack, c.ParentHashes[i])
+		}
+	}
+	return nil
+}
+
+// the tips of the local refs can not be read as commits, so go-git sends
+// them as haves without walking their history. Only forced refspecs work,
+// the fast-forward check of the others reads the old tip.
+type shallowFetchStorage struct {
+	*filesystem.Storage
+	tips map[plumbing.Hash]bool
+}
+
+func (s *shallowFetchStorage) EncodedObject(t plumbing.ObjectType, h plumbing.Hash) (plumbing.EncodedObject, error) {
+	if t == plumbing.CommitObject && s.tips[h] {
+		return nil, plumbing.ErrObjectNotFound
+	}
+	return s.Storage.EncodedObject(t, h)
+}
+
+// gitFetch fetches like remote.Fetch and works for shallow clones too
+func gitFetch(r *git.Repository, remote *config.RemoteConfig, o *git.FetchOptions) error {
+	shallow, err := gitShallow(r)
+	if err != nil {
+		return err
+	}
+	storage, ok := r.Storer.(*filesystem.Storage)
+	if len(shallow) == 0 || !ok {
+		return git.NewRemote(r.Storer, remote).Fetch(o)
+	}
+
+	fetchStorage := &shallowFetchStorage{
+		Storage: storage,
+		tips:    make(map[plumbing.Hash]bool),
+	}
+	refs, err := r.References()
+	if err != nil {
+		return err
+	}
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() == plumbing.HashReference {
+			fetchStorage.tips[ref.Hash()] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return git.NewRemote(fetchStorage, remote).Fetch(o)
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v3"
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+type GitCommit struct {
+	Hash    string    `json:"hash"`
+	Author  string    `json:"author"`
+	Time    time.Time `json:"time"`
+	Message string    `json:"message"`
+}
+
+type GitStatus struct {
+	Name       string      `json:"name"`
+	Directory  string      `json:"directory"`
+	Branch     string      `json:"branch"`
+	Head       string      `json:"head"`
+	Ahead      int         `json:"ahead"`
+	Behind     int         `json:"behind"`
+	Dirty      []string    `json:"dirty"`
+	LastUpdate time.Time   `json:"last_update"`
+	Commits    []GitCommit `json:"commits"`
+	Error      string      `json:"error,omitempty"`
+}
+
+func GetGitStatus(db *badger.DB, v GitConfig, home string) GitStatus {
+	status := GitStatus{
+		Name:      v.Name,
+		Directory: strings.ReplaceAll(v.Directory, HOME, home),
+		Dirty:     []string{},
+	}
+
+	last, err := GetLastGitUpdate(db, v.Name)
+	if err == nil {
+		status.LastUpdate = last
+	}
+	status.Commits, err = GetGitCommits(db, v.Name)
+	if err != nil {
+		status.Commits = []GitCommit{}
+	}
+
+	err = gitStatus(&status, v)
+	if err != nil {
+		status.Error = err.Error()
+	}
+	return status
+}
+
+// only the local view is reported, the remote is not fetched for a status
+func gitStatus(status *GitStatus, v GitConfig) error {
+	r, err := git.PlainOpen(status.Directory)
+	if err != nil {
+		return err
+	}
+	head, err := r.Head()
+	if err != nil {
+		return err
+	}
+	status.Head = head.Hash().String()
+
+	branch := v.Branch
+	if head.Name().IsBranch() {
+		status.Branch = head.Name().Short()
+		branch = status.Branch
+	}
+
+	w, err := r.Worktree()
+	if err != nil {
+		return err
+	}
+	status.Dirty, err = gitDirtyFiles(w)
+	if err != nil {
+		return err
+	}
+
+	if branch == "" {
+		return nil
+	}
+	remoteRef, err := r.Reference(plumbing.NewRemoteReferenceName(GIT_REMOTE_NAME, branch), true)
+	if err == plumbing.ErrReferenceNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	status.Ahead, status.Behind, err = gitAheadBehind(r, head.Hash(), remoteRef.Hash())
+	return err
+}
+
+// gitAheadBehind walks both sides newest first and marks which side reaches a
+// commit, like git rev-list --left-right does. The walk ends once only
+// shared commits are left, so the history below the merge base is not read.
+func gitAheadBehind(r *git.Repository, local plumbing.Hash, remote plumbing.Hash) (int, int, error) {
+	if local == remote {
+		return 0, 0, nil
+	}
+	localCommit, err := r.CommitObject(local)
+	if err != nil {
+		return 0, 0, err
+	}
+	remoteCommit, err := r.CommitObject(remote)
+	if err != nil {
+		return 0, 0, err
+	}
+	shallow, err := gitShallow(r)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	flags := map[plumbing.Hash]int{local: GIT_SIDE_LOCAL, remote: GIT_SIDE_REMOTE}
+	queue := []*object.Commit{localCommit, remoteCommit}
+	queued := map[plumbing.Hash]bool{local: true, remote: true}
+	for gitQueueUnshared(queue, flags) {
+		sort.Slice(queue, func(i, j int) bool {
+			return queue[i].Committer.When.After(queue[j].Committer.When)
+		})
+		c := queue[0]
+		queue = queue[1:]
+		delete(queued, c.Hash)
+
+		flag := flags[c.Hash]
+		if shallow[c.Hash] {
+			continue
+		}
+		err = c.Parents().ForEach(func(parent *object.Commit) error {
+			if flags[parent.Hash]|flag == flags[parent.Hash] {
+				return nil
+			}
+			flags[parent.Hash] |= flag
+			if !queued[parent.Hash] {
+				queued[parent.Hash] = true
+				queue = append(queue, parent)
+			}
+			return nil
+		})
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+
+	ahead, behind := 0, 0
+	for _, flag := range flags {
+		switch flag {
+		case GIT_SIDE_LOCAL:
+			ahead++
+		case GIT_SIDE_REMOTE:
+			behind++
+		}
+	}
+	return ahead, behind, nil
+}
+
+func gitQueueUnshared(queue []*object.Commit, flags map[plumbing.Hash]int) bool {
+	for _, c := range queue {
+		if flags[c.Hash] != GIT_SIDE_BOTH {
+			return true
+		}
+	}
+	return false
+}
+
+func gitAncestors(r *git.Repository, hash plumbing.Hash) (map[plumbing.Hash]struct{}, error) {
+	commits := make(map[plumbing.Hash]struct{})
+	err := gitWalk(r, hash, func(c *object.Commit) error {
+		commits[c.Hash] = struct{}{}
+		return nil
+	})
+	return commits, err
+}
+
+func UpdateLastGitUpdate(db *badger.DB, name string, timestamp time.Time) (bool, error) {
+	return Put(db, STORE_GIT_UPDATE+name, timestamp.Format(time.RFC3339Nano))
+}
+
+func GetLastGitUpdate(db *badger.DB, name string) (time.Time, error) {
+	return getTimestamp(db, STORE_GIT_UPDATE+name)
+}
+
+func recordGitUpdate(name string) {
+	_, err := UpdateLastGitUpdate(AgentConfiguration.DB, name, time.Now())
+	if err != nil {
+		Sugar.Debug(ERROR_GIT_UPDATE, err)
+	}
+}
+
+func GitHead(v GitConfig, home string) plumbing.Hash {
+	r, err := git.PlainOpen(strings.ReplaceAll(v.Directory, HOME, home))
+	if err != nil {
+		return plumbing.ZeroHash
+	}
+	head, err := r.Head()
+	if err != nil {
+		return plumbing.ZeroHash
+	}
+	return head.Hash()
+}
+
+// GitCommitsBetween lists the commits reachable from head but not from old,
+// newest first and at most GIT_COMMIT_LIMIT of them
+func GitCommitsBetween(v GitConfig, home string, old plumbing.Hash, head plumbing.Hash) ([]GitCommit, error) {
+	r, err := git.PlainOpen(strings.ReplaceAll(v.Directory, HOME, home))
+	if err != nil {
+		return nil, err
+	}
+	known := make(map[plumbing.Hash]struct{})
+	if !old.IsZero() {
+		known, err = gitAncestors(r, old)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	commits := []GitCommit{}
+	err = gitWalk(r, head, func(c *object.Commit) error {
+		if _, ok := known[c.Hash]; ok {
+			return nil
+		}
+		commits = append(commits, GitCommit{
+			Hash:    c.Hash.String(),
+			Author:  c.Author.Name + " <" + c.Author.Email + ">",
+			Time:    c.Author.When,
+			Message: strings.TrimSpace(c.Message),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(commits, func(i, j int) bool {
+		return commits[i].Time.After(commits[j].Time)
+	})
+	if len(commits) > GIT_COMMIT_LIMIT {
+		commits = commits[:GIT_COMMIT_LIMIT]
+	}
+	return commits, nil
+}
+
+func FormatGitCommits(commits []GitCommit) string {
+	var buffer bytes.Buffer
+	buffer.WriteString("Pulled commits: " + strconv.Itoa(len(commits)) + "\n")
+	for _, c := range commits {
+		subject := strings.SplitN(c.Message, "\n", 2)[0]
+		buffer.WriteString(c.Hash[:7] + " " + c.Author + " " + subject + "\n")
+	}
+	return buffer.String()
+}
+
+func PutGitCommits(db *badger.DB, name string, commits []GitCommit) (bool, error) {
+	value, err := json.Marshal(commits)
+	if err != nil {
+		return false, err
+	}
+	return Put(db, STORE_GIT_COMMITS+name, string(value))
+}
+
+func GetGitCommits(db *badger.DB, name string) ([]GitCommit, error) {
+	commits := []GitCommit{}
+	value, err := Get(db, STORE_GIT_COMMITS+name)
+	if err != nil {
+		return commits, err
+	}
+	err = json.Unmarshal([]byte(value), &commits)
+	return commits, err
+}
+
+func UpdateGitHooked(db *badger.DB, name string, head plumbing.Hash) (bool, error) {
+	return Put(db, STORE_GIT_HOOKED+name, head.String())
+}
+
+func GetGitHooked(db *badger.DB, name string) (plumbing.Hash, error) {
+	value, err := Get(db, STORE_GIT_HOOKED+name)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return plumbing.NewHash(value), nil
+}
+
+func recordGitHooked(name string, head plumbing.Hash) {
+	_, err := UpdateGitHooked(AgentConfiguration.DB, name, head)
+	if err != nil {
+		Sugar.Debug(ERROR_GIT_UPDATE, err)
+	}
+}
+
+func recordGitCommits(name string, commits []GitCommit) {
+	_, err := PutGitCommits(AgentConfiguration.DB, name, commits)
+	if err != nil {
+		Sugar.Debug(ERROR_GIT_UPDATE, err)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGitStatusGetGitStatus(t *testing.T) {
+	fmt.Println("running: TestGitStatusGetGitStatus")
+	db := InitDB("", "", true)
+	t.Cleanup(func() { db.Close() })
+
+	home, remote := createLocalRemote(t)
+	work, err := git.PlainClone(home+"/work", false, &git.CloneOptions{URL: remote})
+	require.NoError(t, err)
+	require.NoError(t, GitCreateRemote("~/work", home, remote))
+	v := GitConfig{Name: "status", Rep: remote, Directory: "~/work"}
+
+	status := GetGitStatus(db, v, home)
+	assert.Empty(t, status.Error)
+	assert.Equal(t, "master", status.Branch)
+	assert.Equal(t, remoteHead(t, remote).String(), status.Head)
+	assert.Equal(t, 0, status.Ahead)
+	assert.Equal(t, 0, status.Behind)
+	assert.Empty(t, status.Dirty)
+	assert.True(t, status.LastUpdate.IsZero())
+
+	// one local commit, two upstream commits fetched into agent_remote
+	local := commitFile(t, work, home+"/work", "LOCAL", "local\n")
+	seed, err := git.PlainOpen(home + "/seed")
+	require.NoError(t, err)
+	commitFile(t, seed, home+"/seed", "A", "a\n")
+	commitFile(t, seed, home+"/seed", "B", "b\n")
+	require.NoError(t, seed.Push(&git.PushOptions{RemoteName: "origin"}))
+	require.NoError(t, work.Fetch(&git.FetchOptions{RemoteName: GIT_REMOTE_NAME}))
+	require.NoError(t, ioutil.WriteFile(home+"/work/README", []byte("dirty\n"), 0600))
+
+	now := time.Now()
+	_, err = UpdateLastGitUpdate(db, v.Name, now)
+	require.NoError(t, err)
+
+	status = GetGitStatus(db, v, home)
+	assert.Empty(t, status.Error)
+	assert.Equal(t, local.String(), status.Head)
+	assert.Equal(t, 1, status.Ahead)
+	assert.Equal(t, 2, status.Behind)
+	assert.Equal(t, []string{"README"}, status.Dirty)
+	assert.True(t, now.Equal(status.LastUpdate))
+
+	// merging the remote leaves the local commit and the merge ahead
+	w, err := work.Worktree()
+	require.NoError(t, err)
+	remoteRef, err := work.Reference(plumbing.NewRemoteReferenceName(GIT_REMOTE_NAME, "master"), true)
+	require.NoError(t, err)
+	_, err = w.Commit("merge", &git.CommitOptions{
+		All:     true,
+		Author:  &object.Signature{Name: "test", Email: "test@localhost", When: time.Now()},
+		Parents: []plumbing.Hash{local, remoteRef.Hash()},
+	})
+	require.NoError(t, err)
+	status = GetGitStatus(db, v, home)
+	assert.Equal(t, 2, status.Ahead)
+	assert.Equal(t, 0, status.Behind)
+
+	status = GetGitStatus(db, GitConfig{Name: "missing", Directory: "~/missing"}, home)
+	assert.Equal(t, git.ErrRepositoryNotExists.Error(), status.Error)
+}
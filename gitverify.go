@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"strconv"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+func gitCheckVerify(v GitConfig) error {
+	switch v.Verify {
+	case "":
+		return nil
+	case GIT_VERIFY_ALL, GIT_VERIFY_TIP:
+		if v.TrustedKeys == "" {
+			return errors.New(ERROR_GIT_TRUSTED_KEYS + v.Name)
+		}
+		return nil
+	default:
+		return errors.New(ERROR_GIT_VERIFY + v.Verify)
+	}
+}
+
+// GitVerifyCommits checks the commits between head and target, or only the
+// target for a tip verification. A rejection is recorded as security event.
+func GitVerifyCommits(r *git.Repository, v GitConfig, head plumbing.Hash, target plumbing.Hash) (int, error) {
+	commits := []*object.Commit{}
+	if v.Verify == GIT_VERIFY_ALL && !head.IsZero() {
+		known, err := gitAncestors(r, head)
+		if err != nil {
+			return 0, err
+		}
+		err = gitWalk(r, target, func(c *object.Commit) error {
+			if _, ok := known[c.Hash]; !ok {
+				commits = append(commits, c)
+			}
+			return nil
+		})
+		if err != nil {
+			return 0, err
+		}
+	} else {
+		c, err := r.CommitObject(target)
+		if err != nil {
+			return 0, err
+		}
+		commits = append(commits, c)
+	}
+
+	for _, c := range commits {
+		_, err := c.Verify(v.TrustedKeys)
+		if err != nil {
+			message := GIT_MESSAGE_REJECTED + v.Name + " to " + target.String() + ", commit " + c.Hash.String() + ": " + err.Error()
+			RecordEvent(EVENT_LEVEL_ERROR, EVENT_SOURCE_SECURITY, message)
+			return 0, errors.New(ERROR_GIT_SIGNATURE + c.Hash.String())
+		}
+	}
+	return len(commits), nil
+}
+
+// fetch first and only move the branch once every new commit is verified,
+// a plain pull would fetch again and could bring unverified commits
+func gitVerifiedPull(r *git.Repository, w *git.Worktree, v GitConfig, auth transport.AuthMethod, progress io.Writer) (string, error) {
+	if v.Branch != "" {
+		err := gitCheckoutBranch(r, w, v.Branch, auth, progress)
+		if err != nil {
+			return "", err
+		}
+	}
+	head, err := r.Head()
+	if err != nil {
+		return "", err
+	}
+	if !head.Name().IsBranch() {
+		return "", errors.New(ERROR_GIT_DETACHED)
+	}
+
+	remote, err := r.Remote(GIT_REMOTE_NAME)
+	if err != nil {
+		return "", err
+	}
+	err = gitFetch(r, remote.Config(), &git.FetchOptions{
+		RemoteName: GIT_REMOTE_NAME,
+		Auth:       auth,
+		Progress:   progress,
+	})
+	if err != nil && err != git.NoErrAlreadyUpToDate {
+		return "", err
+	}
+	remoteRef, err := r.Reference(plumbing.NewRemoteReferenceName(GIT_REMOTE_NAME, head.Name().Short()), true)
+	if err != nil {
+		return "", err
+	}
+	if remoteRef.Hash() == head.Hash() {
+		return "Already up-to-date", nil
+	}
+
+	forward, err := gitIsAncestor(r, head.Hash(), remoteRef.Hash())
+	if err != nil {
+		return "", err
+	}
+	if !forward {
+		return "", git.ErrNonFastForwardUpdate
+	}
+
+	count, err := GitVerifyCommits(r, v, head.Hash(), remoteRef.Hash())
+	if err != nil {
+		return "", err
+	}
+	err = w.Reset(&git.ResetOptions{
+		Commit: remoteRef.Hash(),
+		Mode:   git.HardReset,
+	})
+	if err != nil {
+		return "", err
+	}
+	return "Verified commits: " + strconv.Itoa(count) + "\nUpdated to: " + remoteRef.Hash().String(), nil
+}
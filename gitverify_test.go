@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+)
+
+func armoredPublicKey(t *testing.T, entity *openpgp.Entity) string {
+	var buffer bytes.Buffer
+	w, err := armor.Encode(&buffer, openpgp.PublicKeyType, nil)
+	require.NoError(t, err)
+	require.NoError(t, entity.Serialize(w))
+	require.NoError(t, w.Close())
+	return buffer.String()
+}
+
+func signedCommit(t *testing.T, r *git.Repository, dir string, name string, key *openpgp.Entity) plumbing.Hash {
+	require.NoError(t, ioutil.WriteFile(dir+"/"+name, []byte(name+"\n"), 0600))
+	w, err := r.Worktree()
+	require.NoError(t, err)
+	_, err = w.Add(name)
+	require.NoError(t, err)
+	hash, err := w.Commit("signed "+name, &git.CommitOptions{
+		Author:  &object.Signature{Name: "test", Email: "test@localhost", When: time.Now()},
+		SignKey: key,
+	})
+	require.NoError(t, err)
+	return hash
+}
+
+func TestGitVerifyPull(t *testing.T) {
+	fmt.Println("running: TestGitVerifyPull")
+	AgentConfiguration.DB = InitDB("", "", true)
+	t.Cleanup(func() {
+		AgentConfiguration.DB.Close()
+		AgentConfiguration.DB = nil
+	})
+
+	trusted, err := openpgp.NewEntity("trusted", "", "trusted@localhost", nil)
+	require.NoError(t, err)
+	untrusted, err := openpgp.NewEntity("untrusted", "", "untrusted@localhost", nil)
+	require.NoError(t, err)
+
+	home, remote := createLocalRemote(t)
+	seed, err := git.PlainOpen(home + "/seed")
+	require.NoError(t, err)
+	work, err := git.PlainClone(home+"/work", false, &git.CloneOptions{URL: remote})
+	require.NoError(t, err)
+	require.NoError(t, GitCreateRemote("~/work", home, remote))
+	head := func() plumbing.Hash {
+		ref, err := work.Head()
+		require.NoError(t, err)
+		return ref.Hash()
+	}
+
+	v := GitConfig{
+		Name:        "verify",
+		Rep:         remote,
+		Directory:   "~/work",
+		Verify:      GIT_VERIFY_ALL,
+		TrustedKeys: armoredPublicKey(t, trusted),
+	}
+
+	signedCommit(t, seed, home+"/seed", "A", trusted)
+	tip := signedCommit(t, seed, home+"/seed", "B", trusted)
+	require.NoError(t, seed.Push(&git.PushOptions{RemoteName: "origin"}))
+	str, err := GitPull(v, home, nil)
+	assert.NoError(t, err)
+	assert.Contains(t, str, "Verified commits: 2")
+	assert.Equal(t, tip, head())
+	assert.FileExists(t, home+"/work/B")
+
+	// an unsigned commit below a signed tip
+	unsigned := commitFile(t, seed, home+"/seed", "C", "c\n")
+	tip = signedCommit(t, seed, home+"/seed", "D", trusted)
+	require.NoError(t, seed.Push(&git.PushOptions{RemoteName: "origin"}))
+	before := head()
+	_, err = GitPull(v, home, nil)
+	assert.EqualError(t, err, ERROR_GIT_SIGNATURE+unsigned.String())
+	assert.Equal(t, before, head())
+	assert.NoFileExists(t, home+"/work/C")
+
+	events, err := GetEvents(AgentConfiguration.DB, 0)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, EVENT_SOURCE_SECURITY, events[0].Source)
+	assert.Equal(t, EVENT_LEVEL_ERROR, events[0].Level)
+
+	v.Verify = GIT_VERIFY_TIP
+	str, err = GitPull(v, home, nil)
+	assert.NoError(t, err)
+	assert.Contains(t, str, "Verified commits: 1")
+	assert.Equal(t, tip, head())
+
+	// signed, but not by a trusted key
+	signedCommit(t, seed, home+"/seed", "E", untrusted)
+	require.NoError(t, seed.Push(&git.PushOptions{RemoteName: "origin"}))
+	_, err = GitPull(v, home, nil)
+	assert.Error(t, err)
+	assert.Equal(t, tip, head())
+
+	// a clone of an untrusted HEAD is removed again, a trusted one is kept
+	clone := GitConfig{Name: "clone", Rep: remote, Directory: "~/clone", Verify: GIT_VERIFY_TIP, TrustedKeys: v.TrustedKeys}
+	err = GitClone(clone, home, nil)
+	assert.Error(t, err)
+	assert.NoDirExists(t, home+"/clone")
+	clone.Verify = "some"
+	err = GitClone(clone, home, nil)
+	assert.EqualError(t, err, ERROR_GIT_VERIFY+"some")
+	assert.NoDirExists(t, home+"/clone")
+	clone.Verify = GIT_VERIFY_ALL
+	clone.Commit = tip.String()
+	require.NoError(t, GitClone(clone, home, nil))
+	assert.FileExists(t, home+"/clone/D")
+
+	v.Verify = "some"
+	_, err = GitPull(v, home, nil)
+	assert.EqualError(t, err, ERROR_GIT_VERIFY+"some")
+	v.Verify = GIT_VERIFY_ALL
+	v.TrustedKeys = ""
+	_, err = GitPull(v, home, nil)
+	assert.EqualError(t, err, ERROR_GIT_TRUSTED_KEYS+"verify")
+}
@@ -5,6 +5,7 @@ import (
 	"io"
 	"os"
 	"os/exec"
+	"runtime"
 	"strings"
 	"time"
 )
@@ -12,11 +13,25 @@ import (
 func MountFolders(home string, config []GocryptConfig) []*exec.Cmd {
 	var output []*exec.Cmd
 	for _, folderconfig := range config {
+		if folderconfig.Reverse {
+			Sugar.Info("Reverse volume is only mounted for backups: ", folderconfig.Name)
+			continue
+		}
 
-		cmd := mount(home, folderconfig)
-		err := IsEmpty(home, folderconfig.MountPoint)
+		cmd, err := mount(home, folderconfig)
 		if err != nil {
 			Sugar.Error("ERROR", err)
+			continue
+		}
+		if folderconfig.NotEmpty {
+			output = append(output, cmd)
+			continue
+		}
+
+		err = IsEmpty(home, folderconfig.MountPoint)
+		if err != nil {
+			Sugar.Error("ERROR", err)
+			CloseExtraFiles(cmd)
 		} else {
 			output = append(output, cmd)
 		}
@@ -24,34 +39,182 @@ func MountFolders(home string, config []GocryptConfig) []*exec.Cmd {
 	return output
 }
 
-func mount(home string, folderconfig GocryptConfig) *exec.Cmd {
-	return MountGocryptfs(folderconfig.Path, folderconfig.MountPoint, home, folderconfig.MountDuration, folderconfig.Password, folderconfig.AllowOther)
+// InitFolders only creates volumes marked with init which have no
+// configuration yet, an existing volume is never initialized again
+func InitFolders(home string, config []GocryptConfig) []*exec.Cmd {
+	var output []*exec.Cmd
+	for _, folderconfig := range config {
+		if !folderconfig.Init {
+			continue
+		}
+
+		mounter, err := GetMounter(folderconfig.Type)
+		if err != nil {
+			Sugar.Error(ERROR_INIT, folderconfig.Name, " ", err)
+			continue
+		}
+		status, err := mounter.Status(home, folderconfig)
+		if err != nil {
+			Sugar.Error(ERROR_INIT, folderconfig.Name, " ", err)
+			continue
+		}
+		if status.Initialized {
+			continue
+		}
+
+		err = os.MkdirAll(strings.ReplaceAll(folderconfig.Path, HOME, home), 0700)
+		if err != nil {
+			Sugar.Error(ERROR_INIT, folderconfig.Name, " ", err)
+			continue
+		}
+		cmd, err := mounter.Init(home, folderconfig)
+		if err != nil {
+			Sugar.Error(ERROR_INIT, folderconfig.Name, " ", err)
+			continue
+		}
+		output = append(output, cmd)
+	}
+	return output
 }
 
-func MountGocryptfs(cryptoDir string, folder string, home string, duration time.Duration, pwd string, allowOther bool) *exec.Cmd {
-	var cmd *exec.Cmd
-	var command string
+func mount(home string, folderconfig GocryptConfig) (*exec.Cmd, error) {
+	mounter, err := GetMounter(folderconfig.Type)
+	if err != nil {
+		return nil, err
+	}
+	return mounter.Mount(home, folderconfig)
+}
 
-	command = "gocryptfs"
+func MountGocryptfs(cryptoDir string, folder string, home string, duration time.Duration, pwd string, allowOther bool, notEmpty bool) (*exec.Cmd, error) {
+	var options []string
 	if allowOther {
-		command = command + " -allow_other"
+		options = append(options, "-allow_other")
+	}
+	if notEmpty {
+		options = append(options, "-nonempty")
 	}
 	if duration.String() != "0s" {
-		command = command + " -i " + duration.String()
+		options = append(options, "-i", duration.String())
 	}
 
 	cryptoDir = strings.ReplaceAll(cryptoDir, HOME, home)
 	folder = strings.ReplaceAll(folder, HOME, home)
 
-	command = command + " " + cryptoDir + " " + folder
+	Sugar.Debug("Mounting: ", folder, " Duration", duration.String(), " AllowOther", allowOther, " NotEmpty", notEmpty)
+	return GocryptfsCommand(pwd, options, cryptoDir, folder)
+}
+
+func MountReverseGocryptfs(plainDir string, folder string, home string, pwd string) (*exec.Cmd, error) {
+	plainDir = strings.ReplaceAll(plainDir, HOME, home)
+	folder = strings.ReplaceAll(folder, HOME, home)
+
+	Sugar.Debug("Mounting reverse: ", plainDir, " to ", folder)
+	return GocryptfsCommand(pwd, []string{"-reverse"}, plainDir, folder)
+}
+
+func InitGocryptfs(cryptoDir string, home string, pwd string, reverse bool) (*exec.Cmd, error) {
+	cryptoDir = strings.ReplaceAll(cryptoDir, HOME, home)
+
+	options := []string{"-init", "-q"}
+	if reverse {
+		options = append(options, "-reverse")
+	}
+	Sugar.Debug("Initializing: ", cryptoDir, " Reverse", reverse)
+	return GocryptfsCommand(pwd, options, cryptoDir)
+}
 
-	Sugar.Debug("Mounting: ", folder, " Duration", duration.String(), " AllowOther", allowOther)
-	cmd = exec.Command("bash", "-c", command)
+// The password is handed to gocryptfs through -passfile on an anonymous pipe,
+// so it never touches the disk and does not show up in the process listing.
+// Without the pipe gocryptfs would ask on a terminal which is not there.
+func GocryptfsCommand(pwd string, options []string, dirs ...string) (*exec.Cmd, error) {
+	reader, path, err := PasswordPipe(pwd)
+	if err != nil {
+		return nil, errors.New(ERROR_PASSWORD_PIPE + err.Error())
+	}
+	options = append(options, "-passfile", path)
+
+	cmd := exec.Command("gocryptfs", append(options, dirs...)...)
+	cmd.Env = os.Environ()
+	cmd.ExtraFiles = []*os.File{reader}
+	return cmd, nil
+}
+
+// CloseExtraFiles releases the password pipe of a command that is not run or
+// already started, the child keeps its own copy
+func CloseExtraFiles(cmd *exec.Cmd) {
+	if cmd == nil {
+		return
+	}
+	for _, f := range cmd.ExtraFiles {
+		f.Close()
+	}
+}
+
+// Returns the read end of a pipe holding the password and the path under
+// which the child process sees it as its first extra file.
+func PasswordPipe(pwd string) (*os.File, string, error) {
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		return nil, "", err
+	}
+	defer writer.Close()
+
+	// the password is far smaller than the pipe buffer so this does not block
+	_, err = writer.WriteString(pwd + "\n")
+	if err != nil {
+		reader.Close()
+		return nil, "", err
+	}
+	return reader, "/dev/fd/3", nil
+}
+
+func UnmountGocryptfs(folder string, home string) *exec.Cmd {
+	folder = strings.ReplaceAll(folder, HOME, home)
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "darwin" {
+		cmd = exec.Command("umount", folder)
+	} else {
+		cmd = exec.Command("fusermount", "-u", folder)
+	}
 	cmd.Env = os.Environ()
-	cmd.Stdin = strings.NewReader(pwd)
 	return cmd
 }
 
+func IsMounted(home string, name string) (bool, bool, error) {
+	path := strings.ReplaceAll(name, HOME, home)
+	out, err := exec.Command("mount").Output()
+	if err != nil {
+		return false, false, err
+	}
+	mounted, readOnly := parseMountTable(string(out), path)
+	return mounted, readOnly, nil
+}
+
+// parses the output of mount which looks like
+// "dev on /path type fuse.gocryptfs (rw,nosuid)" on linux and
+// "dev on /path (macfuse, nodev, read-only)" on darwin
+func parseMountTable(table string, path string) (bool, bool) {
+	path = strings.TrimSuffix(path, "/")
+	for _, line := range strings.Split(table, "\n") {
+		index := strings.Index(line, " on "+path+" ")
+		if index < 0 {
+			continue
+		}
+
+		options := line[strings.LastIndex(line, "(")+1:]
+		options = strings.TrimSuffix(options, ")")
+		for _, option := range strings.Split(options, ",") {
+			option = strings.TrimSpace(option)
+			if option == "ro" || option == "read-only" {
+				return true, true
+			}
+		}
+		return true, false
+	}
+	return false, false
+}
+
 func IsEmpty(home string, name string) error {
 	path := strings.ReplaceAll(name, HOME, home)
 	stat, err := os.Stat(path)
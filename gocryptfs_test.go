@@ -6,6 +6,7 @@ import (
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"runtime"
 	"strconv"
 	"strings"
 	"testing"
@@ -34,7 +35,8 @@ func TestGocryptfsMountGocryptfs(t *testing.T) {
 	_ = os.Mkdir(test_mountpath, 0700)
 	require.DirExists(t, test_mountpath, "Folder creation failed")
 
-	cmd := MountGocryptfs("~/test/tmp", test_mountpath, home, idletime, "hallo", false)
+	cmd, err := MountGocryptfs("~/test/tmp", test_mountpath, home, idletime, "hallo", false, false)
+	require.NoError(t, err)
 
 	assert.Contains(t, cmd.String(), "gocryptfs -i 3s", "/test/tmp", "/test/tmp-mount",
 		// clear location of executable
@@ -115,3 +117,138 @@ func TestGocryptfsIsEmpty(t *testing.T) {
 	err = IsEmpty(home, GOCRYPT_TEST_MOUNTPATH)
 	assert.NoError(t, err)
 }
+
+func TestGocryptfsParseMountTable(t *testing.T) {
+	fmt.Println("running: TestGocryptfsParseMountTable")
+	linux := "proc on /proc type proc (rw,nosuid,nodev,noexec,relatime)\n" +
+		"/home/user/crypt on /home/user/mount type fuse.gocryptfs (rw,nosuid,nodev,relatime)\n" +
+		"/home/user/ro on /home/user/ro-mount type fuse.gocryptfs (ro,nosuid,nodev,relatime)\n"
+	darwin := "/dev/disk1s1 on / (apfs, local, journaled)\n" +
+		"gocryptfs@osxfuse0 on /Users/user/mount (osxfuse, nodev, nosuid, read-only, mounted by user)\n"
+
+	mounted, readOnly := parseMountTable(linux, "/home/user/mount")
+	assert.True(t, mounted)
+	assert.False(t, readOnly)
+
+	mounted, readOnly = parseMountTable(linux, "/home/user/ro-mount/")
+	assert.True(t, mounted)
+	assert.True(t, readOnly)
+
+	mounted, _ = parseMountTable(linux, "/home/user")
+	assert.False(t, mounted)
+
+	mounted, readOnly = parseMountTable(darwin, "/Users/user/mount")
+	assert.True(t, mounted)
+	assert.True(t, readOnly)
+}
+
+func TestGocryptfsMountOptions(t *testing.T) {
+	fmt.Println("running: TestGocryptfsMountOptions")
+	home, err := os.Getwd()
+	require.NoError(t, err)
+
+	cmd, err := MountGocryptfs(GOCRYPT_TEST_FOLDER, GOCRYPT_TEST_MOUNTPATH, home, 0, "hallo", false, false)
+	require.NoError(t, err)
+	assert.NotContains(t, cmd.String(), "-allow_other")
+	assert.NotContains(t, cmd.String(), "-nonempty")
+	assert.NotContains(t, cmd.String(), "-i ")
+
+	cmd, err = MountGocryptfs(GOCRYPT_TEST_FOLDER, GOCRYPT_TEST_MOUNTPATH, home, 5*time.Second, "hallo", true, true)
+	require.NoError(t, err)
+	assert.Contains(t, cmd.String(), "gocryptfs -allow_other -nonempty -i 5s -passfile /dev/fd/3 "+home+"/test/tmp "+home+"/test/tmp-mount")
+}
+
+func TestGocryptfsMountFoldersNotEmpty(t *testing.T) {
+	fmt.Println("running: TestGocryptfsMountFoldersNotEmpty")
+	home, err := os.Getwd()
+	require.NoError(t, err)
+
+	// IsEmpty fails for a missing mount point
+	config := GocryptConfig{
+		MountPoint: "~/test/not-existing",
+		Path:       GOCRYPT_TEST_FOLDER,
+		Password:   "hallo",
+	}
+	cmds := MountFolders(home, []GocryptConfig{config})
+	assert.Empty(t, cmds)
+
+	config.NotEmpty = true
+	cmds = MountFolders(home, []GocryptConfig{config})
+	require.Len(t, cmds, 1)
+	assert.Contains(t, cmds[0].String(), "-nonempty")
+}
+
+func TestGocryptfsMountReverseGocryptfs(t *testing.T) {
+	fmt.Println("running: TestGocryptfsMountReverseGocryptfs")
+	home, err := os.Getwd()
+	require.NoError(t, err)
+
+	cmd, err := MountReverseGocryptfs(GOCRYPT_TEST_REVERSE_FOLDER, GOCRYPT_TEST_REVERSE_MOUNTPATH, home, "hallo")
+	require.NoError(t, err)
+	assert.Contains(t, cmd.String(), "gocryptfs -reverse -passfile /dev/fd/3 "+home+"/test/plain "+home+"/test/plain-mount")
+
+	cmd = UnmountGocryptfs(GOCRYPT_TEST_REVERSE_MOUNTPATH, home)
+	assert.Contains(t, cmd.String(), home+"/test/plain-mount")
+
+	config := GocryptConfig{
+		MountPoint: GOCRYPT_TEST_REVERSE_MOUNTPATH,
+		Path:       GOCRYPT_TEST_REVERSE_FOLDER,
+		Reverse:    true,
+	}
+	cmds := MountFolders(home, []GocryptConfig{config})
+	assert.Empty(t, cmds)
+}
+
+func TestGocryptfsPasswordPipe(t *testing.T) {
+	fmt.Println("running: TestGocryptfsPasswordPipe")
+	if runtime.GOOS != "linux" {
+		t.Skip("needs /proc")
+	}
+	home, err := os.Getwd()
+	require.NoError(t, err)
+
+	cmd, err := MountGocryptfs(GOCRYPT_TEST_FOLDER, GOCRYPT_TEST_MOUNTPATH, home, 0, VAULT_TEST_PASSWORD, false, false)
+	require.NoError(t, err)
+	assert.Nil(t, cmd.Stdin)
+	assert.NotContains(t, cmd.String(), VAULT_TEST_PASSWORD)
+	require.Len(t, cmd.ExtraFiles, 1)
+
+	// run a stand-in for gocryptfs which reads the passfile like gocryptfs does
+	script := home + "/test/fake-gocryptfs"
+	err = ioutil.WriteFile(script, []byte("#!/bin/sh\nsleep 1\nwhile [ \"$1\" != \"-passfile\" ]; do shift; done\ncat \"$2\"\n"), 0700)
+	require.NoError(t, err)
+	t.Cleanup(func() { os.Remove(script) })
+	fake := exec.Command(script, cmd.Args[1:]...)
+	fake.ExtraFiles = cmd.ExtraFiles
+	cmd = fake
+
+	job := CreateJobFromCommand(cmd, "passfile")
+	require.NoError(t, cmd.Start())
+
+	cmdline, err := ioutil.ReadFile("/proc/" + strconv.Itoa(cmd.Process.Pid) + "/cmdline")
+	require.NoError(t, err)
+	assert.Contains(t, string(cmdline), "-passfile")
+	assert.NotContains(t, string(cmdline), VAULT_TEST_PASSWORD)
+
+	environ, err := ioutil.ReadFile("/proc/" + strconv.Itoa(cmd.Process.Pid) + "/environ")
+	require.NoError(t, err)
+	assert.NotContains(t, string(environ), VAULT_TEST_PASSWORD)
+
+	require.NoError(t, cmd.Wait())
+	assert.Equal(t, VAULT_TEST_PASSWORD+"\n", job.Stdout.String())
+}
+
+func TestGocryptfsCloseExtraFiles(t *testing.T) {
+	fmt.Println("running: TestGocryptfsCloseExtraFiles")
+	home, err := os.Getwd()
+	require.NoError(t, err)
+
+	// a command which is never started must not keep the pipe open
+	cmd, err := MountGocryptfs(GOCRYPT_TEST_FOLDER, GOCRYPT_TEST_MOUNTPATH, home, 0, VAULT_TEST_PASSWORD, false, false)
+	require.NoError(t, err)
+	job := CreateJobFromCommand(cmd, "closed pipe")
+	require.NoError(t, job.DontRun(false))
+	assert.ErrorIs(t, cmd.ExtraFiles[0].Close(), os.ErrClosed)
+
+	CloseExtraFiles(nil)
+}
@@ -3,8 +3,13 @@ package main
 import (
 	"bytes"
 	"errors"
+	"io"
+	"os"
 	"os/exec"
 	"strconv"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
 )
 
 func handleError(job Job, err error, errMsg string, buffer bytes.Buffer) bool {
@@ -21,25 +26,40 @@ func handleError(job Job, err error, errMsg string, buffer bytes.Buffer) bool {
 }
 
 func HandleGit(mode string, v GitConfig, run bool, printOutput bool, home string) (bool, error) {
-	var job Job
+	var update func(progress io.Writer) (string, bool, error)
 	switch mode {
-	case "clone":
-		job = CreateJobFromFunction(func() error {
-			return GitClone(v.Rep, v.Directory, home, v.PersonalToken)
-		}, mode+" "+v.Name)
-	case "pull":
-		job = CreateJobFromFunction(func() error {
-			err := GitCreateRemote(v.Directory, home, v.Rep)
+	case GIT_MODE_CLONE:
+		update = func(progress io.Writer) (string, bool, error) {
+			err := GitClone(v, home, progress)
+			return "", err == nil, err
+		}
+	case GIT_MODE_PULL:
+		update = func(progress io.Writer) (string, bool, error) {
+			reconciled, err := GitReconcileRemote(v, home, progress)
 			if err != nil {
-				return err
-			} else {
-				return GitPull(v.Directory, home, v.PersonalToken)
+				return reconciled, false, err
 			}
-		}, mode+" "+v.Name)
+			str, pulled, err := gitPull(v, home, progress)
+			return reconciled + str, pulled, err
+		}
+	case GIT_MODE_SYNC:
+		update = func(progress io.Writer) (string, bool, error) {
+			str, err := GitSync(v, home, progress)
+			return str, err == nil, err
+		}
+	case GIT_MODE_MIRROR:
+		update = func(progress io.Writer) (string, bool, error) {
+			str, err := GitMirror(v, home, progress)
+			return str, err == nil, err
+		}
 	default:
-		return false, errors.New("Not supported Mode: " + mode)
+		return false, errors.New(ERROR_GIT_MODE + mode)
 	}
 
+	job := CreateJobFromOutputFunction(func(stdout io.Writer, stderr io.Writer) error {
+		return runGitUpdate(v, home, update, stdout, stderr)
+	}, mode+" "+v.Name)
+
 	var err error
 
 	if run {
@@ -50,6 +70,68 @@ func HandleGit(mode string, v GitConfig, run bool, printOutput bool, home string
 
 	return err == nil, err
 }
+
+// progress goes to stderr like it does for the git binary, the result and
+// the pulled commits to stdout
+func runGitUpdate(v GitConfig, home string, update func(progress io.Writer) (string, bool, error), stdout io.Writer, stderr io.Writer) error {
+	old := GitHead(v, home)
+	str, updated, err := update(stderr)
+	if str != "" {
+		io.WriteString(stdout, str+"\n")
+	}
+	if err != nil {
+		io.WriteString(stderr, err.Error()+"\n")
+		return err
+	}
+	// a skipped pull is no update, the status keeps showing the last one
+	if !updated {
+		return nil
+	}
+	recordGitUpdate(v.Name)
+
+	head := GitHead(v, home)
+	// a fresh clone has no earlier state, its history was not pulled
+	if head != old && !old.IsZero() {
+		commits, err := GitCommitsBetween(v, home, old, head)
+		if err != nil {
+			io.WriteString(stderr, err.Error()+"\n")
+		} else {
+			recordGitCommits(v.Name, commits)
+			io.WriteString(stdout, FormatGitCommits(commits))
+		}
+	}
+
+	// the hooks run from the last HEAD they succeeded for, so a failed hook
+	// is retried with the next update
+	hooked, err := GetGitHooked(AgentConfiguration.DB, v.Name)
+	if err != nil {
+		hooked = old
+	}
+	if head == hooked {
+		return nil
+	}
+	err = runGitHooks(v, home, hooked, head, stdout, stderr)
+	if err != nil {
+		return err
+	}
+	recordGitHooked(v.Name, head)
+	return nil
+}
+
+// hooks only run after HEAD changed, a failing hook stops the following ones
+func runGitHooks(v GitConfig, home string, old plumbing.Hash, head plumbing.Hash, stdout io.Writer, stderr io.Writer) error {
+	for k, hook := range v.Hooks {
+		job := CreateJobFromCommand(GitHookCommand(v, home, hook, old, head), "hook "+v.Name+" "+strconv.Itoa(k))
+		err := job.RunJob(false)
+		io.WriteString(stdout, "Hook: "+hook+"\n"+job.Stdout.String())
+		if err != nil {
+			io.WriteString(stderr, ERROR_GIT_HOOK+hook+" "+err.Error()+"\n"+job.Stderr.String())
+			return errors.New(ERROR_GIT_HOOK + hook + " " + err.Error())
+		}
+	}
+	return nil
+}
+
 func HandleBackup(cmd *exec.Cmd, name string, printOutput bool, test bool, run bool) error {
 	job := CreateJobFromCommand(cmd, name)
 	var err error
@@ -67,6 +149,53 @@ func HandleBackup(cmd *exec.Cmd, name string, printOutput bool, test bool, run b
 
 }
 
+func HandleReverseBackup(cmd *exec.Cmd, reverse []GocryptConfig, home string, name string, printOutput bool, test bool, run bool) error {
+	if len(reverse) == 0 || test {
+		return HandleBackup(cmd, name, printOutput, test, run)
+	}
+
+	job := CreateJobFromCommand(cmd, name)
+	backup := job.Function
+	job.Function = func() error {
+		// the reverse mounts are torn down even if the backup fails
+		defer unmountReverse(reverse, home)
+		for _, v := range reverse {
+			cmd, err := GocryptfsMounter{}.Mount(home, v)
+			if err != nil {
+				return errors.New(ERROR_REVERSE_MOUNT + v.Name + " " + err.Error())
+			}
+			mountJob := CreateJobFromCommand(cmd, "reverse "+v.Name)
+			err = mountJob.RunJob(printOutput)
+			if err != nil {
+				return errors.New(ERROR_REVERSE_MOUNT + v.Name + " " + err.Error() + " " + mountJob.Stderr.String())
+			}
+		}
+		return backup()
+	}
+
+	if run {
+		return job.RunJob(printOutput)
+	}
+	return job.RunJobBackground(printOutput)
+}
+
+func unmountReverse(reverse []GocryptConfig, home string) {
+	for _, v := range reverse {
+		status, err := GocryptfsMounter{}.Status(home, v)
+		if err != nil {
+			Sugar.Error(ERROR_REVERSE_UNMOUNT, err)
+		} else if !status.Mounted {
+			continue
+		}
+
+		job := CreateJobFromCommand(GocryptfsMounter{}.Unmount(home, v), "unmount "+v.Name)
+		err = job.RunJob(false)
+		if err != nil {
+			Sugar.Error(ERROR_REVERSE_UNMOUNT, v.Name, " ", err, " ", job.Stderr.String())
+		}
+	}
+}
+
 func HandleMount(job Job, printOutput bool, test bool, run bool, buffer bytes.Buffer) bool {
 	var err error
 	if test {
@@ -96,6 +225,20 @@ func HandleMountFolders(cmds []*exec.Cmd, printOutput bool, test bool, run bool)
 
 }
 
+// a volume has to exist before it is mounted, so init always runs in the
+// foreground
+func HandleInitFolders(cmds []*exec.Cmd, printOutput bool, test bool) (string, bool) {
+	ok := true
+	var buffer bytes.Buffer
+	for k, v := range cmds {
+		job := CreateJobFromCommand(v, "init"+strconv.Itoa(k))
+		if !HandleMount(job, printOutput, test, true, buffer) {
+			ok = false
+		}
+	}
+	return buffer.String(), ok
+}
+
 func DoSeal(token string) error{
 	err := Seal(AgentConfiguration.VaultConfig, token)
 	if err != nil {
@@ -131,11 +274,14 @@ func DoMount(token string, debug bool, printOutput bool, test bool, run bool) (s
 	if err != nil {
 		return "", err
 	}
+	config.MountDuration = AgentConfiguration.MountDuration
+	config.MountAllow = AgentConfiguration.MountAllow
 
 	err = config.GetGocryptConfig()
 	if err != nil {
 		return "", err
 	}
+	str, ok := HandleInitFolders(InitFolders(config.Agent.HomeFolder, config.Gocrypt), printOutput, test)
 	out := MountFolders(config.Agent.HomeFolder, config.Gocrypt)
 
 	if debug {
@@ -144,7 +290,16 @@ func DoMount(token string, debug bool, printOutput bool, test bool, run bool) (s
 			Sugar.Info("Command", k, ": ", v.String())
 		}
 	}
-	str, ok := HandleMountFolders(out, printOutput, test, run)
+	mountStr, mountOk := HandleMountFolders(out, printOutput, test, run)
+	str += mountStr
+	ok = ok && mountOk
+	if !test {
+		for _, v := range config.Gocrypt {
+			if !v.Reverse {
+				RegisterMount(config.Agent.HomeFolder, v)
+			}
+		}
+	}
 	if ok {
 		return str, nil
 	} else {
@@ -152,6 +307,85 @@ func DoMount(token string, debug bool, printOutput bool, test bool, run bool) (s
 	}
 }
 
+func DoFsck(token string) (string, error) {
+	config, err := CreateConfigFromVault(token, AgentConfiguration.Hostname, AgentConfiguration.VaultConfig)
+	if err != nil {
+		return "", err
+	}
+
+	err = config.GetGocryptConfig()
+	if err != nil {
+		return "", err
+	}
+
+	var buffer bytes.Buffer
+	for _, v := range config.Gocrypt {
+		result := RunFsck(config.Agent.HomeFolder, v)
+		buffer.WriteString("\nFsck: " + v.Name + " " + result.Status)
+
+		err = CheckFsckResult(AgentConfiguration.DB, v.Name, result)
+		if err != nil {
+			Sugar.Error(ERROR_FSCK, err)
+		}
+	}
+	return buffer.String(), nil
+}
+
+// a failing template doesn't stop the others, the command only runs after
+// its template changed
+func DoTemplate(token string) (string, error) {
+	config, err := CreateConfigFromVault(token, AgentConfiguration.Hostname, AgentConfiguration.VaultConfig)
+	if err != nil {
+		return "", err
+	}
+
+	err = config.GetTemplateConfig()
+	if err != nil {
+		return "", err
+	}
+
+	var buffer bytes.Buffer
+	ok := true
+	for _, v := range config.Template {
+		changed, err := RenderTemplate(config.VaultConfig, token, config.Agent.HomeFolder, v)
+		if err != nil {
+			ok = false
+			buffer.WriteString("\nTemplate: " + v.Name + " " + err.Error())
+			continue
+		}
+		buffer.WriteString("\nTemplate: " + v.Name + " changed: " + strconv.FormatBool(changed))
+		if v.Command == "" {
+			continue
+		}
+		// the marker keeps a failed command pending until it succeeded for
+		// the written file
+		if changed {
+			_, err = Put(AgentConfiguration.DB, STORE_TEMPLATE_PENDING+v.Name, v.Destination)
+			if err != nil {
+				Sugar.Debug(ERROR_TEMPLATE_PENDING, err)
+			}
+		} else if _, err := Get(AgentConfiguration.DB, STORE_TEMPLATE_PENDING+v.Name); err != nil {
+			continue
+		}
+
+		job := CreateJobFromCommand(TemplateCommand(config.Agent.HomeFolder, v), "template "+v.Name)
+		err = job.RunJob(false)
+		if err != nil {
+			ok = false
+			buffer.WriteString("\nTemplate: " + v.Name + " " + ERROR_TEMPLATE_COMMAND + err.Error() + " " + job.Stderr.String())
+			continue
+		}
+		err = Remove(AgentConfiguration.DB, STORE_TEMPLATE_PENDING+v.Name)
+		if err != nil {
+			Sugar.Debug(ERROR_TEMPLATE_PENDING, err)
+		}
+	}
+	if !ok {
+		return buffer.String(), errors.New(ERROR_TEMPLATE)
+	}
+	return buffer.String(), nil
+}
+
 func DoBackupVerbose(token string, mode string) error {
 	return DoBackup(token, mode, true, false,false,true)
 }
@@ -172,6 +406,7 @@ func DoBackup(token string, mode string, printOutput bool, debug bool, test bool
 	}
 
 	var cmd *exec.Cmd
+	var reverse []GocryptConfig
 	switch mode {
 	case "init":
 		cmd = InitRepo(config.Restic.Environment, config.Agent.HomeFolder)
@@ -180,8 +415,16 @@ func DoBackup(token string, mode string, printOutput bool, debug bool, test bool
 	case "check":
 		cmd = CheckRepo(config.Restic.Environment, config.Agent.HomeFolder)
 	case "backup":
+		reverse, err = config.GetReverseConfig()
+		if err != nil {
+			return err
+		}
+		path := config.Restic.Path
+		for _, v := range reverse {
+			path = path + " " + v.MountPoint
+		}
 		cmd = Backup(
-			config.Restic.Path,
+			strings.TrimSpace(path),
 			config.Restic.Environment,
 			config.Agent.HomeFolder,
 			config.Restic.ExcludePath,
@@ -201,7 +444,7 @@ func DoBackup(token string, mode string, printOutput bool, debug bool, test bool
 		Sugar.Debug("Command: ", cmd.String())
 		Sugar.Info("Config", config.Restic)
 	}
-	return HandleBackup(cmd, mode, printOutput, test, run)
+	return HandleReverseBackup(cmd, reverse, config.Agent.HomeFolder, mode, printOutput, test, run)
 }
 
 func DoGitVerbose(token string, mode string)( string,bool,error ){
@@ -222,10 +465,52 @@ func DoGit(token string, mode string, run bool, printOutput bool) ( string,bool,
 	var buffer bytes.Buffer
 	ok := true
 	for _, v := range config.Git {
-		ok, err = HandleGit(mode, v, run, printOutput, config.Agent.HomeFolder)
-		if !ok && err != nil {
-			buffer.WriteString("\nJob: " + v.Name + " " + err.Error())
+		repoMode := mode
+		if mode == GIT_MODE_UPDATE {
+			repoMode = GitUpdateMode(v, config.Agent.HomeFolder)
+		}
+		repoOk, err := HandleGit(repoMode, v, run, printOutput, config.Agent.HomeFolder)
+		if !repoOk {
+			ok = false
+			if err != nil {
+				buffer.WriteString("\nJob: " + v.Name + " " + err.Error())
+			}
 		}
 	}
 	return buffer.String(), ok,nil
 }
+
+// GitUpdateMode is the mode configured for the repository, a missing checkout
+// is cloned first. A mirror creates its bare repository itself.
+func GitUpdateMode(v GitConfig, home string) string {
+	mode := v.Mode
+	if mode == "" {
+		mode = GIT_MODE_PULL
+	}
+	if mode == GIT_MODE_MIRROR {
+		return mode
+	}
+	_, err := os.Stat(strings.ReplaceAll(v.Directory, HOME, home))
+	if os.IsNotExist(err) {
+		return GIT_MODE_CLONE
+	}
+	return mode
+}
+
+func DoGitStatus(token string) ([]GitStatus, error) {
+	config, err := CreateConfigFromVault(token, AgentConfiguration.Hostname, AgentConfiguration.VaultConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	err = config.GetGitConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	status := []GitStatus{}
+	for _, v := range config.Git {
+		status = append(status, GetGitStatus(AgentConfiguration.DB, v, config.Agent.HomeFolder))
+	}
+	return status, nil
+}
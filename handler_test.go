@@ -4,9 +4,14 @@ import (
 	"fmt"
 	"testing"
 	"bytes"
+	"io/ioutil"
+	"os"
 	"os/exec"
+	"strings"
 
+	git "github.com/go-git/go-git/v5"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestHandleMount(t *testing.T) {
@@ -17,3 +22,269 @@ func TestHandleMount(t *testing.T) {
 	var buffer bytes.Buffer
 	assert.False(t,HandleMount(job, true, false, true, buffer))
 }
+
+func TestHandleReverseBackup(t *testing.T) {
+	fmt.Println("running: TestHandleReverseBackup")
+	home, err := os.Getwd()
+	require.NoError(t, err)
+	plain := strings.ReplaceAll(GOCRYPT_TEST_REVERSE_FOLDER, HOME, home)
+	mountpath := strings.ReplaceAll(GOCRYPT_TEST_REVERSE_MOUNTPATH, HOME, home)
+	t.Cleanup(func() {
+		os.RemoveAll(plain)
+		os.RemoveAll(mountpath)
+	})
+
+	require.NoError(t, os.MkdirAll(plain, 0700))
+	require.NoError(t, os.MkdirAll(mountpath, 0700))
+	require.NoError(t, ioutil.WriteFile(plain+GOCRYPT_TEST_FILE, []byte("testfile\n"), 0600))
+
+	cmd := exec.Command("gocryptfs", "-init", "-reverse", "-q", plain)
+	cmd.Stdin = strings.NewReader(VAULT_TEST_PASSWORD)
+	require.NoError(t, cmd.Run())
+
+	reverse := []GocryptConfig{{
+		Name:       "reversepath",
+		Path:       GOCRYPT_TEST_REVERSE_FOLDER,
+		MountPoint: GOCRYPT_TEST_REVERSE_MOUNTPATH,
+		Password:   VAULT_TEST_PASSWORD,
+		Reverse:    true,
+	}}
+
+	// the backup sees the encrypted view of the plain folder
+	backup := exec.Command("bash", "-c", "ls "+mountpath+" | grep -v gocryptfs | grep -q .")
+	err = HandleReverseBackup(backup, reverse, home, "reverse-test", false, false, true)
+	assert.NoError(t, err)
+
+	mounted, _, err := IsMounted(home, GOCRYPT_TEST_REVERSE_MOUNTPATH)
+	assert.NoError(t, err)
+	assert.False(t, mounted)
+
+	// the mount is torn down even if the backup fails
+	err = HandleReverseBackup(exec.Command("false"), reverse, home, "reverse-test", false, false, true)
+	assert.Error(t, err)
+
+	mounted, _, err = IsMounted(home, GOCRYPT_TEST_REVERSE_MOUNTPATH)
+	assert.NoError(t, err)
+	assert.False(t, mounted)
+}
+
+func TestHandleGitOutput(t *testing.T) {
+	fmt.Println("running: TestHandleGitOutput")
+	AgentConfiguration.DB = InitDB("", "", true)
+	t.Cleanup(func() {
+		AgentConfiguration.DB.Close()
+		AgentConfiguration.DB = nil
+	})
+
+	home, remote := createLocalRemote(t)
+	v := GitConfig{Name: "output", Rep: remote, Directory: "~/work"}
+	ok, err := HandleGit("clone", v, true, false, home)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	value, found := jobmap.Get("clone output")
+	require.True(t, found)
+	assert.NotContains(t, value.(*Job).Status().Stdout, "Pulled commits")
+	_, err = GetGitCommits(AgentConfiguration.DB, "output")
+	assert.Error(t, err)
+
+	seed, err := git.PlainOpen(home + "/seed")
+	require.NoError(t, err)
+	first := commitFile(t, seed, home+"/seed", "A", "a\n")
+	second := commitFile(t, seed, home+"/seed", "B", "b\n")
+	require.NoError(t, seed.Push(&git.PushOptions{RemoteName: "origin"}))
+
+	ok, err = HandleGit("pull", v, true, false, home)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	value, found = jobmap.Get("pull output")
+	require.True(t, found)
+	status := value.(*Job).Status()
+	assert.True(t, status.Finished)
+	assert.Contains(t, status.Stdout, "Updated to: "+second.String())
+	assert.Contains(t, status.Stdout, "Pulled commits: 2\n")
+	assert.Contains(t, status.Stdout, second.String()[:7]+" test <test@localhost> update B")
+
+	commits, err := GetGitCommits(AgentConfiguration.DB, "output")
+	require.NoError(t, err)
+	require.Len(t, commits, 2)
+	assert.Equal(t, second.String(), commits[0].Hash)
+	assert.Equal(t, first.String(), commits[1].Hash)
+	assert.Equal(t, "update A", commits[1].Message)
+	updated, err := GetLastGitUpdate(AgentConfiguration.DB, "output")
+	require.NoError(t, err)
+
+	// a pull skipped for a dirty worktree is not an update
+	commitFile(t, seed, home+"/seed", "C", "c\n")
+	require.NoError(t, seed.Push(&git.PushOptions{RemoteName: "origin"}))
+	require.NoError(t, ioutil.WriteFile(home+"/work/README", []byte("dirty\n"), 0600))
+	ok, err = HandleGit("pull", v, true, false, home)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	last, err := GetLastGitUpdate(AgentConfiguration.DB, "output")
+	require.NoError(t, err)
+	assert.Equal(t, updated, last)
+
+	// errors end up in the job
+	v.Directory = "~/missing"
+	ok, err = HandleGit("pull", v, true, false, home)
+	assert.Error(t, err)
+	assert.False(t, ok)
+	value, found = jobmap.Get("pull output")
+	require.True(t, found)
+	assert.Contains(t, value.(*Job).Stderr.String(), git.ErrRepositoryNotExists.Error())
+}
+
+func TestHandleGitUpdateMode(t *testing.T) {
+	fmt.Println("running: TestHandleGitUpdateMode")
+	home, err := os.Getwd()
+	require.NoError(t, err)
+
+	assert.Equal(t, GIT_MODE_PULL, GitUpdateMode(GitConfig{Directory: "~/test"}, home))
+	assert.Equal(t, GIT_MODE_SYNC, GitUpdateMode(GitConfig{Directory: "~/test", Mode: GIT_MODE_SYNC}, home))
+	assert.Equal(t, GIT_MODE_CLONE, GitUpdateMode(GitConfig{Directory: "~/test/not-existing", Mode: GIT_MODE_SYNC}, home))
+	assert.Equal(t, GIT_MODE_MIRROR, GitUpdateMode(GitConfig{Directory: "~/test/not-existing", Mode: GIT_MODE_MIRROR}, home))
+
+	_, err = HandleGit("unknown", GitConfig{}, true, false, home)
+	assert.EqualError(t, err, ERROR_GIT_MODE+"unknown")
+}
+
+func TestHandleGitHooks(t *testing.T) {
+	fmt.Println("running: TestHandleGitHooks")
+	AgentConfiguration.DB = InitDB("", "", true)
+	t.Cleanup(func() {
+		AgentConfiguration.DB.Close()
+		AgentConfiguration.DB = nil
+	})
+
+	home, remote := createLocalRemote(t)
+	v := GitConfig{
+		Name:      "hooks",
+		Rep:       remote,
+		Directory: "~/work",
+		Hooks: []string{
+			"echo $" + GIT_HOOK_OLD_COMMIT + " $" + GIT_HOOK_NEW_COMMIT + " > " + home + "/hook.txt",
+			"echo $" + GIT_HOOK_NAME + " && pwd",
+		},
+	}
+	ok, err := HandleGit("clone", v, true, false, home)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	cloned := GitHead(v, home)
+
+	seed, err := git.PlainOpen(home + "/seed")
+	require.NoError(t, err)
+	update := commitFile(t, seed, home+"/seed", "A", "a\n")
+	require.NoError(t, seed.Push(&git.PushOptions{RemoteName: "origin"}))
+
+	ok, err = HandleGit("pull", v, true, false, home)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	content, err := ioutil.ReadFile(home + "/hook.txt")
+	require.NoError(t, err)
+	assert.Equal(t, cloned.String()+" "+update.String()+"\n", string(content))
+	value, found := jobmap.Get("pull hooks")
+	require.True(t, found)
+	stdout := value.(*Job).Stdout.String()
+	assert.Contains(t, stdout, "Hook: "+v.Hooks[1]+"\nhooks\n"+home+"/work\n")
+
+	// nothing changed, so the hooks don't run again
+	require.NoError(t, os.Remove(home+"/hook.txt"))
+	ok, err = HandleGit("pull", v, true, false, home)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.NoFileExists(t, home+"/hook.txt")
+
+	v.Hooks = []string{"echo failing >&2 && exit 3", "touch " + home + "/hook.txt"}
+	commitFile(t, seed, home+"/seed", "B", "b\n")
+	require.NoError(t, seed.Push(&git.PushOptions{RemoteName: "origin"}))
+	ok, err = HandleGit("pull", v, true, false, home)
+	assert.Error(t, err)
+	assert.False(t, ok)
+	assert.NoFileExists(t, home+"/hook.txt")
+	value, found = jobmap.Get("pull hooks")
+	require.True(t, found)
+	assert.Contains(t, value.(*Job).Stderr.String(), ERROR_GIT_HOOK+v.Hooks[0])
+	assert.Contains(t, value.(*Job).Stderr.String(), "failing\n")
+
+	// the failed hooks are retried without a new commit, from the last
+	// HEAD they succeeded for
+	v.Hooks = []string{"echo $" + GIT_HOOK_OLD_COMMIT + " > " + home + "/hook.txt"}
+	ok, err = HandleGit("pull", v, true, false, home)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	content, err = ioutil.ReadFile(home + "/hook.txt")
+	require.NoError(t, err)
+	assert.Equal(t, update.String()+"\n", string(content))
+	hooked, err := GetGitHooked(AgentConfiguration.DB, v.Name)
+	require.NoError(t, err)
+	assert.Equal(t, GitHead(v, home), hooked)
+}
+
+func TestHandleDoTemplate(t *testing.T) {
+	fmt.Println("running: TestHandleDoTemplate")
+	t.Cleanup(clear)
+	testconfig := readConfig(t)
+	AgentConfiguration.VaultConfig = testconfig.config
+	AgentConfiguration.Hostname = Hostname
+	withTemplate = true
+	pwd, err := os.Getwd()
+	require.NoError(t, err)
+	folder := strings.ReplaceAll(TEMPLATE_TEST_FOLDER, HOME, pwd)
+	t.Cleanup(func() {
+		withTemplate = false
+		os.RemoveAll(folder)
+	})
+
+	require.NoError(t, os.MkdirAll(folder, 0700))
+	require.NoError(t, ioutil.WriteFile(strings.ReplaceAll(TEMPLATE_TEST_SOURCE, HOME, pwd),
+		[]byte("password={{ secret \"gocrypt\" \"gocryptpath\" \"pw\" }}\n"), 0600))
+
+	str, err := DoTemplate(VAULT_TEST_TOKEN)
+	require.NoError(t, err)
+	assert.Contains(t, str, "Template: apptemplate changed: true")
+	content, err := ioutil.ReadFile(strings.ReplaceAll(TEMPLATE_TEST_DEST, HOME, pwd))
+	require.NoError(t, err)
+	assert.Equal(t, "password="+VAULT_TEST_PASSWORD+"\n", string(content))
+
+	// the command only runs after a change
+	str, err = DoTemplate(VAULT_TEST_TOKEN)
+	require.NoError(t, err)
+	assert.Contains(t, str, "Template: apptemplate changed: false")
+	content, err = ioutil.ReadFile(folder + "/rendered")
+	require.NoError(t, err)
+	assert.Equal(t, "apptemplate\n", string(content))
+
+	// a failed command stays pending until it succeeded
+	AgentConfiguration.DB = InitDB("", "", true)
+	t.Cleanup(func() {
+		AgentConfiguration.DB.Close()
+		AgentConfiguration.DB = nil
+	})
+	require.NoError(t, ioutil.WriteFile(strings.ReplaceAll(TEMPLATE_TEST_SOURCE, HOME, pwd), []byte("changed\n"), 0600))
+	require.NoError(t, os.Remove(folder+"/rendered"))
+	require.NoError(t, os.Mkdir(folder+"/rendered", 0700))
+	str, err = DoTemplate(VAULT_TEST_TOKEN)
+	assert.EqualError(t, err, ERROR_TEMPLATE)
+	assert.Contains(t, str, "Template: apptemplate changed: true")
+	assert.Contains(t, str, ERROR_TEMPLATE_COMMAND)
+
+	require.NoError(t, os.Remove(folder+"/rendered"))
+	str, err = DoTemplate(VAULT_TEST_TOKEN)
+	require.NoError(t, err)
+	assert.Contains(t, str, "Template: apptemplate changed: false")
+	content, err = ioutil.ReadFile(folder + "/rendered")
+	require.NoError(t, err)
+	assert.Equal(t, "apptemplate\n", string(content))
+	_, err = Get(AgentConfiguration.DB, STORE_TEMPLATE_PENDING+"apptemplate")
+	assert.Error(t, err)
+
+	_, err = DoTemplate(VAULT_TEST_TOKEN)
+	require.NoError(t, err)
+	content, err = ioutil.ReadFile(folder + "/rendered")
+	require.NoError(t, err)
+	assert.Equal(t, "apptemplate\n", string(content))
+
+	require.NoError(t, os.Remove(strings.ReplaceAll(TEMPLATE_TEST_SOURCE, HOME, pwd)))
+	_, err = DoTemplate(VAULT_TEST_TOKEN)
+	assert.EqualError(t, err, ERROR_TEMPLATE)
+}
@@ -2,7 +2,10 @@ package main
 
 import (
 	"bytes"
+	"io"
 	"os/exec"
+	"sync"
+	"sync/atomic"
 
 	cmap "github.com/orcaman/concurrent-map"
 )
@@ -12,11 +15,44 @@ var jobmap cmap.ConcurrentMap
 type Job struct {
 	Cmd         *exec.Cmd
 	Function    func() error
-	Stdout      *bytes.Buffer
-	Stderr      *bytes.Buffer
+	Stdout      *JobOutput
+	Stderr      *JobOutput
 	Name        string
 	printOutput bool
-	finished    bool
+	// shared by every copy of the job, the rest server reads it while
+	// the job runs in the background
+	finished *int32
+}
+
+// JobOutput is written by a running job while the rest server reads it
+type JobOutput struct {
+	lock   sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (o *JobOutput) Write(p []byte) (int, error) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	return o.buffer.Write(p)
+}
+
+func (o *JobOutput) String() string {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	return o.buffer.String()
+}
+
+func (o *JobOutput) Len() int {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	return o.buffer.Len()
+}
+
+type JobStatus struct {
+	Name     string `json:"name"`
+	Finished bool   `json:"finished"`
+	Stdout   string `json:"stdout"`
+	Stderr   string `json:"stderr"`
 }
 
 func LogJobStatus(job *Job) {
@@ -38,11 +74,20 @@ func LogJobStatus(job *Job) {
 }
 
 func (job *Job) IsFinished() bool {
-	return job.finished
+	return atomic.LoadInt32(job.finished) == 1
+}
+
+func (job *Job) Status() JobStatus {
+	return JobStatus{
+		Name:     job.Name,
+		Finished: job.IsFinished(),
+		Stdout:   job.Stdout.String(),
+		Stderr:   job.Stderr.String(),
+	}
 }
 
 func (job *Job) QueueStatus() {
-	job.finished = true
+	atomic.StoreInt32(job.finished, 1)
 	if job.Cmd != nil && job.Cmd.Process == nil {
 		Sugar.Info("Process not found")
 		return
@@ -56,8 +101,9 @@ func CreateJobFromFunction(f func() error, name string) Job {
 	}
 
 	job := Job{
-		Stdout:   new(bytes.Buffer),
-		Stderr:   new(bytes.Buffer),
+		Stdout:   new(JobOutput),
+		Stderr:   new(JobOutput),
+		finished: new(int32),
 		Function: f,
 		Name:     name,
 	}
@@ -65,6 +111,16 @@ func CreateJobFromFunction(f func() error, name string) Job {
 	return job
 }
 
+func CreateJobFromOutputFunction(f func(stdout io.Writer, stderr io.Writer) error, name string) Job {
+	job := CreateJobFromFunction(nil, name)
+	stdout, stderr := job.Stdout, job.Stderr
+	job.Function = func() error {
+		return f(stdout, stderr)
+	}
+	jobmap.Set(name, &job)
+	return job
+}
+
 func CreateJobFromCommand(cmd *exec.Cmd, name string) Job {
 	if jobmap == nil {
 		jobmap = cmap.New()
@@ -82,8 +138,9 @@ func CreateJobFromCommand(cmd *exec.Cmd, name string) Job {
 
 	job := Job{
 		Cmd:      cmd,
-		Stdout:   new(bytes.Buffer),
-		Stderr:   new(bytes.Buffer),
+		Stdout:   new(JobOutput),
+		Stderr:   new(JobOutput),
+		finished: new(int32),
 		Function: cmd.Run,
 		Name:     name,
 	}
@@ -102,6 +159,8 @@ func (job *Job) RunJob(printOutput bool) error {
 
 func (job *Job) doJob() error {
 	err := job.Function()
+	// the child got its own copies, e.g. of a password pipe
+	CloseExtraFiles(job.Cmd)
 	job.QueueStatus()
 	jobmap.Set(job.Name, job)
 	return err
@@ -122,6 +181,7 @@ func (job *Job) RunJobBackground(printOutput bool) error {
 func (job *Job) DontRun(printOutput bool) error {
 	job.printOutput = printOutput
 
+	CloseExtraFiles(job.Cmd)
 	if job.Cmd != nil {
 		Sugar.Info("Not Runing: ", job.Cmd)
 	} else {
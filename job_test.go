@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"os/exec"
 	"testing"
 	"time"
@@ -70,3 +71,52 @@ func TestJobRunJob(t *testing.T) {
 	assert.Equal(t, "TEST=hallo\n", j.Stdout.String())
 	assert.Equal(t, "", j.Stderr.String())
 }
+
+func TestJobRunOutputFunction(t *testing.T) {
+	fmt.Println("running: TestJobRunOutputFunction")
+	t.Cleanup(clear)
+
+	job := CreateJobFromOutputFunction(func(stdout io.Writer, stderr io.Writer) error {
+		io.WriteString(stdout, "hallo")
+		io.WriteString(stderr, "welt")
+		return nil
+	}, "test")
+	err := job.RunJob(false)
+	assert.NoError(t, err)
+
+	v, ok := jobmap.Get("test")
+	require.True(t, ok)
+	j := v.(*Job)
+	assert.True(t, j.IsFinished())
+	assert.Equal(t, "hallo", j.Stdout.String())
+	assert.Equal(t, "welt", j.Stderr.String())
+}
+
+func TestJobStatusWhileRunning(t *testing.T) {
+	fmt.Println("running: TestJobStatusWhileRunning")
+	t.Cleanup(clear)
+
+	release := make(chan struct{})
+	job := CreateJobFromOutputFunction(func(stdout io.Writer, stderr io.Writer) error {
+		for i := 0; i < 100; i++ {
+			io.WriteString(stdout, "line\n")
+		}
+		<-release
+		return nil
+	}, "test")
+	require.NoError(t, job.RunJobBackground(false))
+
+	v, ok := jobmap.Get("test")
+	require.True(t, ok)
+	running := v.(*Job)
+	assert.Eventually(t, func() bool {
+		status := running.Status()
+		return !status.Finished && status.Stdout != ""
+	}, 5*time.Second, 10*time.Millisecond)
+	close(release)
+
+	assert.Eventually(t, func() bool {
+		return running.Status().Finished
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, 100*len("line\n"), running.Stdout.Len())
+}
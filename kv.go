@@ -0,0 +1,201 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+
+	vault "github.com/hashicorp/vault/api"
+)
+
+type MountsConfig struct {
+	Config   string
+	Restic   string
+	Gocrypt  string
+	Git      string
+	Template string
+	Status   string
+}
+
+type kvKey struct {
+	config *vault.Config
+	mount  string
+}
+
+// kvMountInfo is where a configured mount lives, a mount like "kv/agents"
+// is the engine "kv" with the prefix "agents/"
+type kvMountInfo struct {
+	version int
+	mount   string
+	prefix  string
+}
+
+var kvMounts = make(map[kvKey]kvMountInfo)
+var kvLock sync.Mutex
+
+func kvMount(mount string, fallback string) string {
+	if mount == "" {
+		return fallback
+	}
+	return mount
+}
+
+// a name like "restic@3" reads version 3 of the secret "restic"
+func splitVersion(name string) (string, string, error) {
+	i := strings.LastIndex(name, KV_VERSION_SEPARATOR)
+	if i < 0 {
+		return name, "", nil
+	}
+	version := name[i+1:]
+	if n, err := strconv.Atoi(version); err != nil || n < 1 {
+		return "", "", errors.New(ERROR_KV_VERSION + name)
+	}
+	return name[:i], version, nil
+}
+
+// KVVersion asks vault which version of the KV engine is mounted, the answer
+// is kept for the lifetime of the agent
+func KVVersion(config *vault.Config, token string, mount string) (int, error) {
+	info, err := kvLookup(config, token, mount)
+	return info.version, err
+}
+
+func kvLookup(config *vault.Config, token string, mount string) (kvMountInfo, error) {
+	key := kvKey{config: config, mount: mount}
+	kvLock.Lock()
+	info, ok := kvMounts[key]
+	kvLock.Unlock()
+	if ok {
+		return info, nil
+	}
+
+	client, err := getClient(config, token)
+	if err != nil {
+		return info, err
+	}
+	secret, err := client.Logical().Read("sys/internal/ui/mounts/" + mount)
+	if err != nil {
+		return info, deniedToken(token, err)
+	}
+	if secret == nil || secret.Data == nil {
+		return info, errors.New(ERROR_KV_MOUNT + mount)
+	}
+
+	info = kvMountInfo{version: 1, mount: strings.Trim(mount, "/")}
+	if options, ok := secret.Data["options"].(map[string]interface{}); ok && options["version"] == "2" {
+		info.version = 2
+	}
+	// vault answers with the engine the path belongs to
+	if path, ok := secret.Data["path"].(string); ok && path != "" {
+		path = strings.Trim(path, "/")
+		if info.mount != path {
+			if !strings.HasPrefix(info.mount, path+"/") {
+				return info, errors.New(ERROR_KV_MOUNT + mount)
+			}
+			info.prefix = strings.TrimPrefix(info.mount, path+"/") + "/"
+			info.mount = path
+		}
+	}
+	kvLock.Lock()
+	kvMounts[key] = info
+	kvLock.Unlock()
+	return info, nil
+}
+
+// the version 2 API puts data or metadata between the mount and the name
+func (info kvMountInfo) path(api string, name string) string {
+	if info.version == 2 {
+		return info.mount + "/" + api + "/" + info.prefix + name
+	}
+	return info.mount + "/" + info.prefix + name
+}
+
+func ReadKV(config *vault.Config, token string, mount string, name string) (map[string]interface{}, error) {
+	name, version, err := splitVersion(name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := kvLookup(config, token, mount)
+	if err != nil {
+		return nil, err
+	}
+	if info.version == 1 && version != "" {
+		return nil, errors.New(ERROR_KV_VERSION_V1 + mount)
+	}
+
+	client, err := getClient(config, token)
+	if err != nil {
+		return nil, err
+	}
+
+	path := info.path("data", name)
+	var query map[string][]string
+	if info.version == 2 && version != "" {
+		query = map[string][]string{"version": {version}}
+	}
+	Sugar.Debug("Getting Data from: ", path)
+	secret, err := client.Logical().ReadWithData(path, query)
+	if err != nil {
+		return nil, deniedToken(token, err)
+	}
+	if secret == nil || secret.Data == nil {
+		return nil, errors.New(ERROR_VAULT_NO_SECRET)
+	}
+
+	data := secret.Data
+	if info.version == 2 {
+		data, _ = secret.Data["data"].(map[string]interface{})
+	}
+	if len(data) == 0 {
+		return nil, errors.New("Data of secret with path: " + path + " is empty")
+	}
+	return data, nil
+}
+
+func WriteKV(config *vault.Config, token string, mount string, name string, data map[string]interface{}) error {
+	info, err := kvLookup(config, token, mount)
+	if err != nil {
+		return err
+	}
+	client, err := getClient(config, token)
+	if err != nil {
+		return err
+	}
+
+	path := info.path("data", name)
+	if info.version == 2 {
+		data = map[string]interface{}{"data": data}
+	}
+	Sugar.Debug("Writing Data to: ", path)
+	_, err = client.Logical().Write(path, data)
+	return deniedToken(token, err)
+}
+
+func ListKV(config *vault.Config, token string, mount string) ([]string, error) {
+	info, err := kvLookup(config, token, mount)
+	if err != nil {
+		return nil, err
+	}
+	client, err := getClient(config, token)
+	if err != nil {
+		return nil, err
+	}
+
+	path := info.path("metadata", "")
+	secret, err := client.Logical().List(path)
+	if err != nil {
+		return nil, deniedToken(token, err)
+	}
+	if secret == nil || secret.Data == nil {
+		return []string{}, nil
+	}
+	keys, _ := secret.Data["keys"].([]interface{})
+	names := []string{}
+	for _, k := range keys {
+		if name, ok := k.(string); ok {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
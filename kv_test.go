@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	vault "github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKVSplitVersion(t *testing.T) {
+	fmt.Println("running: TestKVSplitVersion")
+	name, version, err := splitVersion("resticpath")
+	require.NoError(t, err)
+	assert.Equal(t, "resticpath", name)
+	assert.Equal(t, "", version)
+
+	name, version, err = splitVersion("user@host@3")
+	require.NoError(t, err)
+	assert.Equal(t, "user@host", name)
+	assert.Equal(t, "3", version)
+
+	_, _, err = splitVersion("resticpath@latest")
+	assert.Error(t, err)
+	_, _, err = splitVersion("resticpath@0")
+	assert.Error(t, err)
+}
+
+func TestKVReadKV(t *testing.T) {
+	fmt.Println("running: TestKVReadKV")
+	t.Cleanup(clear)
+	testconfig := readConfig(t)
+
+	version, err := KVVersion(testconfig.config, testconfig.token, MOUNT_RESTIC)
+	require.NoError(t, err)
+	assert.Equal(t, 2, version)
+	version, err = KVVersion(testconfig.config, testconfig.token, MOUNT_CONFIG)
+	require.NoError(t, err)
+	assert.Equal(t, 1, version)
+	_, err = KVVersion(testconfig.config, testconfig.token, "missing")
+	assert.Error(t, err)
+
+	data, err := ReadKV(testconfig.config, testconfig.token, MOUNT_RESTIC, testconfig.resticpath)
+	require.NoError(t, err)
+	assert.Equal(t, VAULT_TEST_PASSWORD, data["pw"])
+
+	// rollback to an older version of the secret
+	data, err = ReadKV(testconfig.config, testconfig.token, MOUNT_RESTIC, testconfig.resticpath+"@1")
+	require.NoError(t, err)
+	assert.Equal(t, VAULT_TEST_OLD_PASSWORD, data["pw"])
+
+	// version 1 secrets are not unpacked even if they have a data key
+	data, err = ReadKV(testconfig.config, testconfig.token, "kv1", "plain")
+	require.NoError(t, err)
+	assert.Equal(t, "not nested", data["data"])
+	assert.Equal(t, "plain", data["name"])
+	_, err = ReadKV(testconfig.config, testconfig.token, "kv1", "plain@2")
+	assert.Error(t, err)
+
+	// a mount below the engine is a prefix of the secret
+	data, err = ReadKV(testconfig.config, testconfig.token, "kv2/agents", "host")
+	require.NoError(t, err)
+	assert.Equal(t, "agents/host", data["path"])
+}
+
+func TestKVConfiguredMounts(t *testing.T) {
+	fmt.Println("running: TestKVConfiguredMounts")
+	t.Cleanup(clear)
+	testconfig := readConfig(t)
+	t.Cleanup(func() { AgentConfiguration.Mounts = MountsConfig{} })
+
+	AgentConfiguration.Mounts = MountsConfig{Restic: "missing"}
+	_, err := GetResticConfig(testconfig.config, testconfig.token, testconfig.resticpath)
+	assert.Error(t, err)
+
+	AgentConfiguration.Mounts = MountsConfig{}
+	conf, err := GetResticConfig(testconfig.config, testconfig.token, testconfig.resticpath+"@1")
+	require.NoError(t, err)
+	assert.Equal(t, VAULT_TEST_OLD_PASSWORD, conf.Password)
+
+	err = Init(&vault.Config{Address: testconfig.config.Address}, []string{
+		"--vault_role_id=" + VAULT_TEST_ROLE_ID,
+		"--vault_secret_id=" + VAULT_TEST_SECRET_ID,
+		"--vault_mount_restic=backup",
+		"--vault_mount_git=repos",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, MountsConfig{Config: MOUNT_CONFIG, Restic: "backup", Gocrypt: MOUNT_GOCRYPT, Git: "repos", Template: MOUNT_TEMPLATE}, AgentConfiguration.Mounts)
+}
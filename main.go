@@ -3,6 +3,7 @@ package main
 import (
 	"bufio"
 	"context"
+	"io"
 	"net/http"
 
 	vault "github.com/hashicorp/vault/api"
@@ -34,6 +35,36 @@ func bindEnviorment() error {
 		return err
 	}
 
+	err = viper.BindEnv(MAIN_DB_KEY_FILE)
+	if err != nil {
+		return err
+	}
+
+	err = viper.BindEnv(MAIN_DB_KEY)
+	if err != nil {
+		return err
+	}
+
+	err = viper.BindEnv(MAIN_DB_PASSPHRASE)
+	if err != nil {
+		return err
+	}
+
+	err = viper.BindEnv(MAIN_DB_NEW_KEY_FILE)
+	if err != nil {
+		return err
+	}
+
+	err = viper.BindEnv(MAIN_DB_NEW_KEY)
+	if err != nil {
+		return err
+	}
+
+	err = viper.BindEnv(MAIN_DB_NEW_PASSPHRASE)
+	if err != nil {
+		return err
+	}
+
 	err = viper.BindEnv(MAIN_TIME_DURATION)
 	if err != nil {
 		return err
@@ -48,6 +79,16 @@ func bindEnviorment() error {
 		return err
 	}
 
+	err = viper.BindEnv(MAIN_FSCK_DURATION)
+	if err != nil {
+		return err
+	}
+
+	err = viper.BindEnv(MAIN_WATCHDOG_DURATION)
+	if err != nil {
+		return err
+	}
+
 	err = viper.BindEnv(MAIN_VAULT_KEY_FILE)
 	if err != nil {
 		return err
@@ -63,6 +104,91 @@ func bindEnviorment() error {
 		return err
 	}
 
+	err = viper.BindEnv(MAIN_VAULT_WRAPPED_SECRET_ID)
+	if err != nil {
+		return err
+	}
+
+	err = viper.BindEnv(MAIN_VAULT_AUTH)
+	if err != nil {
+		return err
+	}
+
+	err = viper.BindEnv(MAIN_VAULT_TOKEN_FILE)
+	if err != nil {
+		return err
+	}
+
+	err = viper.BindEnv(MAIN_VAULT_CERT_ROLE)
+	if err != nil {
+		return err
+	}
+
+	err = viper.BindEnv(MAIN_VAULT_CLIENT_CERT)
+	if err != nil {
+		return err
+	}
+
+	err = viper.BindEnv(MAIN_VAULT_CLIENT_KEY)
+	if err != nil {
+		return err
+	}
+
+	err = viper.BindEnv(MAIN_VAULT_CA_CERT)
+	if err != nil {
+		return err
+	}
+
+	err = viper.BindEnv(MAIN_VAULT_TLS_SERVER_NAME)
+	if err != nil {
+		return err
+	}
+
+	err = viper.BindEnv(MAIN_VAULT_TLS_SKIP_VERIFY)
+	if err != nil {
+		return err
+	}
+
+	err = viper.BindEnv(MAIN_VAULT_USERNAME)
+	if err != nil {
+		return err
+	}
+
+	err = viper.BindEnv(MAIN_VAULT_PASSWORD)
+	if err != nil {
+		return err
+	}
+
+	err = viper.BindEnv(MAIN_VAULT_MOUNT_CONFIG)
+	if err != nil {
+		return err
+	}
+
+	err = viper.BindEnv(MAIN_VAULT_MOUNT_RESTIC)
+	if err != nil {
+		return err
+	}
+
+	err = viper.BindEnv(MAIN_VAULT_MOUNT_GOCRYPT)
+	if err != nil {
+		return err
+	}
+
+	err = viper.BindEnv(MAIN_VAULT_MOUNT_GIT)
+	if err != nil {
+		return err
+	}
+
+	err = viper.BindEnv(MAIN_VAULT_MOUNT_TEMPLATE)
+	if err != nil {
+		return err
+	}
+
+	err = viper.BindEnv(MAIN_VAULT_MOUNT_STATUS)
+	if err != nil {
+		return err
+	}
+
 	err = viper.BindEnv(MAIN_BACKUP)
 	if err != nil {
 		return err
@@ -73,16 +199,37 @@ func bindEnviorment() error {
 
 func Init(vaultConfig *vault.Config, args []string) error {
 	jobmap = cmap.New()
+	mountmap = cmap.New()
 	addressCommend := pflag.NewFlagSet("agent", pflag.ContinueOnError)
 	addressCommend.String(MAIN_ADDRESS, "localhost:8081", "the addess on which rest server of the agent is startet")
 	addressCommend.String(MAIN_PATHDB, "/opt/agent/db", "The path where to save the Database")
+	addDBKeyFlags(addressCommend)
 	addressCommend.String(MAIN_TIME_DURATION, "30m", "The duration between backups")
 	addressCommend.String(MAIN_MOUNT_DURATION, "", "The Duration how long the gocrypt should be mounted")
 	addressCommend.String(MAIN_MOUNT_ALLOW, "true", "If the gocrypt mount should be allowed by other users")
+	addressCommend.String(MAIN_FSCK_DURATION, "24h", "The duration between gocryptfs integrity checks")
+	addressCommend.String(MAIN_WATCHDOG_DURATION, "30s", "The duration between checks for stale mounts, 0s disables the watchdog")
 	addressCommend.String(MAIN_VAULT_KEY_FILE, "", "File in which the vault keys are stored for easy save into Badger database")
 	addressCommend.String(MAIN_VAULT_ADDRESS, "https://localhost:8200", "The address to the vault server")
 	addressCommend.String(MAIN_VAULT_ROLE_ID, "", "Role ID for AppRole login into Vault")
 	addressCommend.String(MAIN_VAULT_SECRET_ID, "", "Secret ID for AppRole login into Vault")
+	addressCommend.String(MAIN_VAULT_WRAPPED_SECRET_ID, "", "Response-wrapping token for the AppRole Secret ID, unwrapped once on the first login")
+	addressCommend.String(MAIN_VAULT_AUTH, AUTH_APPROLE, "Vault auth method: approle, token_file, cert or userpass")
+	addressCommend.String(MAIN_VAULT_TOKEN_FILE, "", "File with the Vault token, e.g. written by Vault Agent")
+	addressCommend.String(MAIN_VAULT_CERT_ROLE, "", "Name of the cert role for TLS certificate login into Vault")
+	addressCommend.String(MAIN_VAULT_CLIENT_CERT, "", "Client certificate for the TLS connection to Vault and certificate login")
+	addressCommend.String(MAIN_VAULT_CLIENT_KEY, "", "Client key for the TLS connection to Vault and certificate login")
+	addressCommend.String(MAIN_VAULT_CA_CERT, "", "CA bundle to verify the Vault server certificate")
+	addressCommend.String(MAIN_VAULT_TLS_SERVER_NAME, "", "Server name expected in the Vault server certificate")
+	addressCommend.String(MAIN_VAULT_TLS_SKIP_VERIFY, "false", "Skip the verification of the Vault server certificate")
+	addressCommend.String(MAIN_VAULT_USERNAME, "", "Username for userpass login into Vault")
+	addressCommend.String(MAIN_VAULT_PASSWORD, "", "Password for userpass login into Vault")
+	addressCommend.String(MAIN_VAULT_MOUNT_CONFIG, MOUNT_CONFIG, "Vault mount of the agent configs")
+	addressCommend.String(MAIN_VAULT_MOUNT_RESTIC, MOUNT_RESTIC, "Vault mount of the restic configs")
+	addressCommend.String(MAIN_VAULT_MOUNT_GOCRYPT, MOUNT_GOCRYPT, "Vault mount of the gocryptfs configs")
+	addressCommend.String(MAIN_VAULT_MOUNT_GIT, MOUNT_GIT, "Vault mount of the git configs")
+	addressCommend.String(MAIN_VAULT_MOUNT_TEMPLATE, MOUNT_TEMPLATE, "Vault mount of the template configs")
+	addressCommend.String(MAIN_VAULT_MOUNT_STATUS, "", "Vault mount the agent status is published to after each run, e.g. "+MOUNT_STATUS+", empty disables it")
 	addressCommend.String(MAIN_BACKUP, "true", "Do backup yes = true")
 
 	err := bindEnviorment()
@@ -114,10 +261,57 @@ func Init(vaultConfig *vault.Config, args []string) error {
 	if !config.useLogin {
 		return errors.New(MAIN_ERROR_LOGIN)
 	}
+	err = config.ConfigureTLS()
+	if err != nil {
+		return err
+	}
 	AgentConfiguration = config
 	return nil
 }
 
+func addDBKeyFlags(flags *pflag.FlagSet) {
+	flags.String(MAIN_DB_KEY_FILE, "", "File with the hex encoded key of the database")
+	flags.String(MAIN_DB_KEY, "", "Hex encoded key of the database, meant to be set as AGENT_DB_KEY")
+	flags.String(MAIN_DB_PASSPHRASE, "", "Passphrase the key of the database is derived from")
+}
+
+// RotateKey re-encrypts the database with the new key given by the db_new_*
+// flags, the agent has to be stopped while it runs
+func RotateKey(args []string) error {
+	flags := pflag.NewFlagSet(MAIN_COMMAND_ROTATE_KEY, pflag.ContinueOnError)
+	flags.String(MAIN_PATHDB, "/opt/agent/db", "The path where to save the Database")
+	addDBKeyFlags(flags)
+	flags.String(MAIN_DB_NEW_KEY_FILE, "", "File with the new hex encoded key of the database")
+	flags.String(MAIN_DB_NEW_KEY, "", "New hex encoded key of the database")
+	flags.String(MAIN_DB_NEW_PASSPHRASE, "", "New passphrase the key of the database is derived from")
+
+	err := bindEnviorment()
+	if err != nil {
+		return err
+	}
+	err = viper.BindPFlags(flags)
+	if err != nil {
+		return err
+	}
+	err = flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	path := viper.GetString(MAIN_PATHDB)
+	next := DBKeyConfig{
+		KeyFile:    viper.GetString(MAIN_DB_NEW_KEY_FILE),
+		Key:        viper.GetString(MAIN_DB_NEW_KEY),
+		Passphrase: viper.GetString(MAIN_DB_NEW_PASSPHRASE),
+	}
+	err = RotateDBKey(path, parseDBKeyConfig(), next)
+	if err != nil {
+		return err
+	}
+	Sugar.Info(MAIN_MESSAGE_ROTATED, path)
+	return nil
+}
+
 func CheckKeyFile(path string) error {
 	stat, err := os.Stat(path)
 	if err != nil {
@@ -157,12 +351,15 @@ func checkRequirements() (string, bool) {
 		return "", false
 	}
 
-	token, err := Login(AgentConfiguration.VaultConfig, AgentConfiguration.RoleID, AgentConfiguration.SecretID)
+	tokenLock.Lock()
+	defer tokenLock.Unlock()
+	token, err := GetVaultToken(AgentConfiguration.DB, AgentConfiguration.VaultConfig, AgentConfiguration.Auth)
 	if err != nil {
 		Sugar.Error("Login failed: ", err)
 		return "", false
 	}
-	return token, true
+	scheduleTokenRenewal(token)
+	return token.Token, true
 }
 
 func CheckBackupRepository() {
@@ -197,6 +394,36 @@ func CheckBackupRepository() {
 	}
 }
 
+func CheckIntegrity() {
+	token, ok := checkRequirements()
+	if !ok {
+		return
+	}
+
+	t, err := getTimestamp(AgentConfiguration.DB, STORE_LAST_FSCK)
+	if err != nil {
+		Sugar.Error(ERROR_TIMESTAMP, err)
+	}
+	Sugar.Debug("Last Fsck: ", t.String())
+
+	t = t.Add(AgentConfiguration.FsckDuration)
+	now := time.Now()
+	Sugar.Info("Next Fsck after: ", t.String())
+	if now.After(t) {
+		str, err := DoFsck(token)
+		if err != nil {
+			Sugar.Error(err)
+			return
+		}
+		Sugar.Info(str)
+
+		_, err = Put(AgentConfiguration.DB, STORE_LAST_FSCK, time.Now().Format(time.RFC3339Nano))
+		if err != nil {
+			Sugar.Error(err)
+		}
+	}
+}
+
 func mountFolders() {
 	token, ok := checkRequirements()
 	if !ok {
@@ -211,6 +438,19 @@ func mountFolders() {
 	Sugar.Info(str)
 }
 
+func renderTemplates() {
+	token, ok := checkRequirements()
+	if !ok {
+		return
+	}
+
+	str, err := DoTemplate(token)
+	Sugar.Info(str)
+	if err != nil {
+		Sugar.Error(err)
+	}
+}
+
 func backup() {
 	token, ok := checkRequirements()
 	if !ok {
@@ -229,6 +469,7 @@ func backup() {
 	if now.After(t) {
 		BackupRepositoryExists(token)
 		err = DoBackupVerbose(token, "backup")
+		UpdateBackupResult(AgentConfiguration.DB, err)
 		if err != nil {
 			Sugar.Error(err)
 			return
@@ -257,35 +498,81 @@ func GitCheckout() {
 		return
 	}
 
-	str, ok, err := DoGit(token, "clone", true, true)
+	str, _, err := DoGit(token, GIT_MODE_UPDATE, true, true)
 	if err != nil {
 		Sugar.Error("Error:", err)
 		return
 	}
 	Sugar.Info(str)
+}
 
-	if ok {
+func reportStatus() {
+	if AgentConfiguration.Mounts.Status == "" {
+		Sugar.Debug(MAIN_MESSAGE_STATUS_DISABLED)
 		return
-	} else {
-		str, _, err = DoGit(token, "pull", true, true)
-		if err != nil {
-			Sugar.Error(err.Error())
-			return
-		}
-		Sugar.Info(str)
+	}
+	token, ok := checkRequirements()
+	if !ok {
+		return
+	}
 
+	git, err := DoGitStatus(token)
+	if err != nil {
+		Sugar.Error(err)
+	}
+	status := CollectStatus(AgentConfiguration.DB, AgentConfiguration.Hostname, git)
+	err = PublishStatus(AgentConfiguration.VaultConfig, token, AgentConfiguration.Mounts.Status, status)
+	if err != nil {
+		Sugar.Error(ERROR_STATUS_PUBLISH, err)
 	}
 }
 
+// Fleet logs in with the same flags as the agent and prints the status every
+// agent published. The database stays closed since the agent may hold it.
+func Fleet(out io.Writer, args []string) error {
+	err := Init(vault.DefaultConfig(), args)
+	if err != nil {
+		return err
+	}
+	if auth, ok := AgentConfiguration.Auth.(AppRoleAuth); ok && auth.SecretID == "" {
+		return errors.New(MAIN_ERROR_FLEET_WRAPPED)
+	}
+
+	secret, err := AgentConfiguration.Auth.Login(AgentConfiguration.VaultConfig)
+	if err != nil {
+		return err
+	}
+	// the token was only needed for this one read, the token of a Vault
+	// Agent is not ours to revoke
+	if _, ok := AgentConfiguration.Auth.(TokenFileAuth); !ok {
+		defer func() {
+			err := RevokeToken(AgentConfiguration.VaultConfig, secret.ClientToken)
+			if err != nil {
+				Sugar.Warn(ERROR_TOKEN_REVOKE, err)
+			}
+		}()
+	}
+	mount := kvMount(AgentConfiguration.Mounts.Status, MOUNT_STATUS)
+	fleet, err := ReadFleet(AgentConfiguration.VaultConfig, secret.ClientToken, mount)
+	if err != nil {
+		return err
+	}
+	return PrintFleet(out, fleet)
+}
+
 func Start() {
 	Sugar.Warn("Waking from Sleep")
+	CheckIntegrity()
 	mountFolders()
+	renderTemplates()
 	GitCheckout()
 	if AgentConfiguration.backup {
 		backup()
 		CheckBackupRepository()
+		reportStatus()
 		Sugar.Warn("Going to Sleep")
 	} else{
+		reportStatus()
 		Sugar.Warn("Exiting since backup is disabled")
 		os.Exit(0)
 	}
@@ -309,6 +596,7 @@ func unsealVault(seal *vault.SealStatusResponse) {
 
 func run() {
 	seal, err := SealStatus(AgentConfiguration.VaultConfig)
+	UpdateSealCheck(AgentConfiguration.DB, seal, err)
 	if err != nil {
 		Sugar.Error(MAIN_ERROR_CHECK_SEAL, err)
 	} else {
@@ -324,6 +612,23 @@ func run() {
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == MAIN_COMMAND_FLEET {
+		err := Fleet(os.Stdout, os.Args[1:])
+		if err != nil {
+			Sugar.Error(err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == MAIN_COMMAND_ROTATE_KEY {
+		err := RotateKey(os.Args[1:])
+		if err != nil {
+			Sugar.Error(err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	StartProfiler()
 	stopChan = make(chan os.Signal, 2)
 	signal.Notify(stopChan, os.Interrupt)
@@ -333,6 +638,10 @@ func main() {
 		if AgentConfiguration.Timer != nil {
 			AgentConfiguration.Timer.Stop()
 		}
+		if AgentConfiguration.WatchdogTimer != nil {
+			AgentConfiguration.WatchdogTimer.Stop()
+		}
+		stopTokenRenewal()
 
 		if AgentConfiguration.DB != nil {
 			Close(AgentConfiguration.DB, 5*time.Millisecond)
@@ -348,7 +657,18 @@ func main() {
 	if err != nil {
 		Sugar.Error("ERROR", err)
 	}
-	AgentConfiguration.DB = InitDB(AgentConfiguration.PathDB, "", false)
+	// the unseal keys must not end up in a plaintext database by accident
+	key, err := LoadDBKey(AgentConfiguration.PathDB, AgentConfiguration.DBKey)
+	if err != nil {
+		Sugar.Fatal(MAIN_ERROR_DB_KEY, err)
+	}
+	if key == nil {
+		Sugar.Warn(STORE_MESSAGE_PLAINTEXT)
+	}
+	AgentConfiguration.DB = InitDB(AgentConfiguration.PathDB, string(key), false)
+	if AgentConfiguration.DB == nil {
+		Sugar.Fatal(MAIN_ERROR_DB, AgentConfiguration.PathDB)
+	}
 
 	if AgentConfiguration.VaultKeyFile != "" {
 		err = CheckKeyFile(AgentConfiguration.VaultKeyFile)
@@ -363,6 +683,7 @@ func main() {
 	go func() {
 		Sugar.Info(MAIN_MESSAGE_START_RUNNING, "\t", AgentConfiguration.Hostname)
 		AgentConfiguration.Timer = time.AfterFunc(5*time.Second, run)
+		StartWatchdog()
 	}()
 
 	Sugar.Debug(MAIN_MESSAGE_START_RESTSERVER)
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+type MountStatus struct {
+	Mounted     bool `json:"mounted"`
+	ReadOnly    bool `json:"read_only"`
+	Stale       bool `json:"stale"`
+	Initialized bool `json:"initialized"`
+}
+
+type Mounter interface {
+	Mount(home string, conf GocryptConfig) (*exec.Cmd, error)
+	Unmount(home string, conf GocryptConfig) *exec.Cmd
+	Status(home string, conf GocryptConfig) (MountStatus, error)
+	Init(home string, conf GocryptConfig) (*exec.Cmd, error)
+	// Fsck returns no command if the filesystem can not be checked
+	Fsck(home string, conf GocryptConfig) (*exec.Cmd, error)
+}
+
+func GetMounter(kind string) (Mounter, error) {
+	switch kind {
+	case "", MOUNTER_GOCRYPTFS:
+		return GocryptfsMounter{}, nil
+	case MOUNTER_CRYFS:
+		return CryfsMounter{}, nil
+	case MOUNTER_SECUREFS:
+		return SecurefsMounter{}, nil
+	default:
+		return nil, errors.New(ERROR_MOUNTER + kind)
+	}
+}
+
+// the status is the same for every fuse based filesystem
+func fuseStatus(home string, mountPoint string) (MountStatus, error) {
+	var status MountStatus
+	if IsStale(home, mountPoint) {
+		status.Mounted = true
+		status.Stale = true
+		return status, nil
+	}
+
+	mounted, readOnly, err := IsMounted(home, mountPoint)
+	if err != nil {
+		return status, err
+	}
+	status.Mounted = mounted
+	status.ReadOnly = readOnly
+	return status, nil
+}
+
+// a volume is initialized once the filesystem wrote its configuration
+func isInitialized(home string, cryptoDir string, configFile string) bool {
+	cryptoDir = strings.ReplaceAll(cryptoDir, HOME, home)
+	_, err := os.Stat(filepath.Join(cryptoDir, configFile))
+	return err == nil
+}
+
+type GocryptfsMounter struct{}
+
+func (GocryptfsMounter) Mount(home string, conf GocryptConfig) (*exec.Cmd, error) {
+	if conf.Reverse {
+		return MountReverseGocryptfs(conf.Path, conf.MountPoint, home, conf.Password)
+	}
+	return MountGocryptfs(conf.Path, conf.MountPoint, home, conf.MountDuration, conf.Password, conf.AllowOther, conf.NotEmpty)
+}
+
+func (GocryptfsMounter) Unmount(home string, conf GocryptConfig) *exec.Cmd {
+	return UnmountGocryptfs(conf.MountPoint, home)
+}
+
+func (GocryptfsMounter) Status(home string, conf GocryptConfig) (MountStatus, error) {
+	status, err := fuseStatus(home, conf.MountPoint)
+	configFile := GOCRYPTFS_CONFIG
+	if conf.Reverse {
+		configFile = GOCRYPTFS_REVERSE_CONFIG
+	}
+	status.Initialized = isInitialized(home, conf.Path, configFile)
+	return status, err
+}
+
+func (GocryptfsMounter) Init(home string, conf GocryptConfig) (*exec.Cmd, error) {
+	return InitGocryptfs(conf.Path, home, conf.Password, conf.Reverse)
+}
+
+// a reverse volume is plaintext on disk, there is nothing to check
+func (GocryptfsMounter) Fsck(home string, conf GocryptConfig) (*exec.Cmd, error) {
+	if conf.Reverse {
+		return nil, nil
+	}
+	return FsckGocryptfs(conf.Path, home, conf.Password)
+}
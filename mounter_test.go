@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMounterGetMounter(t *testing.T) {
+	fmt.Println("running: TestMounterGetMounter")
+	mounter, err := GetMounter("")
+	assert.NoError(t, err)
+	assert.IsType(t, GocryptfsMounter{}, mounter)
+
+	mounter, err = GetMounter(MOUNTER_GOCRYPTFS)
+	assert.NoError(t, err)
+	assert.IsType(t, GocryptfsMounter{}, mounter)
+
+	mounter, err = GetMounter(MOUNTER_CRYFS)
+	assert.NoError(t, err)
+	assert.IsType(t, CryfsMounter{}, mounter)
+
+	mounter, err = GetMounter(MOUNTER_SECUREFS)
+	assert.NoError(t, err)
+	assert.IsType(t, SecurefsMounter{}, mounter)
+
+	mounter, err = GetMounter("unknown")
+	assert.EqualError(t, err, ERROR_MOUNTER+"unknown")
+	assert.Nil(t, mounter)
+}
+
+func TestMounterGocryptfsMounter(t *testing.T) {
+	fmt.Println("running: TestMounterGocryptfsMounter")
+	home, err := os.Getwd()
+	require.NoError(t, err)
+
+	conf := GocryptConfig{
+		MountPoint: GOCRYPT_TEST_MOUNTPATH,
+		Path:       GOCRYPT_TEST_FOLDER,
+		Password:   VAULT_TEST_PASSWORD,
+	}
+	mounter := GocryptfsMounter{}
+
+	cmd, err := mounter.Mount(home, conf)
+	require.NoError(t, err)
+	assert.Contains(t, cmd.String(), "gocryptfs -passfile /dev/fd/3 "+home+"/test/tmp "+home+"/test/tmp-mount")
+
+	conf.Reverse = true
+	cmd, err = mounter.Mount(home, conf)
+	require.NoError(t, err)
+	assert.Contains(t, cmd.String(), "gocryptfs -reverse")
+
+	cmd, err = mounter.Init(home, conf)
+	require.NoError(t, err)
+	assert.Contains(t, cmd.String(), "gocryptfs -init -q -reverse -passfile /dev/fd/3 "+home+"/test/tmp")
+	assert.NotContains(t, cmd.String(), VAULT_TEST_PASSWORD)
+
+	cmd = mounter.Unmount(home, conf)
+	assert.Contains(t, cmd.String(), home+"/test/tmp-mount")
+
+	cmd, err = mounter.Fsck(home, conf)
+	assert.NoError(t, err)
+	assert.Nil(t, cmd)
+
+	status, err := mounter.Status(home, GocryptConfig{MountPoint: "~/test", Path: GOCRYPT_TEST_FOLDER})
+	assert.NoError(t, err)
+	assert.False(t, status.Mounted)
+	assert.False(t, status.Stale)
+	assert.True(t, status.Initialized)
+
+	conf.Reverse = false
+	cmd, err = mounter.Fsck(home, conf)
+	require.NoError(t, err)
+	assert.Contains(t, cmd.String(), "gocryptfs -fsck")
+	CloseExtraFiles(cmd)
+}
+
+func TestMounterCryfsMounter(t *testing.T) {
+	fmt.Println("running: TestMounterCryfsMounter")
+	home, err := os.Getwd()
+	require.NoError(t, err)
+
+	conf := GocryptConfig{
+		Type:       MOUNTER_CRYFS,
+		MountPoint: "~/test",
+		Path:       GOCRYPT_TEST_FOLDER,
+	}
+	mounter := CryfsMounter{}
+
+	status, err := mounter.Status(home, conf)
+	assert.NoError(t, err)
+	assert.False(t, status.Initialized)
+
+	cmd, err := mounter.Fsck(home, conf)
+	assert.NoError(t, err)
+	assert.Nil(t, cmd)
+
+	result := RunFsck(home, conf)
+	assert.Equal(t, FSCK_STATUS_SKIPPED, result.Status)
+	assert.Equal(t, FSCK_MESSAGE_TYPE+conf.Name, result.Output)
+}
+
+func TestMounterInitFolders(t *testing.T) {
+	fmt.Println("running: TestMounterInitFolders")
+	home, err := os.Getwd()
+	require.NoError(t, err)
+	dir, err := ioutil.TempDir("", "agent-init")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	configs := []GocryptConfig{
+		{Name: "existing", Path: GOCRYPT_TEST_FOLDER, MountPoint: "~/test", Init: true},
+		{Name: "notmarked", Path: filepath.Join(dir, "notmarked"), MountPoint: "~/test"},
+		{Name: "new", Path: filepath.Join(dir, "new", "volume"), MountPoint: "~/test", Init: true},
+		{Name: "unsupported", Type: "unknown", Path: dir, MountPoint: "~/test", Init: true},
+	}
+	cmds := InitFolders(home, configs)
+	require.Len(t, cmds, 1)
+	assert.Contains(t, cmds[0].String(), "gocryptfs -init -q -passfile /dev/fd/3 "+filepath.Join(dir, "new", "volume"))
+	assert.DirExists(t, filepath.Join(dir, "new", "volume"))
+	assert.NoDirExists(t, filepath.Join(dir, "notmarked"))
+	CloseExtraFiles(cmds[0])
+}
+
+func TestMounterMountFolders(t *testing.T) {
+	fmt.Println("running: TestMounterMountFolders")
+	home, err := os.Getwd()
+	require.NoError(t, err)
+
+	conf := GocryptConfig{
+		MountPoint: GOCRYPT_TEST_MOUNTPATH,
+		Path:       GOCRYPT_TEST_FOLDER,
+		NotEmpty:   true,
+	}
+	conf.Type = "unknown"
+	cmds := MountFolders(home, []GocryptConfig{conf})
+	assert.Empty(t, cmds)
+
+	conf.Type = MOUNTER_CRYFS
+	cmds = MountFolders(home, []GocryptConfig{conf})
+	require.Len(t, cmds, 1)
+	assert.Contains(t, cmds[0].String(), "cryfs")
+}
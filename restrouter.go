@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"errors"
 	"net/http"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 )
@@ -110,11 +111,17 @@ func getStatus(c *gin.Context) {
 		return
 	}
 	var buffer bytes.Buffer
+	jobs := []JobStatus{}
 	for _, k := range jobmap.Keys() {
 		v, ok := jobmap.Get(k)
 		if ok {
 			cmd := v.(*Job)
-			buffer.WriteString("Job: " + k + " Status: " + cmd.Cmd.ProcessState.String())
+			jobs = append(jobs, cmd.Status())
+			if cmd.Cmd != nil {
+				buffer.WriteString("Job: " + k + " Status: " + cmd.Cmd.ProcessState.String())
+			} else {
+				buffer.WriteString("Job: " + k + " Finished: " + strconv.FormatBool(cmd.IsFinished()))
+			}
 		} else {
 			buffer.WriteString("Job: " + k + " Error while retrieving")
 		}
@@ -123,9 +130,26 @@ func getStatus(c *gin.Context) {
 		buffer.WriteString("No Job started")
 	}
 
+	fsck, err := GetFsckResults(AgentConfiguration.DB)
+	if err != nil {
+		Sugar.Debug(ERROR_STATUS, err)
+	}
+	events, err := GetEvents(AgentConfiguration.DB, EVENT_LIMIT)
+	if err != nil {
+		Sugar.Debug(ERROR_STATUS, err)
+	}
+	token, err := GetTokenStatus(AgentConfiguration.DB)
+	if err != nil {
+		Sugar.Debug(ERROR_STATUS, err)
+	}
+
 	Sugar.Info("Get Status: ", buffer.String())
 	c.JSON(http.StatusOK, gin.H{
 		REST_JSON_MESSAGE: buffer.String(),
+		REST_JSON_JOBS:    jobs,
+		REST_JSON_FSCK:    fsck,
+		REST_JSON_EVENTS:  events,
+		REST_JSON_TOKEN:   token,
 	})
 }
 
@@ -205,6 +229,23 @@ func postGit(c *gin.Context) {
 	}
 }
 
+func getGitStatus(c *gin.Context) {
+	token, ok := checkRequirements()
+	if !ok {
+		returnErr(errors.New(ERROR_VAULT_LOGIN), ERROR_GIT_STATUS, c)
+		return
+	}
+
+	status, err := DoGitStatus(token)
+	if err != nil {
+		returnErr(err, ERROR_GIT_STATUS, c)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		REST_JSON_GIT: status,
+	})
+}
+
 func RunRestServer(address string) (*http.Server, func()) {
 	server := &http.Server{
 		Addr:    address,
@@ -240,5 +281,6 @@ func CreateRestHandler() http.Handler {
 	r.POST("/git", postGit)
 	r.GET("/is_sealed", getIsSealed)
 	r.GET("/status", getStatus)
+	r.GET("/git/status", getGitStatus)
 	return r
 }
@@ -13,6 +13,7 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	git "github.com/go-git/go-git/v5"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -292,11 +293,23 @@ func TestRestStatus(t *testing.T) {
 		"application/json", bytes.NewBuffer(reqBody))
 	assert.NoError(t, err)
 
+	_, err = PutToken(AgentConfiguration.DB, VaultToken{
+		Token:    VAULT_TEST_TOKEN,
+		Policies: []string{"default"},
+		Lease:    time.Hour,
+		Expire:   time.Now().Add(time.Hour),
+	})
+	require.NoError(t, err)
+
 	resp, err := http.Get(REST_TEST_STATUS)
 	assert.NoError(t, err)
 	defer resp.Body.Close()
 
 	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Contains(t, string(body), "\""+REST_JSON_TOKEN+"\":{\"policies\":[\"default\"]")
+	assert.NotContains(t, string(body), VAULT_TEST_TOKEN)
 
 	err = server.Shutdown(context.Background())
 	assert.NoError(t, err)
@@ -449,3 +462,28 @@ func TestRestForbidden(t *testing.T) {
 	err := server.Shutdown(context.Background())
 	assert.NoError(t, err)
 }
+
+func TestRestGitStatus(t *testing.T) {
+	fmt.Println("running: TestRestGitStatus")
+	t.Cleanup(clear)
+	setupRestrouterTest(t)
+	server, fun := RunRestServer(MAIN_TEST_ADDRESS)
+
+	go fun()
+	time.Sleep(1 * time.Millisecond)
+
+	bodyStr := sendingGet(t, REST_TEST_GIT_STATUS, http.StatusOK)
+	var body map[string][]GitStatus
+	require.NoError(t, json.Unmarshal([]byte(bodyStr), &body))
+	status := body[REST_JSON_GIT]
+	require.Len(t, status, 2)
+	assert.Equal(t, "gitpath", status[0].Name)
+	assert.Equal(t, git.ErrRepositoryNotExists.Error(), status[0].Error)
+	assert.Equal(t, "vimrc", status[1].Name)
+
+	err := server.Shutdown(context.Background())
+	assert.NoError(t, err)
+
+	err = AgentConfiguration.DB.Close()
+	assert.NoError(t, err)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+)
+
+type SecurefsMounter struct{}
+
+func (SecurefsMounter) Mount(home string, conf GocryptConfig) (*exec.Cmd, error) {
+	return MountSecurefs(conf.Path, conf.MountPoint, home, conf.Password, conf.AllowOther, conf.NotEmpty), nil
+}
+
+func (SecurefsMounter) Unmount(home string, conf GocryptConfig) *exec.Cmd {
+	return UnmountGocryptfs(conf.MountPoint, home)
+}
+
+func (SecurefsMounter) Status(home string, conf GocryptConfig) (MountStatus, error) {
+	status, err := fuseStatus(home, conf.MountPoint)
+	status.Initialized = isInitialized(home, conf.Path, SECUREFS_CONFIG)
+	return status, err
+}
+
+// securefs has no integrity check of its own
+func (SecurefsMounter) Fsck(home string, conf GocryptConfig) (*exec.Cmd, error) {
+	return nil, nil
+}
+
+// securefs asks for the new password twice
+func (SecurefsMounter) Init(home string, conf GocryptConfig) (*exec.Cmd, error) {
+	cryptoDir := strings.ReplaceAll(conf.Path, HOME, home)
+
+	cmd := exec.Command("securefs", "create", cryptoDir)
+	cmd.Env = os.Environ()
+	cmd.Stdin = strings.NewReader(conf.Password + "\n" + conf.Password + "\n")
+	return cmd, nil
+}
+
+// securefs has no idle unmount, so the mount duration is not used
+func MountSecurefs(cryptoDir string, folder string, home string, pwd string, allowOther bool, notEmpty bool) *exec.Cmd {
+	options := []string{"mount", "--background"}
+
+	var fuseOptions []string
+	if allowOther {
+		fuseOptions = append(fuseOptions, "allow_other")
+	}
+	if notEmpty {
+		fuseOptions = append(fuseOptions, "nonempty")
+	}
+	if len(fuseOptions) > 0 {
+		options = append(options, "-o", strings.Join(fuseOptions, ","))
+	}
+
+	cryptoDir = strings.ReplaceAll(cryptoDir, HOME, home)
+	folder = strings.ReplaceAll(folder, HOME, home)
+	options = append(options, cryptoDir, folder)
+
+	Sugar.Debug("Mounting securefs: ", folder, " AllowOther", allowOther, " NotEmpty", notEmpty)
+	cmd := exec.Command("securefs", options...)
+	// securefs reads the password from stdin when it is not a terminal
+	cmd.Env = os.Environ()
+	cmd.Stdin = strings.NewReader(pwd + "\n")
+	return cmd
+}
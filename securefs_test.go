@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSecurefsMountSecurefs(t *testing.T) {
+	fmt.Println("running: TestSecurefsMountSecurefs")
+	home, err := os.Getwd()
+	require.NoError(t, err)
+
+	cmd := MountSecurefs(GOCRYPT_TEST_FOLDER, GOCRYPT_TEST_MOUNTPATH, home, VAULT_TEST_PASSWORD, false, false)
+	assert.Contains(t, cmd.String(), "securefs mount --background "+home+"/test/tmp "+home+"/test/tmp-mount")
+	assert.NotContains(t, cmd.String(), "-o")
+
+	cmd = MountSecurefs(GOCRYPT_TEST_FOLDER, GOCRYPT_TEST_MOUNTPATH, home, VAULT_TEST_PASSWORD, true, true)
+	assert.Contains(t, cmd.String(), "securefs mount --background -o allow_other,nonempty "+home+"/test/tmp "+home+"/test/tmp-mount")
+	assert.NotContains(t, cmd.String(), VAULT_TEST_PASSWORD)
+
+	pwd, err := ioutil.ReadAll(cmd.Stdin)
+	assert.NoError(t, err)
+	assert.Equal(t, VAULT_TEST_PASSWORD+"\n", string(pwd))
+}
+
+func TestSecurefsMounter(t *testing.T) {
+	fmt.Println("running: TestSecurefsMounter")
+	home, err := os.Getwd()
+	require.NoError(t, err)
+
+	conf := GocryptConfig{
+		Type:       MOUNTER_SECUREFS,
+		MountPoint: GOCRYPT_TEST_MOUNTPATH,
+		Path:       GOCRYPT_TEST_FOLDER,
+		Password:   VAULT_TEST_PASSWORD,
+	}
+	mounter := SecurefsMounter{}
+
+	cmd := mounter.Unmount(home, conf)
+	assert.Contains(t, cmd.Args, home+"/test/tmp-mount")
+
+	cmd, err = mounter.Init(home, conf)
+	require.NoError(t, err)
+	assert.Contains(t, cmd.String(), "securefs create "+home+"/test/tmp")
+	assert.NotContains(t, cmd.String(), VAULT_TEST_PASSWORD)
+	pwd, err := ioutil.ReadAll(cmd.Stdin)
+	assert.NoError(t, err)
+	assert.Equal(t, VAULT_TEST_PASSWORD+"\n"+VAULT_TEST_PASSWORD+"\n", string(pwd))
+
+	status, err := mounter.Status(home, conf)
+	assert.NoError(t, err)
+	assert.False(t, status.Initialized)
+
+	cmd, err = mounter.Fsck(home, conf)
+	assert.NoError(t, err)
+	assert.Nil(t, cmd)
+}
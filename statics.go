@@ -1,5 +1,7 @@
 package main
 
+import "time"
+
 const (
 	// Backup Constants
 	RESTIC_PASSWORD   = "RESTIC_PASSWORD="
@@ -8,26 +10,135 @@ const (
 	RESTIC_SECRET_KEY = "AWS_SECRET_ACCESS_KEY="
 
 	// Git Contstatns
-	GIT_REMOTE_NAME = "agent_remote"
+	GIT_REMOTE_NAME        = "agent_remote"
+	GIT_REMOTE_CANDIDATE   = "agent_candidate"
+	GIT_CANDIDATE_REFS     = "refs/agent-candidate/"
+	GIT_CONFIG_SECTION     = "agent"
+	GIT_CONFIG_REMOTE      = "remote"
+	GIT_DEFAULT_AUTHOR     = "agent"
+	GIT_DEFAULT_EMAIL      = "agent@localhost"
+	GIT_DEFAULT_MESSAGE    = "agent sync from {{.Hostname}} at {{.Timestamp}}"
+	GIT_STRATEGY_FF        = "ff-only"
+	GIT_STRATEGY_NONE      = "none"
+	GIT_STRATEGY_MERGE     = "merge"
+	GIT_STRATEGY_REBASE    = "rebase"
+	GIT_MERGE_MESSAGE      = "Merge remote branch "
+	GIT_MODE_CLONE         = "clone"
+	GIT_MODE_PULL          = "pull"
+	GIT_MODE_SYNC          = "sync"
+	GIT_MODE_MIRROR        = "mirror"
+	GIT_MODE_UPDATE        = "update"
+	GIT_SIDE_LOCAL         = 1
+	GIT_SIDE_REMOTE        = 2
+	GIT_SIDE_BOTH          = GIT_SIDE_LOCAL | GIT_SIDE_REMOTE
+	GIT_DIRTY_SKIP         = "skip"
+	GIT_DIRTY_BACKUP       = "backup"
+	GIT_DIRTY_RESET        = "reset"
+	GIT_BACKUP_SUFFIX      = ".agent-backup"
+	GIT_MIRROR_BACKUP      = "refs/agent-backup/"
+	GIT_BACKUP_FORMAT      = "20060102-150405"
+	GIT_MESSAGE_DIRTY_SKIP = "Worktree has local changes, not pulling: "
+	GIT_COMMIT_LIMIT       = 50
+	GIT_HOOK_OLD_COMMIT    = "AGENT_GIT_OLD_COMMIT"
+	GIT_HOOK_NEW_COMMIT    = "AGENT_GIT_NEW_COMMIT"
+	GIT_HOOK_NAME          = "AGENT_GIT_NAME"
+	GIT_HOOK_DIR           = "AGENT_GIT_DIR"
+	GIT_VERIFY_ALL         = "all"
+	GIT_VERIFY_TIP         = "tip"
+	GIT_MESSAGE_REJECTED   = "Rejected unsigned or untrusted update of "
+	GIT_MESSAGE_MISMATCH   = "Directory holds a different repository than configured: "
+	GIT_MESSAGE_DRIFT      = "Worktree drifted from the pinned ref, resetting: "
+
+	TEMPLATE_NAME = "AGENT_TEMPLATE_NAME"
+	TEMPLATE_DEST = "AGENT_TEMPLATE_DEST"
+
+	MOUNT_CONFIG         = "config"
+	MOUNT_RESTIC         = "restic"
+	MOUNT_GOCRYPT        = "gocrypt"
+	MOUNT_GIT            = "git"
+	MOUNT_TEMPLATE       = "template"
+	MOUNT_STATUS         = "agent-status"
+	KV_VERSION_SEPARATOR = "@"
+
+	AUTH_APPROLE    = "approle"
+	AUTH_TOKEN_FILE = "token_file"
+	AUTH_CERT       = "cert"
+	AUTH_USERPASS   = "userpass"
 
 	// Store Constants
-	STORE_TOKEN       = "token"
-	STORE_TIMESTAMP   = "timestamp"
-	STORE_LAST_BACKUP = "last_backup"
-	STORE_KEY         = "vault-key-"
-
-	STORE_ERROR_NOT_DROPED = "Error keys were not dropped."
-
-	MAIN_PATHDB          = "pathdb"
-	MAIN_ADDRESS         = "address"
-	MAIN_TIME_DURATION   = "duration"
-	MAIN_MOUNT_DURATION  = "mount_duration"
-	MAIN_MOUNT_ALLOW     = "mount_allow"
-	MAIN_VAULT_KEY_FILE  = "vault_key_file"
-	MAIN_VAULT_ADDRESS   = "vault_address"
-	MAIN_VAULT_SECRET_ID = "vault_secret_id"
-	MAIN_VAULT_ROLE_ID   = "vault_role_id"
-	MAIN_BACKUP				   = "do_backup"
+	STORE_TOKEN            = "token"
+	STORE_SECRET_ID        = "approle-secret-id"
+	STORE_WRAPPING_TOKEN   = "approle-wrapping-token"
+	STORE_TIMESTAMP        = "timestamp"
+	STORE_LAST_BACKUP      = "last_backup"
+	STORE_BACKUP_RESULT    = "last_backup_result"
+	STORE_SEAL_CHECK       = "last_seal_check"
+	STORE_KEY              = "vault-key-"
+	STORE_FSCK             = "fsck-"
+	STORE_LAST_FSCK        = "last_fsck"
+	STORE_EVENT            = "event-"
+	STORE_GIT_UPDATE       = "git-last-update-"
+	STORE_GIT_COMMITS      = "git-commits-"
+	STORE_GIT_HOOKED       = "git-hooked-"
+	STORE_TEMPLATE_PENDING = "template-pending-"
+
+	STORE_ERROR_NOT_DROPED  = "Error keys were not dropped."
+	STORE_ERROR_KEY         = "Error decoding the database key, it has to be hex encoded: "
+	STORE_ERROR_KEY_LENGTH  = "Error the database key has to be 16, 24 or 32 bytes long"
+	STORE_ERROR_NO_KEY      = "Error no database key is configured"
+	STORE_ERROR_MIGRATE     = "Error encrypting the plaintext database: "
+	STORE_MESSAGE_MIGRATE   = "Encrypting the plaintext database at: "
+	STORE_MESSAGE_RECOVER   = "Finishing the interrupted encryption of the database at: "
+	STORE_MESSAGE_PLAINTEXT = "No database key is configured, the database is not encrypted"
+
+	STORE_SALT_SUFFIX      = ".salt"
+	STORE_MIGRATE_SUFFIX   = ".encrypted"
+	STORE_PLAINTEXT_SUFFIX = ".plaintext"
+	STORE_ROTATE_SUFFIX    = ".new"
+	STORE_SALT_LENGTH      = 16
+	STORE_KEY_LENGTH       = 32
+	STORE_SCRYPT_N         = 32768
+	STORE_SCRYPT_R         = 8
+	STORE_SCRYPT_P         = 1
+	STORE_INDEX_CACHE_SIZE = 16 << 20
+
+	MAIN_PATHDB                  = "pathdb"
+	MAIN_ADDRESS                 = "address"
+	MAIN_TIME_DURATION           = "duration"
+	MAIN_MOUNT_DURATION          = "mount_duration"
+	MAIN_MOUNT_ALLOW             = "mount_allow"
+	MAIN_FSCK_DURATION           = "fsck_duration"
+	MAIN_WATCHDOG_DURATION       = "watchdog_duration"
+	MAIN_VAULT_KEY_FILE          = "vault_key_file"
+	MAIN_VAULT_ADDRESS           = "vault_address"
+	MAIN_VAULT_SECRET_ID         = "vault_secret_id"
+	MAIN_VAULT_ROLE_ID           = "vault_role_id"
+	MAIN_VAULT_WRAPPED_SECRET_ID = "vault_wrapped_secret_id"
+	MAIN_VAULT_AUTH              = "vault_auth"
+	MAIN_VAULT_TOKEN_FILE        = "vault_token_file"
+	MAIN_VAULT_CERT_ROLE         = "vault_cert_role"
+	MAIN_VAULT_CLIENT_CERT       = "vault_client_cert"
+	MAIN_VAULT_CLIENT_KEY        = "vault_client_key"
+	MAIN_VAULT_CA_CERT           = "vault_ca_cert"
+	MAIN_VAULT_TLS_SERVER_NAME   = "vault_tls_server_name"
+	MAIN_VAULT_TLS_SKIP_VERIFY   = "vault_tls_skip_verify"
+	MAIN_VAULT_USERNAME          = "vault_username"
+	MAIN_VAULT_PASSWORD          = "vault_password"
+	MAIN_VAULT_MOUNT_CONFIG      = "vault_mount_config"
+	MAIN_VAULT_MOUNT_RESTIC      = "vault_mount_restic"
+	MAIN_VAULT_MOUNT_GOCRYPT     = "vault_mount_gocrypt"
+	MAIN_VAULT_MOUNT_GIT         = "vault_mount_git"
+	MAIN_VAULT_MOUNT_TEMPLATE    = "vault_mount_template"
+	MAIN_VAULT_MOUNT_STATUS      = "vault_mount_status"
+	MAIN_COMMAND_FLEET           = "fleet"
+	MAIN_COMMAND_ROTATE_KEY      = "rotate-db-key"
+	MAIN_DB_KEY_FILE             = "db_key_file"
+	MAIN_DB_KEY                  = "db_key"
+	MAIN_DB_PASSPHRASE           = "db_passphrase"
+	MAIN_DB_NEW_KEY_FILE         = "db_new_key_file"
+	MAIN_DB_NEW_KEY              = "db_new_key"
+	MAIN_DB_NEW_PASSPHRASE       = "db_new_passphrase"
+	MAIN_BACKUP                  = "do_backup"
 
 	MAIN_MESSAGE_NOT_ENOUGH_KEYS  = "Not enough vault keys in storage"
 	MAIN_MESSAGE_START_UNSEAL     = "Starting to unseal Vault"
@@ -43,14 +154,71 @@ const (
 	MAIN_POST_GIT_ENDPOINT    = "/git"
 	MAIN_POST_DATA_TYPE       = "application/json"
 
-	MAIN_ERROR_CHECK_SEAL = "Error while checking seal: "
-	MAIN_ERROR_UNSEAL     = "Error while unsealing vault: "
-	MAIN_ERROR_SHUTDOWN   = "Error shutting down: "
-	MAIN_ERROR_IS_DIR     = "Error provided path is a directory"
-	MAIN_ERROR_LOGIN      = "Error Role and Secret ID are required"
+	MAIN_ERROR_CHECK_SEAL        = "Error while checking seal: "
+	MAIN_ERROR_UNSEAL            = "Error while unsealing vault: "
+	MAIN_ERROR_SHUTDOWN          = "Error shutting down: "
+	MAIN_ERROR_IS_DIR            = "Error provided path is a directory"
+	MAIN_ERROR_TLS               = "Error configuring Vault TLS: "
+	MAIN_MESSAGE_TLS_SKIP_VERIFY = "Vault TLS certificate verification is disabled"
+	MAIN_ERROR_LOGIN             = "Error the Vault auth method is missing credentials"
+	MAIN_ERROR_FLEET_WRAPPED     = "Error the fleet command can not use a wrapped secret ID, it would be unwrapped a second time"
+	MAIN_MESSAGE_STATUS_DISABLED = "Status reporting is disabled"
+	MAIN_MESSAGE_ROTATED         = "Database key rotated for: "
+	MAIN_ERROR_DB_KEY            = "Error loading the database key: "
+	MAIN_ERROR_DB                = "Error opening the database at: "
 
 	HOME = "~"
 
+	// Fsck Constants
+	FSCK_EXIT_CORRUPT    = 26
+	FSCK_STATUS_OK       = "ok"
+	FSCK_STATUS_CORRUPT  = "corrupt"
+	FSCK_STATUS_FAILED   = "failed"
+	FSCK_STATUS_SKIPPED  = "skipped"
+	FSCK_MESSAGE_MOUNTED = "Volume is mounted read-write, skipping fsck"
+	FSCK_MESSAGE_TYPE    = "No integrity check available for: "
+	FSCK_MESSAGE_CORRUPT = "Integrity check found corruption in: "
+
+	// Status Constants
+	STATUS_SUCCESS       = "success"
+	STATUS_FAILED        = "failed"
+	STATUS_UNKNOWN       = "unknown"
+	STATUS_NEVER         = "never"
+	STATUS_SEALED        = "sealed"
+	STATUS_UNSEALED      = "unsealed"
+	ERROR_STATUS_READ    = "Error reading status of host: "
+	ERROR_STATUS_PUBLISH = "Error publishing agent status: "
+
+	// Mounter Constants
+	MOUNTER_GOCRYPTFS        = "gocryptfs"
+	MOUNTER_CRYFS            = "cryfs"
+	MOUNTER_SECUREFS         = "securefs"
+	CRYFS_FRONTEND           = "CRYFS_FRONTEND=noninteractive"
+	CRYFS_CONFIG             = "cryfs.config"
+	SECUREFS_CONFIG          = ".securefs.json"
+	GOCRYPTFS_CONFIG         = "gocryptfs.conf"
+	GOCRYPTFS_REVERSE_CONFIG = ".gocryptfs.reverse.conf"
+
+	// Watchdog Constants
+	WATCHDOG_MAX_REMOUNTS           = 3
+	WATCHDOG_HEALTHY_CHECKS         = 5
+	WATCHDOG_MESSAGE_DISABLED       = "Mount watchdog is disabled"
+	WATCHDOG_MESSAGE_STALE          = "Found stale mount: "
+	WATCHDOG_MESSAGE_REMOUNTED      = "Remounted stale mount: "
+	WATCHDOG_MESSAGE_REMOUNT_FAILED = "Remount failed for: "
+	WATCHDOG_MESSAGE_GIVE_UP        = "Giving up remounting after too many attempts: "
+
+	// Event Constants
+	EVENT_LEVEL_ERROR     = "error"
+	EVENT_LEVEL_WARN      = "warn"
+	EVENT_LEVEL_INFO      = "info"
+	EVENT_SOURCE_FSCK     = "fsck"
+	EVENT_SOURCE_WATCHDOG = "watchdog"
+	EVENT_SOURCE_GIT      = "git"
+	EVENT_SOURCE_SECURITY = "security"
+	EVENT_TTL             = 30 * 24 * time.Hour
+	EVENT_LIMIT           = 20
+
 	ERROR_DATABASE_NOT_FOUND = "Database is not initialized"
 	ERROR_DATABASE_CLOSED    = "Database is closed"
 	ERROR_DATABASE_PANIC     = "Database was thrown in panic"
@@ -60,6 +228,7 @@ const (
 	ERROR_MODE              = "Backup Mode:"
 	ERROR_GIT               = "GIT Mode:"
 	ERROR_STATUS            = "GetStatus:"
+	ERROR_GIT_STATUS        = "GetGitStatus:"
 	ERROR_ISSEALED          = "IsSealed:"
 	ERROR_UNSEAL            = "Unseal:"
 	ERROR_SEAL              = "Seal:"
@@ -68,19 +237,80 @@ const (
 	ERROR_CONFIG            = "GetConfigFromVault:"
 	ERROR_BINDING           = "BindJSON:"
 	ERROR_PUT_TOKEN         = "PutToken:"
+	ERROR_DROP_TOKEN        = "DropToken:"
 	ERROR_PUT_SEAL_KEY      = "PutSealKey:"
 	REST_JSON_MESSAGE       = "message"
+	REST_JSON_JOBS          = "jobs"
+	REST_JSON_FSCK          = "fsck"
+	REST_JSON_EVENTS        = "events"
+	REST_JSON_GIT           = "git"
+	REST_JSON_TOKEN         = "token"
 	REST_VAULT_SEAL_MESSAGE = "Vault seal is: "
 
-	ERROR_VAULT_SEALED         = "Vault is sealed."
-	ERROR_VAULT_NOT_SEALED     = "Vault is not sealed."
-	ERROR_VAULT_NO_SECRET      = "Vault has no data for this endpoint."
-	ERROR_VAULT_CONFIG_MISSING = "Vault config is missing"
+	ERROR_VAULT_SEALED          = "Vault is sealed."
+	ERROR_VAULT_NOT_SEALED      = "Vault is not sealed."
+	ERROR_VAULT_NO_SECRET       = "Vault has no data for this endpoint."
+	ERROR_VAULT_CONFIG_MISSING  = "Vault config is missing"
+	ERROR_VAULT_LOGIN           = "Vault login failed"
+	ERROR_KV_MOUNT              = "Vault has no KV mount: "
+	ERROR_KV_VERSION            = "Secret version has to be a positive number: "
+	ERROR_KV_VERSION_V1         = "KV version 1 has no secret versions: "
+	ERROR_VAULT_NO_AUTH         = "Vault response has no auth information"
+	ERROR_VAULT_AUTH            = "Not supported Vault auth method: "
+	ERROR_VAULT_AUTH_MISSING    = "Vault auth method needs: "
+	ERROR_VAULT_TOKEN_FILE      = "Vault token file is empty: "
+	ERROR_VAULT_WRAPPING_USED   = "Wrapping token was already used or is invalid: "
+	ERROR_PUT_SECRET_ID         = "Error storing unwrapped secret ID: "
+	ERROR_VAULT_WRAPPING_KEY    = "A wrapped secret ID needs an encrypted database to keep the secret ID"
+	VAULT_MESSAGE_WRAPPING_USED = "Wrapping token for the secret ID was already used or is invalid, it may have been intercepted"
+	ERROR_TOKEN_RENEW           = "Token renewal failed, logging in again: "
+	ERROR_TOKEN_REVOKE          = "Token revocation failed: "
+	MESSAGE_TOKEN_RENEWED       = "Renewed Vault token, TTL: "
+	MESSAGE_TOKEN_LOGIN         = "Logged into Vault, TTL: "
+	MESSAGE_TOKEN_DENIED        = "Vault denied the token, logging in again with the next request"
 
 	ERROR_UNMARSHAL        = "Error marshaling message: "
 	ERROR_SENDING_REQUEST  = "Error sending request: "
 	ERROR_READING_RESPONSE = "Error reading response: "
 	ERROR_TIMESTAMP        = "Error retrieving timestamp: "
+	ERROR_EVENT            = "Error storing event: "
+	ERROR_FSCK             = "Error storing fsck result: "
+	ERROR_GIT_UPDATE       = "Error storing git update time: "
+	ERROR_WATCHDOG_UNMOUNT = "Error unmounting stale mount: "
+	ERROR_WATCHDOG_STATUS  = "Error checking mount: "
+	ERROR_WATCHDOG_LOGIN   = "Login failed, can not fetch the password for remounting"
+	ERROR_PASSWORD_PIPE    = "Error creating password pipe: "
+	ERROR_GIT_KNOWN_HOSTS  = "SSH needs known_hosts entries for strict host key checking: "
+	ERROR_GIT_STRATEGY     = "Not supported pull strategy: "
+	ERROR_GIT_DETACHED     = "HEAD is not a branch, can not sync"
+	ERROR_GIT_DIVERGED     = "Local and remote history diverged, resolve by hand: "
+	ERROR_GIT_CONFLICT     = "Local and remote changed the same files, resolve by hand: "
+	ERROR_GIT_REBASE       = "Local history does not reach the merge base, can not rebase: "
+	ERROR_GIT_MODE         = "Not supported Mode: "
+	ERROR_GIT_MIRROR_BARE  = "A mirror needs a bare repository: "
+	ERROR_GIT_DIRTY_POLICY = "Not supported dirty worktree policy: "
+	ERROR_GIT_VERIFY       = "Not supported signature verification: "
+	ERROR_GIT_TRUSTED_KEYS = "Signature verification needs trusted_keys: "
+	ERROR_GIT_SIGNATURE    = "Commit is not signed by a trusted key: "
+	ERROR_GIT_MISMATCH     = "Configured repository does not match the checkout: "
+	ERROR_GIT_HOOK         = "Git hook failed: "
+	ERROR_GIT_REF          = "Only one of tag and commit can be pinned: "
+	ERROR_GIT_COMMIT       = "Pinned commit has to be a full commit id: "
+	ERROR_GIT_PINNED       = "Sync is not possible for a pinned tag or commit: "
+	ERROR_GIT_REMOTE_AHEAD = "Remote is ahead and pull strategy is none: "
+	ERROR_TEMPLATE_CONFIG  = "Template needs source and dest: "
+	ERROR_TEMPLATE_KEY     = "Secret has no such key: "
+	ERROR_TEMPLATE_MODE    = "Template mode has to be octal: "
+	ERROR_TEMPLATE_OWNER   = "Template owner not found: "
+	ERROR_TEMPLATE         = "Rendering templates failed"
+	ERROR_TEMPLATE_COMMAND = "Template command failed: "
+	ERROR_TEMPLATE_PENDING = "Error keeping the pending template command: "
+	ERROR_MOUNTER          = "Not supported filesystem type: "
+	ERROR_INIT             = "Error initializing volume: "
+	ERROR_REVERSE_TYPE     = "Reverse mode is only supported for gocryptfs: "
+	ERROR_NOT_REVERSE      = "Gocrypt config is not a reverse volume: "
+	ERROR_REVERSE_MOUNT    = "Error mounting reverse volume: "
+	ERROR_REVERSE_UNMOUNT  = "Error unmounting reverse volume: "
 
 	BACKUP_TEST_FOLDER       = "~/test/Backup"
 	BACKUP_TEST_EXCLUDE_FILE = "~/test/exclude\n~/*.go"
@@ -91,6 +321,13 @@ const (
 	GOCRYPT_TEST_FILE      = "/test"
 	GOCRYPT_TEST_FOLDER    = "~/test/tmp"
 
+	GOCRYPT_TEST_REVERSE_FOLDER    = "~/test/plain"
+	GOCRYPT_TEST_REVERSE_MOUNTPATH = "~/test/plain-mount"
+
+	TEMPLATE_TEST_FOLDER = "~/test/template"
+	TEMPLATE_TEST_SOURCE = "~/test/template/app.tmpl"
+	TEMPLATE_TEST_DEST   = "~/test/template/app.conf"
+
 	GIT_TEST_FOLDER       = "~/test/reverse"
 	GIT_TEST_REPO         = "https://github.com/azak-azkaran/reverse-link"
 	GIT_TEST_FOLDER_VIMRC = "~/test/vimrc"
@@ -99,6 +336,12 @@ const (
 
 	VAULT_TEST_PASSWORD            = "hallo"
 	VAULT_TEST_TOKEN               = "superrandompasswordtoken"
+	VAULT_TEST_OLD_PASSWORD        = "oldpassword"
+	VAULT_TEST_AGENT_TOKEN         = "tokenfromvaultagent"
+	VAULT_TEST_WRAPPING_TOKEN      = "wrappingtoken"
+	VAULT_TEST_CERT_ROLE           = "agent-cert"
+	VAULT_TEST_TLS_SERVER_NAME     = "vault.internal"
+	VAULT_TEST_USERNAME            = "agent-user"
 	VAULT_TEST_PATH                = "~/test/tmp"
 	VAULT_TEST_MOUNTPATH           = "~/test/tmp-mount"
 	VAULT_TEST_CONFIGPATH          = "gocryptpath"
@@ -116,12 +359,17 @@ const (
 	MAIN_TEST_MOUNT_ALLOW    = "false"
 	MAIN_TEST_KEYFILE_PATH   = "./test/keyfile"
 
+	STORE_TEST_KEY        = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+	STORE_TEST_NEW_KEY    = "1f1e1d1c1b1a191817161514131211100f0e0d0c0b0a09080706050403020100"
+	STORE_TEST_PASSPHRASE = "correct horse battery staple"
+
 	REST_TEST_TOKEN      = "http://localhost:8031/token"
 	REST_TEST_LOG        = "http://localhost:8031/logs"
 	REST_TEST_BACKUP     = "http://localhost:8031/backup"
 	REST_TEST_STATUS     = "http://localhost:8031/status"
 	REST_TEST_MOUNT      = "http://localhost:8031/mount"
 	REST_TEST_GIT        = "http://localhost:8031/git"
+	REST_TEST_GIT_STATUS = "http://localhost:8031/git/status"
 	REST_TEST_UNSEAL     = "http://localhost:8031/unseal"
 	REST_TEST_IS_SEALED  = "http://localhost:8031/is_sealed"
 	REST_TEST_SEAL       = "http://localhost:8031/seal"
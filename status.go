@@ -0,0 +1,248 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v3"
+	vault "github.com/hashicorp/vault/api"
+)
+
+// Version is set at build time with -ldflags "-X main.Version=..."
+var Version = "dev"
+
+type MountReport struct {
+	Name     string `json:"name"`
+	Mounted  bool   `json:"mounted"`
+	ReadOnly bool   `json:"read_only"`
+	Stale    bool   `json:"stale"`
+	Error    string `json:"error,omitempty"`
+}
+
+type GitReport struct {
+	Name       string    `json:"name"`
+	Branch     string    `json:"branch"`
+	Head       string    `json:"head"`
+	Ahead      int       `json:"ahead"`
+	Behind     int       `json:"behind"`
+	Dirty      int       `json:"dirty"`
+	LastUpdate time.Time `json:"last_update"`
+	Error      string    `json:"error,omitempty"`
+}
+
+type SealCheck struct {
+	Time   time.Time `json:"time"`
+	Sealed bool      `json:"sealed"`
+	Error  string    `json:"error,omitempty"`
+}
+
+// AgentStatus is the document every agent publishes about itself, it is kept
+// small so the fleet can be read in one go
+type AgentStatus struct {
+	Hostname     string        `json:"hostname"`
+	Version      string        `json:"version"`
+	Reported     time.Time     `json:"reported"`
+	LastBackup   time.Time     `json:"last_backup"`
+	BackupResult string        `json:"backup_result"`
+	Mounts       []MountReport `json:"mounts"`
+	Git          []GitReport   `json:"git"`
+	Seal         SealCheck     `json:"seal"`
+	Error        string        `json:"error,omitempty"`
+}
+
+func UpdateBackupResult(db *badger.DB, err error) (bool, error) {
+	if err != nil {
+		return Put(db, STORE_BACKUP_RESULT, err.Error())
+	}
+	return Put(db, STORE_BACKUP_RESULT, STATUS_SUCCESS)
+}
+
+func UpdateSealCheck(db *badger.DB, seal *vault.SealStatusResponse, err error) (bool, error) {
+	check := SealCheck{Time: time.Now()}
+	if err != nil {
+		check.Error = err.Error()
+	} else {
+		check.Sealed = seal.Sealed
+	}
+	value, err := json.Marshal(check)
+	if err != nil {
+		return false, err
+	}
+	return Put(db, STORE_SEAL_CHECK, string(value))
+}
+
+func getSealCheck(db *badger.DB) SealCheck {
+	var check SealCheck
+	value, err := Get(db, STORE_SEAL_CHECK)
+	if err != nil {
+		return check
+	}
+	err = json.Unmarshal([]byte(value), &check)
+	if err != nil {
+		Sugar.Error(err)
+	}
+	return check
+}
+
+// CollectStatus only uses what the agent already knows, the git states are
+// the exception and need the configuration from vault
+func CollectStatus(db *badger.DB, hostname string, git []GitStatus) AgentStatus {
+	status := AgentStatus{
+		Hostname: hostname,
+		Version:  Version,
+		Reported: time.Now(),
+		Mounts:   []MountReport{},
+		Git:      []GitReport{},
+		Seal:     getSealCheck(db),
+	}
+
+	last, err := GetLastBackup(db)
+	if err == nil {
+		status.LastBackup = last
+	}
+	status.BackupResult, _ = Get(db, STORE_BACKUP_RESULT)
+
+	if mountmap != nil {
+		for item := range mountmap.IterBuffered() {
+			conf, home := item.Val.(*ManagedMount).get()
+			report := MountReport{Name: item.Key}
+			mounter, err := GetMounter(conf.Type)
+			if err == nil {
+				var mount MountStatus
+				mount, err = mounter.Status(home, conf)
+				report.Mounted = mount.Mounted
+				report.ReadOnly = mount.ReadOnly
+				report.Stale = mount.Stale
+			}
+			if err != nil {
+				report.Error = err.Error()
+			}
+			status.Mounts = append(status.Mounts, report)
+		}
+		sort.Slice(status.Mounts, func(i, j int) bool {
+			return status.Mounts[i].Name < status.Mounts[j].Name
+		})
+	}
+
+	for _, v := range git {
+		status.Git = append(status.Git, GitReport{
+			Name:       v.Name,
+			Branch:     v.Branch,
+			Head:       v.Head,
+			Ahead:      v.Ahead,
+			Behind:     v.Behind,
+			Dirty:      len(v.Dirty),
+			LastUpdate: v.LastUpdate,
+			Error:      v.Error,
+		})
+	}
+	return status
+}
+
+func PublishStatus(config *vault.Config, token string, mount string, status AgentStatus) error {
+	value, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+	var data map[string]interface{}
+	err = json.Unmarshal(value, &data)
+	if err != nil {
+		return err
+	}
+	return WriteKV(config, token, mount, status.Hostname, data)
+}
+
+func ReadFleet(config *vault.Config, token string, mount string) ([]AgentStatus, error) {
+	hosts, err := ListKV(config, token, mount)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(hosts)
+
+	// a host which can not be read is a row with the error, not a missing one
+	fleet := []AgentStatus{}
+	for _, host := range hosts {
+		status, err := readHostStatus(config, token, mount, host)
+		if err != nil {
+			status = AgentStatus{Hostname: host, Error: ERROR_STATUS_READ + host + " " + err.Error()}
+		}
+		fleet = append(fleet, status)
+	}
+	return fleet, nil
+}
+
+func readHostStatus(config *vault.Config, token string, mount string, host string) (AgentStatus, error) {
+	var status AgentStatus
+	data, err := ReadKV(config, token, mount, host)
+	if err != nil {
+		return status, err
+	}
+	value, err := json.Marshal(data)
+	if err != nil {
+		return status, err
+	}
+	err = json.Unmarshal(value, &status)
+	return status, err
+}
+
+func PrintFleet(out io.Writer, fleet []AgentStatus) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "HOST\tVERSION\tREPORTED\tLAST BACKUP\tRESULT\tMOUNTS\tSTALE\tGIT\tSEAL\tERROR")
+	for _, s := range fleet {
+		mounted, stale := 0, 0
+		for _, m := range s.Mounts {
+			if m.Mounted {
+				mounted++
+			}
+			if m.Stale {
+				stale++
+			}
+		}
+		gitOk := 0
+		for _, g := range s.Git {
+			if g.Error == "" {
+				gitOk++
+			}
+		}
+		fmt.Fprintln(w, s.Hostname+"\t"+s.Version+"\t"+
+			fleetTime(s.Reported)+"\t"+fleetTime(s.LastBackup)+"\t"+fleetResult(s.BackupResult)+"\t"+
+			strconv.Itoa(mounted)+"/"+strconv.Itoa(len(s.Mounts))+"\t"+strconv.Itoa(stale)+"\t"+
+			strconv.Itoa(gitOk)+"/"+strconv.Itoa(len(s.Git))+"\t"+fleetSeal(s.Seal)+"\t"+s.Error)
+	}
+	return w.Flush()
+}
+
+func fleetTime(t time.Time) string {
+	if t.IsZero() {
+		return STATUS_NEVER
+	}
+	return t.Local().Format(time.RFC3339)
+}
+
+// the error itself stays in the document, the table only flags it
+func fleetResult(value string) string {
+	switch value {
+	case "":
+		return STATUS_UNKNOWN
+	case STATUS_SUCCESS:
+		return value
+	default:
+		return STATUS_FAILED
+	}
+}
+
+func fleetSeal(check SealCheck) string {
+	switch {
+	case check.Time.IsZero() || check.Error != "":
+		return STATUS_UNKNOWN
+	case check.Sealed:
+		return STATUS_SEALED
+	default:
+		return STATUS_UNSEALED
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	vault "github.com/hashicorp/vault/api"
+	cmap "github.com/orcaman/concurrent-map"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatusPublishStatus(t *testing.T) {
+	fmt.Println("running: TestStatusPublishStatus")
+	t.Cleanup(clear)
+	t.Cleanup(func() { statusDocs = make(map[string]interface{}) })
+	testconfig := readConfig(t)
+	db := InitDB("", "", true)
+	defer db.Close()
+	pwd, err := os.Getwd()
+	require.NoError(t, err)
+
+	// nothing happened yet
+	status := CollectStatus(db, "emptyhost", nil)
+	assert.True(t, status.LastBackup.IsZero())
+	assert.Equal(t, "", status.BackupResult)
+	assert.True(t, status.Seal.Time.IsZero())
+
+	last := time.Now().Add(-time.Hour)
+	_, err = UpdateLastBackup(db, last)
+	require.NoError(t, err)
+	_, err = UpdateBackupResult(db, nil)
+	require.NoError(t, err)
+	_, err = UpdateSealCheck(db, &vault.SealStatusResponse{Sealed: true}, nil)
+	require.NoError(t, err)
+	mountmap = cmap.New()
+	RegisterMount(pwd, GocryptConfig{Name: "notmounted", MountPoint: "~/test/not-existing"})
+	git := []GitStatus{{Name: "vimrc", Branch: "master", Head: "abc", Dirty: []string{"vimrc"}}}
+
+	status = CollectStatus(db, Hostname, git)
+	assert.Equal(t, Version, status.Version)
+	assert.Equal(t, STATUS_SUCCESS, status.BackupResult)
+	assert.True(t, status.Seal.Sealed)
+	require.Len(t, status.Mounts, 1)
+	assert.Equal(t, "notmounted", status.Mounts[0].Name)
+	assert.False(t, status.Mounts[0].Mounted)
+	require.Len(t, status.Git, 1)
+	assert.Equal(t, 1, status.Git[0].Dirty)
+
+	err = PublishStatus(testconfig.config, testconfig.token, MOUNT_STATUS, status)
+	require.NoError(t, err)
+	_, err = UpdateBackupResult(db, fmt.Errorf("repository locked"))
+	require.NoError(t, err)
+	err = PublishStatus(testconfig.config, testconfig.token, MOUNT_STATUS, CollectStatus(db, "otherhost", nil))
+	require.NoError(t, err)
+
+	fleet, err := ReadFleet(testconfig.config, testconfig.token, MOUNT_STATUS)
+	require.NoError(t, err)
+	require.Len(t, fleet, 2)
+	var own AgentStatus
+	for _, s := range fleet {
+		if s.Hostname == Hostname {
+			own = s
+		}
+	}
+	assert.Equal(t, Version, own.Version)
+	assert.WithinDuration(t, last, own.LastBackup, time.Millisecond)
+	assert.Equal(t, status.Mounts, own.Mounts)
+	assert.Equal(t, "abc", own.Git[0].Head)
+	assert.True(t, own.Seal.Sealed)
+
+	var buffer bytes.Buffer
+	require.NoError(t, PrintFleet(&buffer, fleet))
+	table := buffer.String()
+	assert.Contains(t, table, "LAST BACKUP")
+	assert.Contains(t, table, Hostname)
+	assert.Contains(t, table, STATUS_SUCCESS)
+	assert.Contains(t, table, STATUS_FAILED)
+	assert.Contains(t, table, STATUS_SEALED)
+	assert.Contains(t, table, "0/1")
+}
+
+func TestStatusFleet(t *testing.T) {
+	fmt.Println("running: TestStatusFleet")
+	t.Cleanup(clear)
+	t.Cleanup(func() { statusDocs = make(map[string]interface{}) })
+	testconfig := readConfig(t)
+
+	err := PublishStatus(testconfig.config, testconfig.token, MOUNT_STATUS, AgentStatus{Hostname: "fleethost", Version: "v1.2.3"})
+	require.NoError(t, err)
+
+	var buffer bytes.Buffer
+	args := []string{
+		MAIN_COMMAND_FLEET,
+		"--vault_address=" + testconfig.config.Address,
+		"--vault_role_id=" + VAULT_TEST_ROLE_ID,
+		"--vault_secret_id=" + VAULT_TEST_SECRET_ID,
+	}
+	revokes := revokeCount
+	err = Fleet(&buffer, args)
+	require.NoError(t, err)
+	assert.Contains(t, buffer.String(), "fleethost")
+	assert.Contains(t, buffer.String(), "v1.2.3")
+	assert.Contains(t, buffer.String(), STATUS_NEVER)
+	assert.Equal(t, revokes+1, revokeCount)
+
+	// a broken document is a row with its error
+	statusDocs["brokenhost"] = map[string]interface{}{"version": 3}
+	buffer.Reset()
+	err = Fleet(&buffer, args)
+	require.NoError(t, err)
+	assert.Contains(t, buffer.String(), "fleethost")
+	assert.Contains(t, buffer.String(), ERROR_STATUS_READ+"brokenhost")
+
+	// a wrapped secret ID belongs to the agent
+	args = []string{
+		MAIN_COMMAND_FLEET,
+		"--vault_address=" + testconfig.config.Address,
+		"--vault_role_id=" + VAULT_TEST_ROLE_ID,
+		"--vault_secret_id=",
+		"--vault_wrapped_secret_id=wrapping",
+		"--" + MAIN_DB_KEY + "=" + STORE_TEST_KEY,
+	}
+	err = Fleet(&buffer, args)
+	assert.Error(t, err)
+	assert.Equal(t, MAIN_ERROR_FLEET_WRAPPED, err.Error())
+}
@@ -43,12 +43,20 @@ func InitDB(path string, masterkey string, debug bool) *badger.DB {
 	}
 
 	if masterkey != "" {
-		opt.WithEncryptionKey([]byte(masterkey))
+		if !debug {
+			err := MigrateDB(path, []byte(masterkey))
+			if err != nil {
+				Sugar.Error(STORE_ERROR_MIGRATE, err)
+			}
+		}
+		opt = opt.WithEncryptionKey([]byte(masterkey)).WithIndexCacheSize(STORE_INDEX_CACHE_SIZE)
 	}
 
+	// badger hands back a half opened database on a wrong key
 	db, err := badger.Open(opt)
 	if err != nil {
 		Sugar.Error("Error opening database: ", err)
+		return nil
 	}
 	closed = false
 	return db
@@ -101,6 +109,55 @@ func Put(db *badger.DB, key string, value string) (bool, error) {
 	return ok, err
 }
 
+func PutWithTTL(db *badger.DB, key string, value string, ttl time.Duration) (bool, error) {
+	if db == nil {
+		return false, errors.New(ERROR_DATABASE_NOT_FOUND)
+	}
+	if closed {
+		return false, errors.New(ERROR_DATABASE_CLOSED)
+	}
+	var ok bool
+	err := db.Update(func(txn *badger.Txn) error {
+		e := badger.NewEntry([]byte(key), []byte(value)).WithTTL(ttl)
+		err := txn.SetEntry(e)
+		if err != nil {
+			return err
+		}
+		ok = true
+		return nil
+	})
+
+	return ok, err
+}
+
+func GetPrefix(db *badger.DB, prefix string) (map[string]string, error) {
+	if db == nil {
+		return nil, errors.New(ERROR_DATABASE_NOT_FOUND)
+	}
+	if closed {
+		return nil, errors.New(ERROR_DATABASE_CLOSED)
+	}
+	values := make(map[string]string)
+	err := db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		p := []byte(prefix)
+		for it.Seek(p); it.ValidForPrefix(p); it.Next() {
+			item := it.Item()
+			valCopy, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			values[string(item.KeyCopy(nil))] = string(valCopy)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
 func Remove(db *badger.DB, key string) ( error) {
 	if db == nil {
 		return  errors.New(ERROR_DATABASE_NOT_FOUND)
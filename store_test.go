@@ -45,11 +45,29 @@ func TestStoreInitDB(t *testing.T) {
 	assert.NoError(t, err)
 
 	fmt.Println("Testing with Masterkey")
-	db = InitDB("./test/DB", "test", false)
+	key, err := decodeDBKey(STORE_TEST_KEY)
+	require.NoError(t, err)
+	db = InitDB("./test/DB", string(key), false)
 	require.NotNil(t, db)
 
+	// the plaintext database was migrated
+	value, err := Get(db, "answer")
+	assert.NoError(t, err)
+	assert.Equal(t, "42", value)
+	assert.NoDirExists(t, "./test/DB"+STORE_PLAINTEXT_SUFFIX)
+	assert.NoDirExists(t, "./test/DB"+STORE_MIGRATE_SUFFIX)
+
 	err = db.Close()
 	assert.NoError(t, err)
+
+	plaintext, err := isPlaintextDB("./test/DB")
+	assert.NoError(t, err)
+	assert.False(t, plaintext)
+	db = InitDB("./test/DB", "", false)
+	assert.Nil(t, db)
+
+	err = RemoveContents("./test/DB/")
+	assert.NoError(t, err)
 }
 
 func TestStoreIntegration(t *testing.T) {
@@ -78,7 +96,9 @@ func TestStoreIntegration(t *testing.T) {
 	assert.NoError(t, err)
 
 	fmt.Println("Testing with Masterkey")
-	db = InitDB("./test/DB", "test", false)
+	key, err := decodeDBKey(STORE_TEST_KEY)
+	require.NoError(t, err)
+	db = InitDB("./test/DB", string(key), false)
 	require.NotNil(t, db)
 
 	ok, err = Put(db, "answer", "Blub")
@@ -263,3 +283,28 @@ func TestStoreRemove(t *testing.T) {
 	_, err = Get(db, "test")
 	assert.Error(t,err)
 }
+
+func TestStoreGetPrefix(t *testing.T) {
+	fmt.Println("running: TestStoreGetPrefix")
+	db := InitDB("", "", true)
+	require.NotNil(t, db)
+
+	ok, err := Put(db, "prefix-a", "a")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = PutWithTTL(db, "prefix-b", "b", time.Hour)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = Put(db, "other", "c")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	values, err := GetPrefix(db, "prefix-")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"prefix-a": "a", "prefix-b": "b"}, values)
+
+	err = db.Close()
+	assert.NoError(t, err)
+}
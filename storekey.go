@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bytes"
+	crypto_rand "crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	badger "github.com/dgraph-io/badger/v3"
+	"golang.org/x/crypto/scrypt"
+)
+
+// DBKeyConfig names where the key of the database comes from, the first one
+// set wins
+type DBKeyConfig struct {
+	KeyFile    string
+	Key        string
+	Passphrase string
+}
+
+func (conf DBKeyConfig) IsSet() bool {
+	return conf.KeyFile != "" || conf.Key != "" || conf.Passphrase != ""
+}
+
+// LoadDBKey returns nil if no key is configured. The salt of a passphrase is
+// kept next to the database since the database can not be read without it.
+func LoadDBKey(path string, conf DBKeyConfig) ([]byte, error) {
+	switch {
+	case conf.KeyFile != "":
+		content, err := ioutil.ReadFile(conf.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		return decodeDBKey(strings.TrimSpace(string(content)))
+	case conf.Key != "":
+		return decodeDBKey(conf.Key)
+	case conf.Passphrase != "":
+		salt, err := ioutil.ReadFile(saltPath(path))
+		if os.IsNotExist(err) {
+			salt, err = newSalt(saltPath(path))
+		}
+		if err != nil {
+			return nil, err
+		}
+		return deriveDBKey(conf.Passphrase, salt)
+	default:
+		return nil, nil
+	}
+}
+
+// keys are hex encoded, e.g. from openssl rand -hex 32
+func decodeDBKey(value string) ([]byte, error) {
+	key, err := hex.DecodeString(value)
+	if err != nil {
+		return nil, errors.New(STORE_ERROR_KEY + err.Error())
+	}
+	switch len(key) {
+	case 16, 24, 32:
+		return key, nil
+	default:
+		return nil, errors.New(STORE_ERROR_KEY_LENGTH)
+	}
+}
+
+func deriveDBKey(passphrase string, salt []byte) ([]byte, error) {
+	return scrypt.Key([]byte(passphrase), salt, STORE_SCRYPT_N, STORE_SCRYPT_R, STORE_SCRYPT_P, STORE_KEY_LENGTH)
+}
+
+func saltPath(path string) string {
+	return filepath.Clean(path) + STORE_SALT_SUFFIX
+}
+
+func newSalt(path string) ([]byte, error) {
+	salt := make([]byte, STORE_SALT_LENGTH)
+	_, err := crypto_rand.Read(salt)
+	if err != nil {
+		return nil, err
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return nil, err
+	}
+	return salt, ioutil.WriteFile(path, salt, 0600)
+}
+
+// a database written without a key has a registry which can be read without one
+func isPlaintextDB(path string) (bool, error) {
+	_, err := os.Stat(filepath.Join(path, badger.KeyRegistryFileName))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	_, err = badger.OpenKeyRegistry(badger.KeyRegistryOptions{Dir: path, ReadOnly: true})
+	if errors.Is(err, badger.ErrEncryptionKeyMismatch) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// MigrateDB copies a plaintext database into an encrypted one at the same
+// path. The plaintext copy is removed afterwards, it holds the unseal keys.
+func MigrateDB(path string, key []byte) error {
+	err := recoverMigration(path)
+	if err != nil {
+		return err
+	}
+	plaintext, err := isPlaintextDB(path)
+	if err != nil || !plaintext {
+		return err
+	}
+	Sugar.Warn(STORE_MESSAGE_MIGRATE, path)
+
+	src, err := badger.Open(badger.DefaultOptions(path).WithLogger(&defaultLog{}))
+	if err != nil {
+		return err
+	}
+	var buffer bytes.Buffer
+	_, err = src.Backup(&buffer, 0)
+	if closeErr := src.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	encrypted := filepath.Clean(path) + STORE_MIGRATE_SUFFIX
+	err = os.RemoveAll(encrypted)
+	if err != nil {
+		return err
+	}
+	dst, err := badger.Open(encryptedOptions(encrypted, key))
+	if err != nil {
+		return err
+	}
+	err = dst.Load(&buffer, 256)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.RemoveAll(encrypted)
+		return err
+	}
+
+	old := filepath.Clean(path) + STORE_PLAINTEXT_SUFFIX
+	err = os.Rename(path, old)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(encrypted, path)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(old)
+}
+
+// recoverMigration finishes a migration which stopped between the renames. A
+// plaintext copy set aside means the encrypted copy was complete, without it
+// the encrypted copy is unfinished and the plaintext database still in place.
+func recoverMigration(path string) error {
+	path = filepath.Clean(path)
+	old := path + STORE_PLAINTEXT_SUFFIX
+	encrypted := path + STORE_MIGRATE_SUFFIX
+
+	_, err := os.Stat(old)
+	if os.IsNotExist(err) {
+		if _, err := os.Stat(path); err == nil {
+			return os.RemoveAll(encrypted)
+		}
+		return nil
+	} else if err != nil {
+		return err
+	}
+	Sugar.Warn(STORE_MESSAGE_RECOVER, path)
+
+	_, err = os.Stat(path)
+	if os.IsNotExist(err) {
+		_, err = os.Stat(encrypted)
+		if os.IsNotExist(err) {
+			// nothing to finish, the plaintext database is migrated again
+			return os.Rename(old, path)
+		} else if err != nil {
+			return err
+		}
+		err = os.Rename(encrypted, path)
+	}
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(old)
+}
+
+// RotateDBKey re-encrypts the data keys of the database with a new master
+// key, the data itself stays untouched. The agent must not be running.
+func RotateDBKey(path string, current DBKeyConfig, next DBKeyConfig) error {
+	oldKey, err := LoadDBKey(path, current)
+	if err != nil {
+		return err
+	}
+	if oldKey == nil {
+		return errors.New(STORE_ERROR_NO_KEY)
+	}
+
+	// a new passphrase gets a new salt, which replaces the old one only
+	// after the registry was written
+	var salt []byte
+	var newKey []byte
+	if next.KeyFile == "" && next.Key == "" && next.Passphrase != "" {
+		salt, err = newSalt(saltPath(path) + STORE_ROTATE_SUFFIX)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(saltPath(path) + STORE_ROTATE_SUFFIX)
+		newKey, err = deriveDBKey(next.Passphrase, salt)
+	} else {
+		newKey, err = LoadDBKey(path, next)
+	}
+	if err != nil {
+		return err
+	}
+	if newKey == nil {
+		return errors.New(STORE_ERROR_NO_KEY)
+	}
+
+	opt := badger.KeyRegistryOptions{Dir: path, ReadOnly: true, EncryptionKey: oldKey}
+	registry, err := badger.OpenKeyRegistry(opt)
+	if err != nil {
+		return err
+	}
+	opt.ReadOnly = false
+	opt.EncryptionKey = newKey
+	err = badger.WriteKeyRegistry(registry, opt)
+	if err != nil {
+		return err
+	}
+
+	if salt != nil {
+		return os.Rename(saltPath(path)+STORE_ROTATE_SUFFIX, saltPath(path))
+	}
+	return nil
+}
+
+func encryptedOptions(path string, key []byte) badger.Options {
+	return badger.DefaultOptions(path).
+		WithLogger(&defaultLog{}).
+		WithEncryptionKey(key).
+		WithIndexCacheSize(STORE_INDEX_CACHE_SIZE)
+}
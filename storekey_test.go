@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	badger "github.com/dgraph-io/badger/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStoreKeyLoadDBKey(t *testing.T) {
+	fmt.Println("running: TestStoreKeyLoadDBKey")
+	dir, err := ioutil.TempDir("", "agent-db")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "db")
+
+	key, err := LoadDBKey(path, DBKeyConfig{})
+	assert.NoError(t, err)
+	assert.Nil(t, key)
+
+	key, err = LoadDBKey(path, DBKeyConfig{Key: STORE_TEST_KEY})
+	require.NoError(t, err)
+	assert.Len(t, key, 32)
+	_, err = LoadDBKey(path, DBKeyConfig{Key: "not hex"})
+	assert.Error(t, err)
+	_, err = LoadDBKey(path, DBKeyConfig{Key: "00010203"})
+	assert.Error(t, err)
+	assert.Equal(t, STORE_ERROR_KEY_LENGTH, err.Error())
+
+	// the key file wins over the other sources
+	keyFile := filepath.Join(dir, "key")
+	require.NoError(t, ioutil.WriteFile(keyFile, []byte(STORE_TEST_NEW_KEY+"\n"), 0600))
+	fromFile, err := LoadDBKey(path, DBKeyConfig{KeyFile: keyFile, Key: STORE_TEST_KEY})
+	require.NoError(t, err)
+	assert.NotEqual(t, key, fromFile)
+
+	// the salt is created once and reused
+	derived, err := LoadDBKey(path, DBKeyConfig{Passphrase: STORE_TEST_PASSPHRASE})
+	require.NoError(t, err)
+	assert.Len(t, derived, STORE_KEY_LENGTH)
+	assert.FileExists(t, path+STORE_SALT_SUFFIX)
+	again, err := LoadDBKey(path, DBKeyConfig{Passphrase: STORE_TEST_PASSPHRASE})
+	require.NoError(t, err)
+	assert.Equal(t, derived, again)
+	other, err := LoadDBKey(path, DBKeyConfig{Passphrase: "another passphrase"})
+	require.NoError(t, err)
+	assert.NotEqual(t, derived, other)
+}
+
+func TestStoreKeyRotateDBKey(t *testing.T) {
+	fmt.Println("running: TestStoreKeyRotateDBKey")
+	dir, err := ioutil.TempDir("", "agent-db")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "db")
+
+	current := DBKeyConfig{Key: STORE_TEST_KEY}
+	key, err := LoadDBKey(path, current)
+	require.NoError(t, err)
+	db := InitDB(path, string(key), false)
+	require.NotNil(t, db)
+	_, err = Put(db, "answer", "42")
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	err = RotateDBKey(path, DBKeyConfig{}, current)
+	assert.Error(t, err)
+	err = RotateDBKey(path, DBKeyConfig{Key: STORE_TEST_NEW_KEY}, DBKeyConfig{Passphrase: STORE_TEST_PASSPHRASE})
+	assert.Error(t, err)
+
+	next := DBKeyConfig{Passphrase: STORE_TEST_PASSPHRASE}
+	err = RotateDBKey(path, current, next)
+	require.NoError(t, err)
+	assert.FileExists(t, path+STORE_SALT_SUFFIX)
+	assert.NoFileExists(t, path+STORE_SALT_SUFFIX+STORE_ROTATE_SUFFIX)
+
+	db = InitDB(path, string(key), false)
+	assert.Nil(t, db)
+
+	key, err = LoadDBKey(path, next)
+	require.NoError(t, err)
+	db = InitDB(path, string(key), false)
+	require.NotNil(t, db)
+	value, err := Get(db, "answer")
+	assert.NoError(t, err)
+	assert.Equal(t, "42", value)
+	require.NoError(t, db.Close())
+
+	// the same passphrase gets a fresh salt and with it a new key
+	err = RotateDBKey(path, next, next)
+	require.NoError(t, err)
+	rotated, err := LoadDBKey(path, next)
+	require.NoError(t, err)
+	assert.NotEqual(t, key, rotated)
+	db = InitDB(path, string(rotated), false)
+	require.NotNil(t, db)
+	require.NoError(t, db.Close())
+}
+
+func TestStoreKeyRecoverMigration(t *testing.T) {
+	fmt.Println("running: TestStoreKeyRecoverMigration")
+	dir, err := ioutil.TempDir("", "agent-db")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "db")
+	key, err := decodeDBKey(STORE_TEST_KEY)
+	require.NoError(t, err)
+
+	// stopped between the renames, the encrypted copy is put in place
+	db, err := badger.Open(encryptedOptions(path+STORE_MIGRATE_SUFFIX, key))
+	require.NoError(t, err)
+	_, err = Put(db, "answer", "42")
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+	plain := InitDB(path+STORE_PLAINTEXT_SUFFIX, "", false)
+	require.NotNil(t, plain)
+	_, err = Put(plain, "answer", "plaintext")
+	require.NoError(t, err)
+	require.NoError(t, plain.Close())
+
+	db = InitDB(path, string(key), false)
+	require.NotNil(t, db)
+	value, err := Get(db, "answer")
+	assert.NoError(t, err)
+	assert.Equal(t, "42", value)
+	require.NoError(t, db.Close())
+	assert.NoDirExists(t, path+STORE_PLAINTEXT_SUFFIX)
+	assert.NoDirExists(t, path+STORE_MIGRATE_SUFFIX)
+
+	// stopped before the encrypted copy was finished, the plaintext
+	// database is migrated again
+	require.NoError(t, os.RemoveAll(path))
+	plain = InitDB(path+STORE_PLAINTEXT_SUFFIX, "", false)
+	require.NotNil(t, plain)
+	_, err = Put(plain, "answer", "again")
+	require.NoError(t, err)
+	require.NoError(t, plain.Close())
+
+	db = InitDB(path, string(key), false)
+	require.NotNil(t, db)
+	value, err = Get(db, "answer")
+	assert.NoError(t, err)
+	assert.Equal(t, "again", value)
+	require.NoError(t, db.Close())
+	assert.NoDirExists(t, path+STORE_PLAINTEXT_SUFFIX)
+	assert.NoDirExists(t, path+STORE_MIGRATE_SUFFIX)
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/template"
+
+	vault "github.com/hashicorp/vault/api"
+)
+
+// RenderTemplate writes the destination only when the rendered content, the
+// mode or the owner differ from what is already there and reports whether it
+// did
+func RenderTemplate(config *vault.Config, token string, home string, conf TemplateConfig) (bool, error) {
+	source := strings.ReplaceAll(conf.Source, HOME, home)
+	dest := strings.ReplaceAll(conf.Destination, HOME, home)
+
+	content, err := ioutil.ReadFile(source)
+	if err != nil {
+		return false, err
+	}
+
+	// every secret is read once per render, even if the template uses several
+	// keys. The name may ask for a version like the configurations do.
+	secrets := make(map[string]map[string]interface{})
+	funcs := template.FuncMap{
+		"secret": func(mount string, name string, key string) (string, error) {
+			path := mount + "/" + name
+			data, ok := secrets[path]
+			if !ok {
+				var err error
+				data, err = ReadKV(config, token, mount, name)
+				if err != nil {
+					return "", err
+				}
+				secrets[path] = data
+			}
+			value, ok := data[key]
+			if !ok {
+				return "", errors.New(ERROR_TEMPLATE_KEY + path + " " + key)
+			}
+			return fmt.Sprint(value), nil
+		},
+	}
+
+	tmpl, err := template.New(conf.Name).Funcs(funcs).Option("missingkey=error").Parse(string(content))
+	if err != nil {
+		return false, err
+	}
+	var buffer bytes.Buffer
+	err = tmpl.Execute(&buffer, nil)
+	if err != nil {
+		return false, err
+	}
+
+	mode, err := templateMode(conf.Mode)
+	if err != nil {
+		return false, err
+	}
+	uid, gid, err := templateOwner(conf.Owner)
+	if err != nil {
+		return false, err
+	}
+	if templateUnchanged(dest, buffer.Bytes(), mode, uid, gid) {
+		return false, nil
+	}
+	return true, writeAtomic(dest, buffer.Bytes(), mode, uid, gid)
+}
+
+func templateUnchanged(dest string, content []byte, mode os.FileMode, uid int, gid int) bool {
+	info, err := os.Stat(dest)
+	if err != nil || info.Mode().Perm() != mode.Perm() {
+		return false
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || (uid != -1 && int(stat.Uid) != uid) || (gid != -1 && int(stat.Gid) != gid) {
+		return false
+	}
+	current, err := ioutil.ReadFile(dest)
+	return err == nil && bytes.Equal(current, content)
+}
+
+// secrets should not be readable by others unless asked for
+func templateMode(mode string) (os.FileMode, error) {
+	if mode == "" {
+		return 0600, nil
+	}
+	m, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil {
+		return 0, errors.New(ERROR_TEMPLATE_MODE + mode)
+	}
+	return os.FileMode(m), nil
+}
+
+// owner is either user or user:group, -1 keeps the owner of the agent
+func templateOwner(owner string) (int, int, error) {
+	if owner == "" {
+		return -1, -1, nil
+	}
+	name := owner
+	group := ""
+	if i := strings.Index(owner, ":"); i >= 0 {
+		name = owner[:i]
+		group = owner[i+1:]
+	}
+
+	u, err := user.Lookup(name)
+	if err != nil {
+		return 0, 0, errors.New(ERROR_TEMPLATE_OWNER + owner + " " + err.Error())
+	}
+	gidString := u.Gid
+	if group != "" {
+		g, err := user.LookupGroup(group)
+		if err != nil {
+			return 0, 0, errors.New(ERROR_TEMPLATE_OWNER + owner + " " + err.Error())
+		}
+		gidString = g.Gid
+	}
+
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return 0, 0, err
+	}
+	gid, err := strconv.Atoi(gidString)
+	if err != nil {
+		return 0, 0, err
+	}
+	return uid, gid, nil
+}
+
+// the content goes to a temporary file next to the destination which is
+// renamed over it, readers never see a half written file
+func writeAtomic(dest string, content []byte, mode os.FileMode, uid int, gid int) error {
+	f, err := ioutil.TempFile(filepath.Dir(dest), "."+filepath.Base(dest)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.Write(content)
+	if err == nil {
+		err = f.Chmod(mode)
+	}
+	if err == nil && (uid != -1 || gid != -1) {
+		err = f.Chown(uid, gid)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), dest)
+}
+
+func TemplateCommand(home string, conf TemplateConfig) *exec.Cmd {
+	cmd := exec.Command("sh", "-c", conf.Command)
+	cmd.Dir = filepath.Dir(strings.ReplaceAll(conf.Destination, HOME, home))
+	cmd.Env = append(os.Environ(),
+		TEMPLATE_NAME+"="+conf.Name,
+		TEMPLATE_DEST+"="+strings.ReplaceAll(conf.Destination, HOME, home),
+	)
+	return cmd
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTemplateRender(t *testing.T) {
+	fmt.Println("running: TestTemplateRender")
+	t.Cleanup(clear)
+	testconfig := readConfig(t)
+	home, err := ioutil.TempDir("", "agent-template")
+	require.NoError(t, err)
+	defer os.RemoveAll(home)
+	current, err := user.Current()
+	require.NoError(t, err)
+
+	require.NoError(t, ioutil.WriteFile(home+"/app.tmpl", []byte(
+		"password={{ secret \"restic\" \"resticpath\" \"pw\" }}\nrepo={{ secret \"restic\" \"resticpath\" \"repo\" }}\n"), 0600))
+	conf := TemplateConfig{
+		Name:        "app",
+		Source:      "~/app.tmpl",
+		Destination: "~/app.conf",
+		Mode:        "0640",
+		Owner:       current.Username,
+	}
+
+	changed, err := RenderTemplate(testconfig.config, VAULT_TEST_TOKEN, home, conf)
+	require.NoError(t, err)
+	assert.True(t, changed)
+	content, err := ioutil.ReadFile(home + "/app.conf")
+	require.NoError(t, err)
+	assert.Equal(t, "password="+VAULT_TEST_PASSWORD+"\nrepo="+VAULT_TEST_BACKUP_PATH+"\n", string(content))
+	info, err := os.Stat(home + "/app.conf")
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0640), info.Mode().Perm())
+
+	// nothing changed, nothing is written
+	changed, err = RenderTemplate(testconfig.config, VAULT_TEST_TOKEN, home, conf)
+	require.NoError(t, err)
+	assert.False(t, changed)
+
+	// a changed mode is written again
+	require.NoError(t, os.Chmod(home+"/app.conf", 0644))
+	changed, err = RenderTemplate(testconfig.config, VAULT_TEST_TOKEN, home, conf)
+	require.NoError(t, err)
+	assert.True(t, changed)
+	info, err = os.Stat(home + "/app.conf")
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0640), info.Mode().Perm())
+
+	require.NoError(t, ioutil.WriteFile(home+"/app.conf", []byte("edited\n"), 0600))
+	changed, err = RenderTemplate(testconfig.config, VAULT_TEST_TOKEN, home, conf)
+	require.NoError(t, err)
+	assert.True(t, changed)
+
+	// errors keep the old file
+	require.NoError(t, ioutil.WriteFile(home+"/app.tmpl", []byte("{{ secret \"restic\" \"resticpath\" \"missing\" }}"), 0600))
+	_, err = RenderTemplate(testconfig.config, VAULT_TEST_TOKEN, home, conf)
+	assert.Error(t, err)
+	content, err = ioutil.ReadFile(home + "/app.conf")
+	require.NoError(t, err)
+	assert.Contains(t, string(content), "password=")
+
+	require.NoError(t, ioutil.WriteFile(home+"/app.tmpl", []byte("static\n"), 0600))
+	conf.Mode = "rw-r--r--"
+	_, err = RenderTemplate(testconfig.config, VAULT_TEST_TOKEN, home, conf)
+	assert.Error(t, err)
+
+	files, err := ioutil.ReadDir(home)
+	require.NoError(t, err)
+	assert.Len(t, files, 2)
+}
+
+func TestTemplateOwner(t *testing.T) {
+	fmt.Println("running: TestTemplateOwner")
+	current, err := user.Current()
+	require.NoError(t, err)
+	group, err := user.LookupGroupId(current.Gid)
+	require.NoError(t, err)
+	uid, err := strconv.Atoi(current.Uid)
+	require.NoError(t, err)
+	gid, err := strconv.Atoi(current.Gid)
+	require.NoError(t, err)
+
+	u, g, err := templateOwner("")
+	require.NoError(t, err)
+	assert.Equal(t, -1, u)
+	assert.Equal(t, -1, g)
+
+	u, g, err = templateOwner(current.Username)
+	require.NoError(t, err)
+	assert.Equal(t, uid, u)
+	assert.Equal(t, gid, g)
+
+	u, g, err = templateOwner(current.Username + ":" + group.Name)
+	require.NoError(t, err)
+	assert.Equal(t, uid, u)
+	assert.Equal(t, gid, g)
+
+	_, _, err = templateOwner("agent-missing-user")
+	assert.Error(t, err)
+}
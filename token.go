@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v3"
+	vault "github.com/hashicorp/vault/api"
+)
+
+// tokenLock serializes logins and renewals and guards the renewal timer
+var tokenLock sync.Mutex
+
+type VaultToken struct {
+	Token     string        `json:"token"`
+	Accessor  string        `json:"accessor"`
+	Policies  []string      `json:"policies"`
+	Renewable bool          `json:"renewable"`
+	Lease     time.Duration `json:"lease"`
+	Expire    time.Time     `json:"expire"`
+	Method    string        `json:"method"`
+}
+
+// TokenStatus is what /status shows of the token, it never holds the secret
+type TokenStatus struct {
+	Policies  []string  `json:"policies"`
+	TTL       string    `json:"ttl"`
+	Expire    time.Time `json:"expire"`
+	Renewable bool      `json:"renewable"`
+}
+
+func newVaultToken(auth *vault.SecretAuth) VaultToken {
+	lease := time.Duration(auth.LeaseDuration) * time.Second
+	return VaultToken{
+		Token:     auth.ClientToken,
+		Accessor:  auth.Accessor,
+		Policies:  auth.Policies,
+		Renewable: auth.Renewable,
+		Lease:     lease,
+		Expire:    time.Now().Add(lease),
+	}
+}
+
+// a token without lease never expires
+func (t VaultToken) TTL() time.Duration {
+	if t.Lease == 0 {
+		return 0
+	}
+	return time.Until(t.Expire)
+}
+
+// renewal starts once half of the lease is used up
+func (t VaultToken) renewAt() time.Time {
+	return t.Expire.Add(-t.Lease / 2)
+}
+
+func (t VaultToken) needsRenewal() bool {
+	return t.Lease != 0 && time.Now().After(t.renewAt())
+}
+
+func (t VaultToken) Status() TokenStatus {
+	return TokenStatus{
+		Policies:  t.Policies,
+		TTL:       t.TTL().Round(time.Second).String(),
+		Expire:    t.Expire,
+		Renewable: t.Renewable,
+	}
+}
+
+func PutToken(db *badger.DB, token VaultToken) (bool, error) {
+	value, err := json.Marshal(token)
+	if err != nil {
+		return false, err
+	}
+	if token.Lease == 0 {
+		return Put(db, STORE_TOKEN, string(value))
+	}
+	// badger drops the token together with the lease
+	return PutWithTTL(db, STORE_TOKEN, string(value), time.Until(token.Expire))
+}
+
+func GetToken(db *badger.DB) (VaultToken, error) {
+	var token VaultToken
+	value, err := Get(db, STORE_TOKEN)
+	if err != nil {
+		return token, err
+	}
+	err = json.Unmarshal([]byte(value), &token)
+	return token, err
+}
+
+func GetTokenStatus(db *badger.DB) (*TokenStatus, error) {
+	token, err := GetToken(db)
+	if err != nil {
+		return nil, err
+	}
+	status := token.Status()
+	return &status, nil
+}
+
+// GetVaultToken returns the stored token, renews it once half of the lease is
+// used up and only logs in again when there is no token or the renewal failed.
+// The token of a Vault Agent is neither stored nor renewed, the agent keeps
+// the file up to date.
+func GetVaultToken(db *badger.DB, config *vault.Config, method VaultAuth) (VaultToken, error) {
+	if file, ok := method.(TokenFileAuth); ok {
+		token, err := file.Token()
+		return VaultToken{Token: token}, err
+	}
+
+	token, err := GetToken(db)
+	stored := err == nil && token.Method == authKey(method)
+	if stored && !token.needsRenewal() {
+		return token, nil
+	}
+
+	if stored && token.Renewable {
+		auth, err := RenewToken(config, token.Token)
+		if err == nil {
+			renewed := newVaultToken(auth)
+			renewed.Method = token.Method
+			if renewed.Accessor == "" {
+				renewed.Accessor = token.Accessor
+			}
+			Sugar.Info(MESSAGE_TOKEN_RENEWED, renewed.TTL().Round(time.Second))
+			storeToken(db, renewed)
+			return renewed, nil
+		}
+		Sugar.Warn(ERROR_TOKEN_RENEW, err)
+	}
+
+	if method == nil {
+		return VaultToken{}, errors.New(ERROR_VAULT_LOGIN)
+	}
+	auth, err := method.Login(config)
+	if err != nil {
+		return VaultToken{}, err
+	}
+	token = newVaultToken(auth)
+	token.Method = authKey(method)
+	Sugar.Info(MESSAGE_TOKEN_LOGIN, token.TTL().Round(time.Second))
+	storeToken(db, token)
+	return token, nil
+}
+
+func storeToken(db *badger.DB, token VaultToken) {
+	_, err := PutToken(db, token)
+	if err != nil {
+		Sugar.Error(ERROR_PUT_TOKEN, err)
+	}
+}
+
+// deniedToken drops the stored token once vault denied it, a revoked token
+// is answered the same way. The next request logs in again.
+func deniedToken(token string, err error) error {
+	var response *vault.ResponseError
+	if token == "" || !errors.As(err, &response) || response.StatusCode != http.StatusForbidden {
+		return err
+	}
+	tokenLock.Lock()
+	defer tokenLock.Unlock()
+	stored, getErr := GetToken(AgentConfiguration.DB)
+	if getErr != nil || stored.Token != token {
+		return err
+	}
+	Sugar.Warn(MESSAGE_TOKEN_DENIED)
+	removeErr := Remove(AgentConfiguration.DB, STORE_TOKEN)
+	if removeErr != nil {
+		Sugar.Error(ERROR_DROP_TOKEN, removeErr)
+	}
+	return err
+}
+
+// the timer renews the token even if nothing else needs vault in between,
+// it is only touched with tokenLock held
+func scheduleTokenRenewal(token VaultToken) {
+	if token.Lease == 0 {
+		return
+	}
+	if AgentConfiguration.TokenTimer != nil {
+		AgentConfiguration.TokenTimer.Stop()
+	}
+	AgentConfiguration.TokenTimer = time.AfterFunc(time.Until(token.renewAt()), func() {
+		checkRequirements()
+	})
+}
+
+func stopTokenRenewal() {
+	tokenLock.Lock()
+	defer tokenLock.Unlock()
+	if AgentConfiguration.TokenTimer != nil {
+		AgentConfiguration.TokenTimer.Stop()
+	}
+}
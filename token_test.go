@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	vault "github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenGetVaultToken(t *testing.T) {
+	fmt.Println("running: TestTokenGetVaultToken")
+	t.Cleanup(clear)
+	t.Cleanup(func() { renewFailing = false })
+	testconfig := readConfig(t)
+	db := InitDB("", "", true)
+	defer db.Close()
+	logins := loginCount
+	renewals := renewCount
+	auth := AppRoleAuth{RoleID: VAULT_TEST_ROLE_ID, SecretID: VAULT_TEST_SECRET_ID}
+
+	token, err := GetVaultToken(db, testconfig.config, auth)
+	require.NoError(t, err)
+	assert.Equal(t, VAULT_TEST_TOKEN, token.Token)
+	assert.Equal(t, "accessorid", token.Accessor)
+	assert.Equal(t, []string{"default", "secret access"}, token.Policies)
+	assert.Equal(t, time.Hour, token.Lease)
+	assert.Equal(t, logins+1, loginCount)
+
+	// the stored token is used as long as it is fresh
+	token, err = GetVaultToken(db, testconfig.config, auth)
+	require.NoError(t, err)
+	assert.Equal(t, VAULT_TEST_TOKEN, token.Token)
+	assert.Equal(t, logins+1, loginCount)
+	assert.Equal(t, renewals, renewCount)
+
+	// more than half of the lease is used up
+	token.Expire = time.Now().Add(10 * time.Minute)
+	_, err = PutToken(db, token)
+	require.NoError(t, err)
+	token, err = GetVaultToken(db, testconfig.config, auth)
+	require.NoError(t, err)
+	assert.Equal(t, 2*time.Hour, token.Lease)
+	assert.True(t, token.TTL() > time.Hour)
+	assert.Equal(t, logins+1, loginCount)
+	assert.Equal(t, renewals+1, renewCount)
+
+	stored, err := GetToken(db)
+	require.NoError(t, err)
+	assert.Equal(t, token.Expire.Unix(), stored.Expire.Unix())
+
+	// a failed renewal falls back to a new login
+	renewFailing = true
+	stored.Expire = time.Now().Add(time.Minute)
+	_, err = PutToken(db, stored)
+	require.NoError(t, err)
+	token, err = GetVaultToken(db, testconfig.config, auth)
+	require.NoError(t, err)
+	assert.Equal(t, time.Hour, token.Lease)
+	assert.Equal(t, logins+2, loginCount)
+	assert.Equal(t, renewals+1, renewCount)
+
+	// the token of another auth method is not used
+	token, err = GetVaultToken(db, testconfig.config, UserpassAuth{Username: VAULT_TEST_USERNAME, Password: VAULT_TEST_PASSWORD})
+	require.NoError(t, err)
+	assert.Equal(t, AUTH_USERPASS+":"+VAULT_TEST_USERNAME, token.Method)
+	token, err = GetVaultToken(db, testconfig.config, auth)
+	require.NoError(t, err)
+	assert.Equal(t, AUTH_APPROLE+":"+VAULT_TEST_ROLE_ID, token.Method)
+	assert.Equal(t, logins+3, loginCount)
+}
+
+func TestTokenGetVaultTokenFile(t *testing.T) {
+	fmt.Println("running: TestTokenGetVaultTokenFile")
+	db := InitDB("", "", true)
+	defer db.Close()
+	dir, err := ioutil.TempDir("", "agent-auth")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	tokenFile := dir + "/token"
+	require.NoError(t, ioutil.WriteFile(tokenFile, []byte(VAULT_TEST_AGENT_TOKEN+"\n"), 0600))
+
+	// the file is read on every call and nothing is stored
+	auth := TokenFileAuth{Path: tokenFile}
+	token, err := GetVaultToken(db, nil, auth)
+	require.NoError(t, err)
+	assert.Equal(t, VAULT_TEST_AGENT_TOKEN, token.Token)
+	_, err = GetToken(db)
+	assert.Error(t, err)
+
+	require.NoError(t, ioutil.WriteFile(tokenFile, []byte("rotated\n"), 0600))
+	token, err = GetVaultToken(db, nil, auth)
+	require.NoError(t, err)
+	assert.Equal(t, "rotated", token.Token)
+}
+
+func TestTokenDeniedToken(t *testing.T) {
+	fmt.Println("running: TestTokenDeniedToken")
+	AgentConfiguration.DB = InitDB("", "", true)
+	t.Cleanup(func() {
+		AgentConfiguration.DB.Close()
+		AgentConfiguration.DB = nil
+	})
+	_, err := PutToken(AgentConfiguration.DB, VaultToken{Token: VAULT_TEST_TOKEN})
+	require.NoError(t, err)
+
+	// other errors and other tokens keep the stored token
+	notFound := &vault.ResponseError{StatusCode: 404}
+	assert.Equal(t, notFound, deniedToken(VAULT_TEST_TOKEN, notFound))
+	denied := &vault.ResponseError{StatusCode: 403}
+	assert.Equal(t, denied, deniedToken("another token", denied))
+	_, err = GetToken(AgentConfiguration.DB)
+	assert.NoError(t, err)
+
+	assert.Equal(t, denied, deniedToken(VAULT_TEST_TOKEN, denied))
+	_, err = GetToken(AgentConfiguration.DB)
+	assert.Error(t, err)
+}
+
+func TestTokenStatus(t *testing.T) {
+	fmt.Println("running: TestTokenStatus")
+	db := InitDB("", "", true)
+	defer db.Close()
+
+	_, err := GetTokenStatus(db)
+	assert.Error(t, err)
+
+	token := VaultToken{
+		Token:     VAULT_TEST_TOKEN,
+		Accessor:  "accessorid",
+		Policies:  []string{"default"},
+		Renewable: true,
+		Lease:     time.Hour,
+		Expire:    time.Now().Add(30 * time.Minute),
+	}
+	_, err = PutToken(db, token)
+	require.NoError(t, err)
+
+	status, err := GetTokenStatus(db)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"default"}, status.Policies)
+	assert.True(t, status.Renewable)
+	ttl, err := time.ParseDuration(status.TTL)
+	require.NoError(t, err)
+	assert.InDelta(t, 30*time.Minute, ttl, float64(time.Minute))
+
+	value, err := json.Marshal(status)
+	require.NoError(t, err)
+	assert.NotContains(t, string(value), VAULT_TEST_TOKEN)
+	assert.NotContains(t, string(value), "accessorid")
+
+	// the token is dropped once it expired
+	token.Expire = time.Now().Add(time.Second)
+	_, err = PutToken(db, token)
+	require.NoError(t, err)
+	time.Sleep(1500 * time.Millisecond)
+	_, err = GetToken(db)
+	assert.Error(t, err)
+}
@@ -2,23 +2,54 @@ package main
 
 import (
 	"errors"
+	"sync"
 
 	vault "github.com/hashicorp/vault/api"
 )
 
+var clients = make(map[*vault.Config]*vault.Client)
+var clientLock sync.Mutex
+
+// getClient shares one client per config, the callers get a clone carrying
+// their token so concurrent calls with different tokens don't interfere
+func getClient(config *vault.Config, token string) (*vault.Client, error) {
+	clientLock.Lock()
+	client, ok := clients[config]
+	if !ok {
+		var err error
+		client, err = vault.NewClient(config)
+		if err != nil {
+			clientLock.Unlock()
+			return nil, err
+		}
+		clients[config] = client
+	}
+	clientLock.Unlock()
+
+	clone, err := client.Clone()
+	if err != nil {
+		return nil, err
+	}
+	if token == "" {
+		clone.ClearToken()
+	} else {
+		clone.SetToken(token)
+	}
+	return clone, nil
+}
+
 func Seal(config *vault.Config, token string) error {
-	client, err := vault.NewClient(config)
+	client, err := getClient(config, token)
 	if err != nil {
 		return err
 	}
-	client.SetToken(token)
 
 	sys := client.Sys()
-	return sys.Seal()
+	return deniedToken(token, sys.Seal())
 }
 
 func Unseal(config *vault.Config, key string) (*vault.SealStatusResponse, error) {
-	client, err := vault.NewClient(config)
+	client, err := getClient(config, "")
 	if err != nil {
 		return nil, err
 	}
@@ -32,7 +63,7 @@ func Unseal(config *vault.Config, key string) (*vault.SealStatusResponse, error)
 }
 
 func SealStatus(config *vault.Config) (*vault.SealStatusResponse, error) {
-	client, err := vault.NewClient(config)
+	client, err := getClient(config, "")
 	if err != nil {
 		return nil, err
 	}
@@ -47,7 +78,7 @@ func SealStatus(config *vault.Config) (*vault.SealStatusResponse, error) {
 }
 
 func IsSealed(config *vault.Config) (bool, error) {
-	client, err := vault.NewClient(config)
+	client, err := getClient(config, "")
 	if err != nil {
 		return true, err
 	}
@@ -60,46 +91,31 @@ func IsSealed(config *vault.Config) (bool, error) {
 	return respones.Sealed, nil
 }
 func GetSecret(config *vault.Config, token string, path string) (*vault.Secret, error) {
-	client, err := vault.NewClient(config)
+	client, err := getClient(config, token)
 	if err != nil {
 		return nil, err
 	}
-	client.SetToken(token)
 
 	logical := client.Logical()
 	secret, err := logical.Read(path)
 	if err != nil {
-		return nil, err
+		return nil, deniedToken(token, err)
 	}
 	return secret, nil
 }
 
-func getDataFromSecret(config *vault.Config, token string, path string) (map[string]interface{}, error) {
-	Sugar.Debug("Getting Data from: ", path)
-	secret, err := GetSecret(config, token, path)
+func Login(config *vault.Config, role_id string, secret_id string) (string, error) {
+	auth, err := LoginAuth(config, role_id, secret_id)
 	if err != nil {
-		return nil, err
-	}
-
-	if secret == nil || secret.Data == nil {
-		return nil, errors.New(ERROR_VAULT_NO_SECRET)
-	}
-
-	if _, ok := secret.Data["data"]; ok {
-		data := secret.Data["data"].(map[string]interface{})
-		if len(data) == 0 {
-			return nil, errors.New("Data of secret with path: " + path + " is empty")
-		}
-		return data, nil
-	} else {
-		return secret.Data, nil
+		return "", err
 	}
+	return auth.ClientToken, nil
 }
 
-func Login(config *vault.Config, role_id string, secret_id string) (string, error) {
-	client, err := vault.NewClient(config)
+func LoginAuth(config *vault.Config, role_id string, secret_id string) (*vault.SecretAuth, error) {
+	client, err := getClient(config, "")
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	// to pass the password
@@ -110,10 +126,56 @@ func Login(config *vault.Config, role_id string, secret_id string) (string, erro
 
 	// PUT call to get a token
 	secret, err := client.Logical().Write("auth/approle/login", options)
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil || secret.Auth == nil {
+		return nil, errors.New(ERROR_VAULT_NO_AUTH)
+	}
+	return secret.Auth, nil
+}
+
+func RenewToken(config *vault.Config, token string) (*vault.SecretAuth, error) {
+	client, err := getClient(config, token)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := client.Auth().Token().RenewSelf(0)
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil || secret.Auth == nil {
+		return nil, errors.New(ERROR_VAULT_NO_AUTH)
+	}
+	return secret.Auth, nil
+}
+
+func RevokeToken(config *vault.Config, token string) error {
+	client, err := getClient(config, token)
+	if err != nil {
+		return err
+	}
+	return client.Auth().Token().RevokeSelf("")
+}
+
+// the wrapping token is its own auth, unwrapping consumes it
+func UnwrapSecretID(config *vault.Config, wrappingToken string) (string, error) {
+	client, err := getClient(config, "")
 	if err != nil {
 		return "", err
 	}
 
-	token := secret.Auth.ClientToken
-	return token, nil
+	secret, err := client.Logical().Unwrap(wrappingToken)
+	if err != nil {
+		return "", err
+	}
+	if secret == nil || secret.Data == nil {
+		return "", errors.New(ERROR_VAULT_NO_SECRET)
+	}
+	secretID, ok := secret.Data["secret_id"].(string)
+	if !ok || secretID == "" {
+		return "", errors.New(ERROR_VAULT_NO_SECRET)
+	}
+	return secretID, nil
 }
@@ -4,6 +4,7 @@ import (
 	"context"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
@@ -18,6 +19,13 @@ var running bool = false
 var sealStatus bool = false
 var multipleKey bool = false
 var forbidden bool = false
+var renewFailing bool = false
+var loginCount = 0
+var renewCount = 0
+var revokeCount = 0
+var wrappingUsed bool = false
+var withTemplate bool = false
+var statusDocs = make(map[string]interface{})
 
 var Progress = 0
 var Hostname string
@@ -78,9 +86,27 @@ func createHandler() http.Handler {
 	})
 	r.GET("/v1/gocrypt/data/random-config-path", test_gocrypt)
 	r.GET("/v1/gocrypt/data/gocryptpath", test_gocrypt)
+	r.GET("/v1/gocrypt/data/reversepath", test_gocrypt_reverse)
 	r.GET("/v1/git/data/gitpath", test_git)
 	r.GET("/v1/git/data/vimrc", test_vimrc)
+	r.GET("/v1/template/data/apptemplate", test_template)
 	r.PUT("/v1/auth/approle/login", test_login)
+	r.PUT("/v1/auth/token/renew-self", test_renew)
+	r.GET("/v1/auth/token/lookup-self", test_lookup_self)
+	r.PUT("/v1/auth/token/revoke-self", func(c *gin.Context) {
+		Sugar.Info("MOCK-Server: called revoke-self")
+		revokeCount++
+		c.Status(http.StatusNoContent)
+	})
+	r.PUT("/v1/auth/cert/login", test_cert_login)
+	r.PUT("/v1/auth/userpass/login/:name", test_userpass_login)
+	r.PUT("/v1/sys/wrapping/unwrap", test_unwrap)
+	r.GET("/v1/sys/internal/ui/mounts/*mount", test_ui_mounts)
+	r.GET("/v1/kv1/:name", test_kv1)
+	r.GET("/v1/kv2/data/*name", test_kv2)
+	r.PUT("/v1/agent-status/data/:host", test_status_write)
+	r.GET("/v1/agent-status/data/:host", test_status_read)
+	r.GET("/v1/agent-status/metadata/", test_status_list)
 	return r
 }
 
@@ -100,6 +126,21 @@ func test_gocrypt(c *gin.Context) {
 	c.JSON(http.StatusOK, msg)
 }
 
+func test_gocrypt_reverse(c *gin.Context) {
+	Sugar.Info("MOCK-Server: called gocrypt reverse")
+	var msg vault.Secret
+	data := make(map[string]interface{})
+	secret := make(map[string]interface{})
+
+	secret["path"] = GOCRYPT_TEST_REVERSE_FOLDER
+	secret["mount-path"] = GOCRYPT_TEST_REVERSE_MOUNTPATH
+	secret["pw"] = VAULT_TEST_PASSWORD
+	secret["reverse"] = "true"
+	data["data"] = secret
+	msg.Data = data
+	c.JSON(http.StatusOK, msg)
+}
+
 func test_config(c *gin.Context) {
 	Sugar.Info("MOCK-Server: called config")
 	var msg vault.Secret
@@ -117,19 +158,39 @@ func test_config(c *gin.Context) {
 	}
 	data["gocryptfs"] = VAULT_TEST_CONFIGPATH
 	data["git"] = "gitpath,vimrc"
+	if withTemplate {
+		data["template"] = "apptemplate"
+	}
 	data["home"] = pwd
 	msg.Data = data
 	c.JSON(http.StatusOK, msg)
 }
 
+func test_template(c *gin.Context) {
+	Sugar.Info("MOCK-Server: called template")
+	var msg vault.Secret
+	data := make(map[string]interface{})
+	secret := make(map[string]interface{})
+
+	secret["source"] = TEMPLATE_TEST_SOURCE
+	secret["dest"] = TEMPLATE_TEST_DEST
+	secret["mode"] = "0640"
+	secret["command"] = "echo $" + TEMPLATE_NAME + " >> rendered"
+	data["data"] = secret
+	msg.Data = data
+	c.JSON(http.StatusOK, msg)
+}
+
 func test_vimrc(c *gin.Context) {
 	Sugar.Info("MOCK-Server: called git")
 	var msg vault.Secret
 	data := make(map[string]interface{})
+	secret := make(map[string]interface{})
 
-	data["repo"] = GIT_TEST_REPO_VIMRC
-	data["dir"] = GIT_TEST_FOLDER_VIMRC
-	data["personal_token"] = ""
+	secret["repo"] = GIT_TEST_REPO_VIMRC
+	secret["dir"] = GIT_TEST_FOLDER_VIMRC
+	secret["personal_token"] = ""
+	data["data"] = secret
 	msg.Data = data
 	c.JSON(http.StatusOK, msg)
 }
@@ -138,10 +199,12 @@ func test_git(c *gin.Context) {
 	Sugar.Info("MOCK-Server: called git")
 	var msg vault.Secret
 	data := make(map[string]interface{})
+	secret := make(map[string]interface{})
 
-	data["repo"] = GIT_TEST_REPO
-	data["dir"] = GIT_TEST_FOLDER
-	data["personal_token"] = ""
+	secret["repo"] = GIT_TEST_REPO
+	secret["dir"] = GIT_TEST_FOLDER
+	secret["personal_token"] = ""
+	data["data"] = secret
 	msg.Data = data
 	c.JSON(http.StatusOK, msg)
 }
@@ -204,6 +267,9 @@ func test_restic(c *gin.Context) {
 	secret["path"] = "~/"
 	secret["repo"] = VAULT_TEST_BACKUP_PATH
 	secret["pw"] = VAULT_TEST_PASSWORD
+	if c.Query("version") == "1" {
+		secret["pw"] = VAULT_TEST_OLD_PASSWORD
+	}
 	secret["exclude"] = VAULT_TEST_BACKUP_EXCLUDE_FILE
 	secret["access_key"] = VAULT_TEST_BACKUP_ACCESS_KEY
 	secret["secret_key"] = VAULT_TEST_BACKUP_SECRET_KEY
@@ -214,6 +280,139 @@ func test_restic(c *gin.Context) {
 
 func test_login(c *gin.Context) {
 	Sugar.Info("MOCK-Server: called login")
+	var body map[string]interface{}
+	if err := c.BindJSON(&body); err != nil || body["secret_id"] != VAULT_TEST_SECRET_ID {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": []string{"invalid secret id"}})
+		return
+	}
+	loginCount++
 	msg := "{\"request_id\":\"requestid\",\"lease_id\":\"\",\"renewable\":false,\"lease_duration\":0,\"data\":null,\"wrap_info\":null,\"warnings\":null,\"auth\":{\"client_token\":\"" + VAULT_TEST_TOKEN + "\",\"accessor\":\"accessorid\",\"policies\":[\"default\",\"secret access\"],\"token_policies\":[\"default\",\"secret access\"],\"metadata\":{\"role_name\":\"agent\"},\"lease_duration\":3600,\"renewable\":true,\"entity_id\":\"entity_id\",\"token_type\":\"service\",\"orphan\":true}}"
 	c.String(http.StatusOK, msg)
 }
+
+func test_renew(c *gin.Context) {
+	Sugar.Info("MOCK-Server: called renew-self")
+	if renewFailing || c.GetHeader("X-Vault-Token") != VAULT_TEST_TOKEN {
+		c.JSON(http.StatusForbidden, gin.H{"errors": []string{"permission denied"}})
+		return
+	}
+	renewCount++
+	c.String(http.StatusOK, testAuthResponse(VAULT_TEST_TOKEN, 7200))
+}
+
+func test_lookup_self(c *gin.Context) {
+	Sugar.Info("MOCK-Server: called lookup-self")
+	if c.GetHeader("X-Vault-Token") != VAULT_TEST_AGENT_TOKEN {
+		c.JSON(http.StatusForbidden, gin.H{"errors": []string{"permission denied"}})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"data": gin.H{
+			"id":        VAULT_TEST_AGENT_TOKEN,
+			"accessor":  "agentaccessor",
+			"policies":  []string{"default", "agent"},
+			"renewable": true,
+			"ttl":       1800,
+		},
+	})
+}
+
+func test_cert_login(c *gin.Context) {
+	Sugar.Info("MOCK-Server: called cert login")
+	if c.Request.TLS != nil && len(c.Request.TLS.PeerCertificates) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": []string{"client certificate must be supplied"}})
+		return
+	}
+	var body map[string]interface{}
+	if err := c.BindJSON(&body); err != nil || body["name"] != VAULT_TEST_CERT_ROLE {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": []string{"invalid certificate or no client certificate supplied"}})
+		return
+	}
+	c.String(http.StatusOK, testAuthResponse(VAULT_TEST_TOKEN, 3600))
+}
+
+func test_userpass_login(c *gin.Context) {
+	Sugar.Info("MOCK-Server: called userpass login")
+	var body map[string]interface{}
+	if err := c.BindJSON(&body); err != nil || c.Param("name") != VAULT_TEST_USERNAME || body["password"] != VAULT_TEST_PASSWORD {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": []string{"invalid username or password"}})
+		return
+	}
+	c.String(http.StatusOK, testAuthResponse(VAULT_TEST_TOKEN, 3600))
+}
+
+func test_unwrap(c *gin.Context) {
+	Sugar.Info("MOCK-Server: called unwrap")
+	if wrappingUsed || c.GetHeader("X-Vault-Token") != VAULT_TEST_WRAPPING_TOKEN {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": []string{"wrapping token is not valid or does not exist"}})
+		return
+	}
+	wrappingUsed = true
+	c.JSON(http.StatusOK, gin.H{
+		"data": gin.H{
+			"secret_id":          VAULT_TEST_SECRET_ID,
+			"secret_id_accessor": "secretaccessor",
+		},
+	})
+}
+
+// config and kv1 are version 1 mounts like on older vaults
+func test_ui_mounts(c *gin.Context) {
+	Sugar.Info("MOCK-Server: called ui mounts")
+	mount := strings.TrimPrefix(c.Param("mount"), "/")
+	if strings.HasPrefix(mount, "kv2/") {
+		mount = "kv2"
+	}
+	switch mount {
+	case "config", "kv1":
+		c.JSON(http.StatusOK, gin.H{"data": gin.H{"type": "kv", "path": mount + "/", "options": nil}})
+	case "restic", "gocrypt", "git", "template", "kv2", "agent-status":
+		c.JSON(http.StatusOK, gin.H{"data": gin.H{"type": "kv", "path": mount + "/", "options": gin.H{"version": "2"}}})
+	default:
+		c.JSON(http.StatusNotFound, gin.H{"errors": []string{}})
+	}
+}
+
+func test_kv1(c *gin.Context) {
+	Sugar.Info("MOCK-Server: called kv1")
+	c.JSON(http.StatusOK, gin.H{"data": gin.H{"name": c.Param("name"), "data": "not nested"}})
+}
+
+func test_kv2(c *gin.Context) {
+	Sugar.Info("MOCK-Server: called kv2")
+	c.JSON(http.StatusOK, gin.H{"data": gin.H{"data": gin.H{"path": strings.TrimPrefix(c.Param("name"), "/")}}})
+}
+
+func test_status_write(c *gin.Context) {
+	Sugar.Info("MOCK-Server: called status write")
+	var body map[string]interface{}
+	err := c.BindJSON(&body)
+	if err != nil {
+		return
+	}
+	statusDocs[c.Param("host")] = body["data"]
+	c.JSON(http.StatusOK, gin.H{"data": gin.H{"version": 1}})
+}
+
+func test_status_read(c *gin.Context) {
+	Sugar.Info("MOCK-Server: called status read")
+	doc, ok := statusDocs[c.Param("host")]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"errors": []string{}})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": gin.H{"data": doc}})
+}
+
+func test_status_list(c *gin.Context) {
+	Sugar.Info("MOCK-Server: called status list")
+	keys := []string{}
+	for k := range statusDocs {
+		keys = append(keys, k)
+	}
+	c.JSON(http.StatusOK, gin.H{"data": gin.H{"keys": keys}})
+}
+
+func testAuthResponse(token string, lease int) string {
+	return "{\"request_id\":\"requestid\",\"lease_id\":\"\",\"renewable\":false,\"lease_duration\":0,\"data\":null,\"wrap_info\":null,\"warnings\":null,\"auth\":{\"client_token\":\"" + token + "\",\"accessor\":\"accessorid\",\"policies\":[\"default\",\"secret access\"],\"token_policies\":[\"default\",\"secret access\"],\"metadata\":{\"role_name\":\"agent\"},\"lease_duration\":" + strconv.Itoa(lease) + ",\"renewable\":true,\"entity_id\":\"entity_id\",\"token_type\":\"service\",\"orphan\":true}}"
+}
@@ -1,8 +1,23 @@
 package main
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
 	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http/httptest"
+	"os"
 	"testing"
+	"time"
+
+	vault "github.com/hashicorp/vault/api"
+	"github.com/spf13/viper"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -98,3 +113,157 @@ func TestVaultLogin(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, VAULT_TEST_TOKEN, token)
 }
+
+type testCert struct {
+	cert     *x509.Certificate
+	key      *ecdsa.PrivateKey
+	certFile string
+	keyFile  string
+}
+
+// signs with the parent or itself when there is none
+func writeTestCert(t *testing.T, dir string, name string, parent *testCert, template x509.Certificate) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.Subject = pkix.Name{CommonName: name}
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+
+	signer, signerKey := &template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, signer, &key.PublicKey, signerKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	c := &testCert{cert: cert, key: key, certFile: dir + "/" + name + ".crt", keyFile: dir + "/" + name + ".key"}
+	require.NoError(t, ioutil.WriteFile(c.certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, ioutil.WriteFile(c.keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+	return c
+}
+
+func startTLSServer(t *testing.T, dir string) (*httptest.Server, *testCert, *testCert) {
+	ca := writeTestCert(t, dir, "ca", nil, x509.Certificate{
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	})
+	// the certificate only holds a name, connecting by ip needs the server name
+	server := writeTestCert(t, dir, "server", ca, x509.Certificate{
+		DNSNames:    []string{VAULT_TEST_TLS_SERVER_NAME},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	client := writeTestCert(t, dir, "client", ca, x509.Certificate{
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+
+	pair, err := tls.LoadX509KeyPair(server.certFile, server.keyFile)
+	require.NoError(t, err)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+
+	ts := httptest.NewUnstartedServer(createHandler())
+	ts.TLS = &tls.Config{
+		Certificates: []tls.Certificate{pair},
+		ClientCAs:    pool,
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+	}
+	ts.StartTLS()
+	t.Cleanup(ts.Close)
+	return ts, ca, client
+}
+
+func TestVaultTLS(t *testing.T) {
+	fmt.Println("Testing: TestVaultTLS")
+	t.Cleanup(clear)
+	readConfig(t)
+	dir, err := ioutil.TempDir("", "agent-tls")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	ts, ca, client := startTLSServer(t, dir)
+
+	certArgs := []string{"--vault_client_cert=" + client.certFile, "--vault_client_key=" + client.keyFile}
+	tests := []struct {
+		name string
+		args []string
+		ok   bool
+		cert bool
+	}{
+		{"no ca", []string{"--vault_tls_server_name=" + VAULT_TEST_TLS_SERVER_NAME}, false, false},
+		{"wrong server name", []string{"--vault_ca_cert=" + ca.certFile}, false, false},
+		{"ca and server name", []string{"--vault_ca_cert=" + ca.certFile, "--vault_tls_server_name=" + VAULT_TEST_TLS_SERVER_NAME}, true, false},
+		{"skip verify", []string{"--vault_tls_skip_verify=true"}, true, false},
+		{"client cert", append([]string{"--vault_ca_cert=" + ca.certFile, "--vault_tls_server_name=" + VAULT_TEST_TLS_SERVER_NAME}, certArgs...), true, true},
+	}
+
+	for _, test := range tests {
+		args := append([]string{
+			"--vault_address=" + ts.URL,
+			"--vault_auth=" + AUTH_USERPASS,
+			"--vault_username=" + VAULT_TEST_USERNAME,
+			"--vault_password=" + VAULT_TEST_PASSWORD,
+		}, test.args...)
+		viper.Reset()
+		config := vault.DefaultConfig()
+		config.MaxRetries = 0
+		err := Init(config, args)
+		require.NoError(t, err, test.name)
+
+		_, err = SealStatus(AgentConfiguration.VaultConfig)
+		if !test.ok {
+			assert.Error(t, err, test.name)
+			continue
+		}
+		assert.NoError(t, err, test.name)
+
+		_, err = AgentConfiguration.Auth.Login(AgentConfiguration.VaultConfig)
+		assert.NoError(t, err, test.name)
+
+		// the mock refuses the cert login without a client certificate
+		_, err = CertAuth{Role: VAULT_TEST_CERT_ROLE}.Login(AgentConfiguration.VaultConfig)
+		if test.cert {
+			assert.NoError(t, err, test.name)
+		} else {
+			assert.Error(t, err, test.name)
+		}
+	}
+
+	viper.Reset()
+	err = Init(vault.DefaultConfig(), []string{
+		"--vault_address=" + ts.URL,
+		"--vault_auth=" + AUTH_CERT,
+		"--vault_client_cert=" + client.certFile,
+		"--vault_client_key=" + dir + "/missing.key",
+	})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), MAIN_ERROR_TLS)
+}
+
+func TestVaultSharedClient(t *testing.T) {
+	fmt.Println("Testing: TestVaultSharedClient")
+	config := &vault.Config{Address: "http://localhost:2200"}
+
+	first, err := getClient(config, "first")
+	require.NoError(t, err)
+	second, err := getClient(config, "")
+	require.NoError(t, err)
+	assert.Equal(t, "first", first.Token())
+	assert.Equal(t, "", second.Token())
+
+	clientLock.Lock()
+	shared := clients[config]
+	clientLock.Unlock()
+	require.NotNil(t, shared)
+	assert.Equal(t, "", shared.Token())
+
+	_, err = getClient(config, "third")
+	require.NoError(t, err)
+	clientLock.Lock()
+	assert.Same(t, shared, clients[config])
+	clientLock.Unlock()
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+
+	badger "github.com/dgraph-io/badger/v3"
+	vault "github.com/hashicorp/vault/api"
+)
+
+type VaultAuth interface {
+	Login(config *vault.Config) (*vault.SecretAuth, error)
+}
+
+// AppRoleAuth unwraps WrappedSecretID on the first login when SecretID is
+// not set
+type AppRoleAuth struct {
+	RoleID          string
+	SecretID        string
+	WrappedSecretID string
+}
+
+// TokenFileAuth reads the token a Vault Agent keeps up to date
+type TokenFileAuth struct {
+	Path string
+}
+
+// CertAuth needs the client certificate configured with the Vault TLS options
+type CertAuth struct {
+	Role string
+}
+
+type UserpassAuth struct {
+	Username string
+	Password string
+}
+
+func GetVaultAuth(config Configuration) (VaultAuth, error) {
+	switch config.AuthMethod {
+	case "", AUTH_APPROLE:
+		if config.RoleID == "" || (config.SecretID == "" && config.WrappedSecretID == "") {
+			return nil, errors.New(ERROR_VAULT_AUTH_MISSING + MAIN_VAULT_ROLE_ID + ", " + MAIN_VAULT_SECRET_ID + " or " + MAIN_VAULT_WRAPPED_SECRET_ID)
+		}
+		if config.SecretID == "" && !config.DBKey.IsSet() {
+			return nil, errors.New(ERROR_VAULT_WRAPPING_KEY)
+		}
+		return AppRoleAuth{RoleID: config.RoleID, SecretID: config.SecretID, WrappedSecretID: config.WrappedSecretID}, nil
+	case AUTH_TOKEN_FILE:
+		if config.TokenFile == "" {
+			return nil, errors.New(ERROR_VAULT_AUTH_MISSING + MAIN_VAULT_TOKEN_FILE)
+		}
+		return TokenFileAuth{Path: config.TokenFile}, nil
+	case AUTH_CERT:
+		if config.ClientCert == "" || config.ClientKey == "" {
+			return nil, errors.New(ERROR_VAULT_AUTH_MISSING + MAIN_VAULT_CLIENT_CERT + ", " + MAIN_VAULT_CLIENT_KEY)
+		}
+		return CertAuth{Role: config.CertRole}, nil
+	case AUTH_USERPASS:
+		if config.Username == "" || config.Password == "" {
+			return nil, errors.New(ERROR_VAULT_AUTH_MISSING + MAIN_VAULT_USERNAME + ", " + MAIN_VAULT_PASSWORD)
+		}
+		return UserpassAuth{Username: config.Username, Password: config.Password}, nil
+	default:
+		return nil, errors.New(ERROR_VAULT_AUTH + config.AuthMethod)
+	}
+}
+
+// authKey names the auth method and the identity it logs in as, a stored
+// token is only used by the same one
+func authKey(method VaultAuth) string {
+	switch a := method.(type) {
+	case AppRoleAuth:
+		return AUTH_APPROLE + ":" + a.RoleID
+	case CertAuth:
+		return AUTH_CERT + ":" + a.Role
+	case UserpassAuth:
+		return AUTH_USERPASS + ":" + a.Username
+	default:
+		return ""
+	}
+}
+
+func (a AppRoleAuth) Login(config *vault.Config) (*vault.SecretAuth, error) {
+	secretID := a.SecretID
+	if secretID == "" {
+		var err error
+		secretID, err = unwrapSecretID(AgentConfiguration.DB, config, a.WrappedSecretID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return LoginAuth(config, a.RoleID, secretID)
+}
+
+// the secret IDs unwrapped by this run by the hash of their wrapping token,
+// a login keeps working if the database could not store them
+var unwrapped = make(map[string]string)
+var unwrappedLock sync.Mutex
+
+// the wrapping token stays in the unit file after the first start, so the
+// unwrapped secret ID is kept together with a hash of the token it came from.
+// Vault refusing a token the agent never unwrapped means someone else did.
+// The secret ID is only kept in an encrypted database.
+func unwrapSecretID(db *badger.DB, config *vault.Config, wrappingToken string) (string, error) {
+	if !AgentConfiguration.DBKey.IsSet() {
+		return "", errors.New(ERROR_VAULT_WRAPPING_KEY)
+	}
+	sum := sha256.Sum256([]byte(wrappingToken))
+	hash := hex.EncodeToString(sum[:])
+	unwrappedLock.Lock()
+	defer unwrappedLock.Unlock()
+	if secretID, ok := unwrapped[hash]; ok {
+		return secretID, nil
+	}
+	stored, err := Get(db, STORE_WRAPPING_TOKEN)
+	if err == nil && stored == hash {
+		secretID, err := Get(db, STORE_SECRET_ID)
+		if err == nil && secretID != "" {
+			return secretID, nil
+		}
+	}
+
+	secretID, err := UnwrapSecretID(config, wrappingToken)
+	if err != nil {
+		var resp *vault.ResponseError
+		if errors.As(err, &resp) && resp.StatusCode == http.StatusBadRequest {
+			RecordEvent(EVENT_LEVEL_ERROR, EVENT_SOURCE_SECURITY, VAULT_MESSAGE_WRAPPING_USED)
+			return "", errors.New(ERROR_VAULT_WRAPPING_USED + err.Error())
+		}
+		return "", err
+	}
+
+	// the wrapping token is used up, so the secret ID is used for this run
+	// even if it can not be kept for the next one
+	unwrapped[hash] = secretID
+	_, err = Put(db, STORE_SECRET_ID, secretID)
+	if err == nil {
+		_, err = Put(db, STORE_WRAPPING_TOKEN, hash)
+	}
+	if err != nil {
+		Sugar.Error(ERROR_PUT_SECRET_ID, err)
+	}
+	return secretID, nil
+}
+
+func (a TokenFileAuth) Token() (string, error) {
+	content, err := ioutil.ReadFile(a.Path)
+	if err != nil {
+		return "", err
+	}
+	token := strings.TrimSpace(string(content))
+	if token == "" {
+		return "", errors.New(ERROR_VAULT_TOKEN_FILE + a.Path)
+	}
+	return token, nil
+}
+
+// the token is looked up to learn its TTL and policies
+func (a TokenFileAuth) Login(config *vault.Config) (*vault.SecretAuth, error) {
+	token, err := a.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := getClient(config, token)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := client.Auth().Token().LookupSelf()
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil || secret.Data == nil {
+		return nil, errors.New(ERROR_VAULT_NO_AUTH)
+	}
+
+	auth := &vault.SecretAuth{ClientToken: token}
+	auth.Accessor, err = secret.TokenAccessor()
+	if err != nil {
+		return nil, err
+	}
+	auth.Policies, err = secret.TokenPolicies()
+	if err != nil {
+		return nil, err
+	}
+	auth.Renewable, err = secret.TokenIsRenewable()
+	if err != nil {
+		return nil, err
+	}
+	ttl, err := secret.TokenTTL()
+	if err != nil {
+		return nil, err
+	}
+	auth.LeaseDuration = int(ttl.Seconds())
+	return auth, nil
+}
+
+// the client certificate is part of the TLS configuration of the shared client
+func (a CertAuth) Login(config *vault.Config) (*vault.SecretAuth, error) {
+	client, err := getClient(config, "")
+	if err != nil {
+		return nil, err
+	}
+
+	options := map[string]interface{}{}
+	if a.Role != "" {
+		options["name"] = a.Role
+	}
+	secret, err := client.Logical().Write("auth/cert/login", options)
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil || secret.Auth == nil {
+		return nil, errors.New(ERROR_VAULT_NO_AUTH)
+	}
+	return secret.Auth, nil
+}
+
+func (a UserpassAuth) Login(config *vault.Config) (*vault.SecretAuth, error) {
+	client, err := getClient(config, "")
+	if err != nil {
+		return nil, err
+	}
+
+	options := map[string]interface{}{
+		"password": a.Password,
+	}
+	secret, err := client.Logical().Write("auth/userpass/login/"+a.Username, options)
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil || secret.Auth == nil {
+		return nil, errors.New(ERROR_VAULT_NO_AUTH)
+	}
+	return secret.Auth, nil
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVaultAuthGetVaultAuth(t *testing.T) {
+	fmt.Println("running: TestVaultAuthGetVaultAuth")
+	tests := []struct {
+		name   string
+		config Configuration
+		auth   VaultAuth
+	}{
+		{"default approle", Configuration{RoleID: "role", SecretID: "secret"}, AppRoleAuth{RoleID: "role", SecretID: "secret"}},
+		{"approle without secret", Configuration{AuthMethod: AUTH_APPROLE, RoleID: "role"}, nil},
+		{"approle with wrapped secret", Configuration{AuthMethod: AUTH_APPROLE, RoleID: "role", WrappedSecretID: "wrapped", DBKey: DBKeyConfig{Key: STORE_TEST_KEY}}, AppRoleAuth{RoleID: "role", WrappedSecretID: "wrapped"}},
+		{"approle with wrapped secret without database key", Configuration{AuthMethod: AUTH_APPROLE, RoleID: "role", WrappedSecretID: "wrapped"}, nil},
+		{"token file", Configuration{AuthMethod: AUTH_TOKEN_FILE, TokenFile: "token"}, TokenFileAuth{Path: "token"}},
+		{"token file without path", Configuration{AuthMethod: AUTH_TOKEN_FILE}, nil},
+		{"cert", Configuration{AuthMethod: AUTH_CERT, CertRole: "role", ClientCert: "crt", ClientKey: "key"}, CertAuth{Role: "role"}},
+		{"cert without key", Configuration{AuthMethod: AUTH_CERT, ClientCert: "crt"}, nil},
+		{"userpass", Configuration{AuthMethod: AUTH_USERPASS, Username: "user", Password: "pw"}, UserpassAuth{Username: "user", Password: "pw"}},
+		{"userpass without password", Configuration{AuthMethod: AUTH_USERPASS, Username: "user"}, nil},
+		{"unknown", Configuration{AuthMethod: "ldap"}, nil},
+	}
+
+	for _, test := range tests {
+		auth, err := GetVaultAuth(test.config)
+		if test.auth == nil {
+			assert.Error(t, err, test.name)
+			continue
+		}
+		assert.NoError(t, err, test.name)
+		assert.Equal(t, test.auth, auth, test.name)
+	}
+}
+
+func TestVaultAuthLogin(t *testing.T) {
+	fmt.Println("running: TestVaultAuthLogin")
+	t.Cleanup(clear)
+	testconfig := readConfig(t)
+	dir, err := ioutil.TempDir("", "agent-auth")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	tokenFile := dir + "/token"
+	require.NoError(t, ioutil.WriteFile(tokenFile, []byte(VAULT_TEST_AGENT_TOKEN+"\n"), 0600))
+
+	auth, err := AppRoleAuth{RoleID: VAULT_TEST_ROLE_ID, SecretID: VAULT_TEST_SECRET_ID}.Login(testconfig.config)
+	require.NoError(t, err)
+	assert.Equal(t, VAULT_TEST_TOKEN, auth.ClientToken)
+
+	auth, err = TokenFileAuth{Path: tokenFile}.Login(testconfig.config)
+	require.NoError(t, err)
+	assert.Equal(t, VAULT_TEST_AGENT_TOKEN, auth.ClientToken)
+	assert.Equal(t, "agentaccessor", auth.Accessor)
+	assert.Equal(t, []string{"default", "agent"}, auth.Policies)
+	assert.Equal(t, 1800, auth.LeaseDuration)
+	assert.True(t, auth.Renewable)
+
+	require.NoError(t, ioutil.WriteFile(tokenFile, []byte("\n"), 0600))
+	_, err = TokenFileAuth{Path: tokenFile}.Login(testconfig.config)
+	assert.Error(t, err)
+	_, err = TokenFileAuth{Path: dir + "/missing"}.Login(testconfig.config)
+	assert.Error(t, err)
+
+	auth, err = CertAuth{Role: VAULT_TEST_CERT_ROLE}.Login(testconfig.config)
+	require.NoError(t, err)
+	assert.Equal(t, VAULT_TEST_TOKEN, auth.ClientToken)
+	_, err = CertAuth{Role: "other"}.Login(testconfig.config)
+	assert.Error(t, err)
+
+	auth, err = UserpassAuth{Username: VAULT_TEST_USERNAME, Password: VAULT_TEST_PASSWORD}.Login(testconfig.config)
+	require.NoError(t, err)
+	assert.Equal(t, VAULT_TEST_TOKEN, auth.ClientToken)
+	_, err = UserpassAuth{Username: VAULT_TEST_USERNAME, Password: "wrong"}.Login(testconfig.config)
+	assert.Error(t, err)
+}
+
+func TestVaultAuthInit(t *testing.T) {
+	fmt.Println("running: TestVaultAuthInit")
+	t.Cleanup(clear)
+	testconfig := readConfig(t)
+
+	err := Init(testconfig.config, []string{"--vault_auth=" + AUTH_USERPASS, "--vault_username=" + VAULT_TEST_USERNAME, "--vault_password=" + VAULT_TEST_PASSWORD})
+	require.NoError(t, err)
+	assert.Equal(t, UserpassAuth{Username: VAULT_TEST_USERNAME, Password: VAULT_TEST_PASSWORD}, AgentConfiguration.Auth)
+
+	err = Init(testconfig.config, []string{"--vault_auth=" + AUTH_CERT})
+	assert.EqualError(t, err, MAIN_ERROR_LOGIN)
+
+	os.Setenv("AGENT_VAULT_AUTH", AUTH_TOKEN_FILE)
+	os.Setenv("AGENT_VAULT_TOKEN_FILE", "/run/agent/token")
+	defer os.Unsetenv("AGENT_VAULT_AUTH")
+	defer os.Unsetenv("AGENT_VAULT_TOKEN_FILE")
+	err = Init(testconfig.config, nil)
+	require.NoError(t, err)
+	assert.Equal(t, TokenFileAuth{Path: "/run/agent/token"}, AgentConfiguration.Auth)
+}
+
+func TestVaultAuthWrappedSecretID(t *testing.T) {
+	fmt.Println("running: TestVaultAuthWrappedSecretID")
+	t.Cleanup(clear)
+	testconfig := readConfig(t)
+	AgentConfiguration.DB = InitDB("", "", true)
+	t.Cleanup(func() {
+		AgentConfiguration.DB.Close()
+		AgentConfiguration.DB = nil
+		AgentConfiguration.DBKey = DBKeyConfig{}
+		wrappingUsed = false
+		unwrapped = make(map[string]string)
+	})
+	wrappingUsed = false
+
+	// without a database key the wrapping token is not touched
+	auth := AppRoleAuth{RoleID: VAULT_TEST_ROLE_ID, WrappedSecretID: VAULT_TEST_WRAPPING_TOKEN}
+	_, err := auth.Login(testconfig.config)
+	assert.EqualError(t, err, ERROR_VAULT_WRAPPING_KEY)
+	assert.False(t, wrappingUsed)
+
+	AgentConfiguration.DBKey = DBKeyConfig{Key: STORE_TEST_KEY}
+	secret, err := auth.Login(testconfig.config)
+	require.NoError(t, err)
+	assert.Equal(t, VAULT_TEST_TOKEN, secret.ClientToken)
+	assert.True(t, wrappingUsed)
+	stored, err := Get(AgentConfiguration.DB, STORE_SECRET_ID)
+	require.NoError(t, err)
+	assert.Equal(t, VAULT_TEST_SECRET_ID, stored)
+
+	// a restart with the same wrapping token uses the stored secret ID
+	unwrapped = make(map[string]string)
+	secret, err = auth.Login(testconfig.config)
+	require.NoError(t, err)
+	assert.Equal(t, VAULT_TEST_TOKEN, secret.ClientToken)
+
+	// somebody else unwrapped the token first
+	unwrapped = make(map[string]string)
+	require.NoError(t, Remove(AgentConfiguration.DB, STORE_WRAPPING_TOKEN))
+	_, err = auth.Login(testconfig.config)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), ERROR_VAULT_WRAPPING_USED)
+
+	events, err := GetEvents(AgentConfiguration.DB, 0)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, EVENT_SOURCE_SECURITY, events[0].Source)
+	assert.Equal(t, VAULT_MESSAGE_WRAPPING_USED, events[0].Message)
+}
+
+func TestVaultAuthWrappedSecretIDNotStored(t *testing.T) {
+	fmt.Println("running: TestVaultAuthWrappedSecretIDNotStored")
+	t.Cleanup(clear)
+	testconfig := readConfig(t)
+	AgentConfiguration.DB = InitDB("", "", true)
+	AgentConfiguration.DBKey = DBKeyConfig{Key: STORE_TEST_KEY}
+	t.Cleanup(func() {
+		AgentConfiguration.DB = nil
+		AgentConfiguration.DBKey = DBKeyConfig{}
+		wrappingUsed = false
+		unwrapped = make(map[string]string)
+	})
+	wrappingUsed = false
+
+	// the database fails after the wrapping token was used up
+	require.NoError(t, AgentConfiguration.DB.Close())
+	closed = true
+	t.Cleanup(func() { closed = false })
+
+	auth := AppRoleAuth{RoleID: VAULT_TEST_ROLE_ID, WrappedSecretID: VAULT_TEST_WRAPPING_TOKEN}
+	secret, err := auth.Login(testconfig.config)
+	require.NoError(t, err)
+	assert.Equal(t, VAULT_TEST_TOKEN, secret.ClientToken)
+	assert.True(t, wrappingUsed)
+
+	// the next login of this run does not unwrap again
+	secret, err = auth.Login(testconfig.config)
+	require.NoError(t, err)
+	assert.Equal(t, VAULT_TEST_TOKEN, secret.ClientToken)
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	cmap "github.com/orcaman/concurrent-map"
+)
+
+var mountmap cmap.ConcurrentMap
+
+// ManagedMount is shared by the watchdog and every run of DoMount, lock
+// guards all of its fields
+type ManagedMount struct {
+	lock     sync.Mutex
+	Config   GocryptConfig
+	Home     string
+	Attempts int
+	Healthy  int
+}
+
+// RegisterMount keeps the counters of a mount which is already watched, the
+// next run of the scheduler must not lift the cap on remounts
+func RegisterMount(home string, conf GocryptConfig) {
+	if mountmap == nil {
+		mountmap = cmap.New()
+	}
+
+	// the password is fetched from vault again on a remount
+	conf.Password = ""
+	mountmap.Upsert(conf.Name, nil, func(exist bool, current interface{}, _ interface{}) interface{} {
+		if exist {
+			m := current.(*ManagedMount)
+			m.lock.Lock()
+			m.Config = conf
+			m.Home = home
+			m.lock.Unlock()
+			return m
+		}
+		return &ManagedMount{Config: conf, Home: home}
+	})
+}
+
+func (m *ManagedMount) get() (GocryptConfig, string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.Config, m.Home
+}
+
+// a flapping mount must not lift the cap on remounts, so the attempts are
+// only forgotten after the mount stayed healthy for a while
+func (m *ManagedMount) healthy() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.Healthy++
+	if m.Healthy >= WATCHDOG_HEALTHY_CHECKS {
+		m.Attempts = 0
+	}
+}
+
+func IsStale(home string, name string) bool {
+	path := strings.ReplaceAll(name, HOME, home)
+	_, err := os.Stat(path)
+	return isStaleError(err)
+}
+
+// a mount point whose fuse process died answers with ENOTCONN on linux
+// and ENXIO on darwin
+func isStaleError(err error) bool {
+	return errors.Is(err, syscall.ENOTCONN) || errors.Is(err, syscall.ENXIO)
+}
+
+// LazyUnmount works for every fuse filesystem, even if its process is gone
+func LazyUnmount(folder string, home string) *exec.Cmd {
+	folder = strings.ReplaceAll(folder, HOME, home)
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "darwin" {
+		cmd = exec.Command("umount", "-f", folder)
+	} else {
+		cmd = exec.Command("fusermount", "-u", "-z", folder)
+	}
+	cmd.Env = os.Environ()
+	return cmd
+}
+
+func StartWatchdog() {
+	if AgentConfiguration.WatchdogDuration <= 0 {
+		Sugar.Info(WATCHDOG_MESSAGE_DISABLED)
+		return
+	}
+	AgentConfiguration.WatchdogTimer = time.AfterFunc(AgentConfiguration.WatchdogDuration, watchdog)
+}
+
+func watchdog() {
+	CheckMounts()
+	AgentConfiguration.WatchdogTimer = time.AfterFunc(AgentConfiguration.WatchdogDuration, watchdog)
+}
+
+func CheckMounts() {
+	if mountmap == nil {
+		return
+	}
+
+	for item := range mountmap.IterBuffered() {
+		m := item.Val.(*ManagedMount)
+		conf, home := m.get()
+		mounter, err := GetMounter(conf.Type)
+		if err != nil {
+			Sugar.Error(ERROR_WATCHDOG_STATUS, conf.Name, " ", err)
+			continue
+		}
+		status, err := mounter.Status(home, conf)
+		if err != nil {
+			Sugar.Error(ERROR_WATCHDOG_STATUS, conf.Name, " ", err)
+			continue
+		}
+		if !status.Stale {
+			m.healthy()
+			continue
+		}
+		HandleStaleMount(m)
+	}
+}
+
+func HandleStaleMount(m *ManagedMount) {
+	m.lock.Lock()
+	conf, home := m.Config, m.Home
+	attempts := m.Attempts
+	m.Healthy = 0
+	if attempts < WATCHDOG_MAX_REMOUNTS+1 {
+		m.Attempts++
+	}
+	m.lock.Unlock()
+
+	name := conf.Name
+	if attempts >= WATCHDOG_MAX_REMOUNTS {
+		if attempts == WATCHDOG_MAX_REMOUNTS {
+			RecordEvent(EVENT_LEVEL_ERROR, EVENT_SOURCE_WATCHDOG, WATCHDOG_MESSAGE_GIVE_UP+name)
+		}
+		return
+	}
+	Sugar.Warn(WATCHDOG_MESSAGE_STALE, name)
+
+	err := unmountStale(conf, home)
+	if err != nil {
+		Sugar.Error(ERROR_WATCHDOG_UNMOUNT, name, " ", err)
+	}
+
+	err = remount(conf, home)
+	attempt := strconv.Itoa(attempts+1) + "/" + strconv.Itoa(WATCHDOG_MAX_REMOUNTS)
+	if err != nil {
+		RecordEvent(EVENT_LEVEL_ERROR, EVENT_SOURCE_WATCHDOG, WATCHDOG_MESSAGE_REMOUNT_FAILED+name+" ("+attempt+"): "+err.Error())
+		return
+	}
+	RecordEvent(EVENT_LEVEL_WARN, EVENT_SOURCE_WATCHDOG, WATCHDOG_MESSAGE_REMOUNTED+name+" ("+attempt+")")
+}
+
+// the filesystem gets the first try, a mount point whose process is gone is
+// only released by a lazy unmount
+func unmountStale(conf GocryptConfig, home string) error {
+	mounter, err := GetMounter(conf.Type)
+	if err == nil {
+		job := CreateJobFromCommand(mounter.Unmount(home, conf), "unmount "+conf.Name)
+		err = job.RunJob(false)
+		if err == nil {
+			return nil
+		}
+		Sugar.Debug(ERROR_WATCHDOG_UNMOUNT, conf.Name, " ", err, " ", job.Stderr.String())
+	}
+
+	job := CreateJobFromCommand(LazyUnmount(conf.MountPoint, home), "lazy unmount "+conf.Name)
+	err = job.RunJob(false)
+	if err != nil {
+		return errors.New(err.Error() + " " + job.Stderr.String())
+	}
+	return nil
+}
+
+func remount(managed GocryptConfig, home string) error {
+	token, ok := checkRequirements()
+	if !ok {
+		return errors.New(ERROR_WATCHDOG_LOGIN)
+	}
+
+	conf, err := GetGocryptConfig(AgentConfiguration.VaultConfig, token, managed.Name)
+	if err != nil {
+		return err
+	}
+	err = conf.ApplyDefaults(AgentConfiguration.MountDuration, AgentConfiguration.MountAllow)
+	if err != nil {
+		return err
+	}
+
+	cmd, err := mount(home, *conf)
+	if err != nil {
+		return err
+	}
+	job := CreateJobFromCommand(cmd, "remount "+managed.Name)
+	err = job.RunJob(false)
+	if err != nil {
+		return errors.New(err.Error() + " " + job.Stderr.String())
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatchdogIsStale(t *testing.T) {
+	fmt.Println("running: TestWatchdogIsStale")
+	home, err := os.Getwd()
+	require.NoError(t, err)
+
+	assert.False(t, IsStale(home, "~/test"))
+	assert.False(t, IsStale(home, "~/test/not-existing"))
+
+	assert.True(t, isStaleError(&os.PathError{Op: "stat", Path: "/mnt", Err: syscall.ENOTCONN}))
+	assert.False(t, isStaleError(&os.PathError{Op: "stat", Path: "/mnt", Err: syscall.ENOENT}))
+	assert.False(t, isStaleError(nil))
+}
+
+func TestWatchdogLazyUnmount(t *testing.T) {
+	fmt.Println("running: TestWatchdogLazyUnmount")
+	home, err := os.Getwd()
+	require.NoError(t, err)
+
+	cmd := LazyUnmount(GOCRYPT_TEST_MOUNTPATH, home)
+	assert.Contains(t, cmd.String(), home+"/test/tmp-mount")
+}
+
+func TestWatchdogCheckMounts(t *testing.T) {
+	fmt.Println("running: TestWatchdogCheckMounts")
+	t.Cleanup(clear)
+	home, err := os.Getwd()
+	require.NoError(t, err)
+
+	mountmap = nil
+	RegisterMount(home, GocryptConfig{
+		Name:       VAULT_TEST_CONFIGPATH,
+		MountPoint: "~/test",
+		Password:   VAULT_TEST_PASSWORD,
+	})
+	v, ok := mountmap.Get(VAULT_TEST_CONFIGPATH)
+	require.True(t, ok)
+	m := v.(*ManagedMount)
+	assert.Empty(t, m.Config.Password)
+
+	// registering the mount again keeps the counters
+	m.Attempts = 2
+	RegisterMount(home, GocryptConfig{
+		Name:       VAULT_TEST_CONFIGPATH,
+		MountPoint: "~/test",
+		Path:       GOCRYPT_TEST_FOLDER,
+	})
+	v, ok = mountmap.Get(VAULT_TEST_CONFIGPATH)
+	require.True(t, ok)
+	assert.Same(t, m, v.(*ManagedMount))
+	assert.Equal(t, 2, m.Attempts)
+	assert.Equal(t, GOCRYPT_TEST_FOLDER, m.Config.Path)
+
+	// the attempts are only reset after the mount stayed healthy
+	for i := 0; i < WATCHDOG_HEALTHY_CHECKS-1; i++ {
+		CheckMounts()
+	}
+	assert.Equal(t, 2, m.Attempts)
+	CheckMounts()
+	assert.Equal(t, 0, m.Attempts)
+}
+
+func TestWatchdogFlappingMount(t *testing.T) {
+	fmt.Println("running: TestWatchdogFlappingMount")
+	t.Cleanup(clear)
+	setupRestrouterTest(t)
+	home, err := os.Getwd()
+	require.NoError(t, err)
+
+	m := &ManagedMount{
+		Config: GocryptConfig{
+			Name:       VAULT_TEST_CONFIGPATH,
+			MountPoint: GOCRYPT_TEST_MOUNTPATH,
+		},
+		Home: home,
+	}
+
+	// the mount goes stale again before it was healthy long enough
+	for i := 0; i < WATCHDOG_MAX_REMOUNTS+2; i++ {
+		HandleStaleMount(m)
+		for j := 0; j < WATCHDOG_HEALTHY_CHECKS-1; j++ {
+			m.healthy()
+		}
+	}
+	assert.Equal(t, WATCHDOG_MAX_REMOUNTS+1, m.Attempts)
+
+	events, err := GetEvents(AgentConfiguration.DB, 0)
+	assert.NoError(t, err)
+	require.Len(t, events, WATCHDOG_MAX_REMOUNTS+1)
+	assert.Contains(t, events[0].Message, WATCHDOG_MESSAGE_GIVE_UP)
+
+	m.healthy()
+	assert.Equal(t, 0, m.Attempts)
+}
+
+func TestWatchdogHandleStaleMount(t *testing.T) {
+	fmt.Println("running: TestWatchdogHandleStaleMount")
+	t.Cleanup(clear)
+	setupRestrouterTest(t)
+	home, err := os.Getwd()
+	require.NoError(t, err)
+
+	m := &ManagedMount{
+		Config: GocryptConfig{
+			Name:       VAULT_TEST_CONFIGPATH,
+			MountPoint: GOCRYPT_TEST_MOUNTPATH,
+		},
+		Home: home,
+	}
+
+	for i := 0; i < WATCHDOG_MAX_REMOUNTS+2; i++ {
+		HandleStaleMount(m)
+	}
+	assert.Equal(t, WATCHDOG_MAX_REMOUNTS+1, m.Attempts)
+
+	events, err := GetEvents(AgentConfiguration.DB, 0)
+	assert.NoError(t, err)
+	// one event per remount and one when giving up
+	require.Len(t, events, WATCHDOG_MAX_REMOUNTS+1)
+	assert.Contains(t, events[0].Message, WATCHDOG_MESSAGE_GIVE_UP)
+	for _, event := range events {
+		assert.Equal(t, EVENT_SOURCE_WATCHDOG, event.Source)
+	}
+
+	err = AgentConfiguration.DB.Close()
+	assert.NoError(t, err)
+}